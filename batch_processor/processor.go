@@ -3,13 +3,19 @@ package batchprocessor
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"sync"
 
 	"github.com/kp/pager/common"
 	"github.com/kp/pager/communicator"
 	"github.com/kp/pager/databases/kafka"
+	"github.com/kp/pager/metrics"
+	"github.com/kp/pager/tracing"
 	"github.com/rs/xid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -23,11 +29,15 @@ func NewBatchProcessor(ctx context.Context, audiences []common.AudienceType, mod
 	}
 }
 
+// Process publishes the audiences in batches and returns the errors of all
+// batches which could not be delivered, the outcome per batch is kept in Results
 // TODO: pageId or batchID for logging
-func (batch *BatchChannelBased) Process(ctx context.Context) (err error) {
+func (batch *BatchChannelBased) Process(ctx context.Context) error {
 	audiences := batch.Audiences
-	batchSize := 5
+	batchSize := DefaultBatchSize
 	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var errs []error
 	concurrentGoroutines := 20
 	semaphore := make(chan struct{}, concurrentGoroutines) // Adjusted to use concurrentGoroutines
 	for i := 0; i < len(audiences); i += batchSize {
@@ -39,29 +49,66 @@ func (batch *BatchChannelBased) Process(ctx context.Context) (err error) {
 		}
 		semaphore <- struct{}{}
 		wg.Add(1)
-		go func(startIndex int, batchAudience []common.AudienceType) {
+		go func(batchAudience []common.AudienceType) {
 			defer func() {
 				<-semaphore
 				wg.Done()
 			}()
-			if startIndex+batchSize < len(audiences) {
-				err = batch.sendBatchToQueue(ctx, audiences[startIndex:startIndex+batchSize])
-			} else {
-				err = batch.sendBatchToQueue(ctx, audiences[startIndex:])
-			}
-			if err != nil {
+			if err := batch.sendBatchToQueue(ctx, batchAudience); err != nil {
 				log.WithFields(log.Fields{
 					"error": err,
 				}).Errorln("CreateBatchAndSendToQueue:ErrorProcessingBatch")
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
 			}
-		}(i, batchAudience)
+		}(batchAudience)
 	}
 	wg.Wait()
-	return
+	return errors.Join(errs...)
+}
+
+// Results returns the outcome of every batch sent by Process
+func (batch *BatchChannelBased) Results() []BatchResult {
+	batch.mu.Lock()
+	defer batch.mu.Unlock()
+	results := make([]BatchResult, len(batch.results))
+	copy(results, batch.results)
+	return results
+}
+
+func (batch *BatchChannelBased) recordResult(batchID string, audiences int, err error) {
+	result := BatchResult{BatchID: batchID, Audiences: audiences}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	if batch.onResult != nil {
+		batch.onResult(result)
+		return
+	}
+	batch.mu.Lock()
+	batch.results = append(batch.results, result)
+	batch.mu.Unlock()
 }
 
 func (batch *BatchChannelBased) sendBatchToQueue(c context.Context, audiences []common.AudienceType) (err error) {
 	batchID := xid.New().String()
+	c, span := tracing.Tracer().Start(c, "batch.publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String(tracing.AttrBatchID, batchID),
+			attribute.String(tracing.AttrRequestID, batch.Model.RequestId),
+			attribute.String("messaging.destination.name", batch.TopicName),
+			attribute.Int(tracing.AttrAudiences, len(audiences)),
+		))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "publish failed")
+		}
+		span.End()
+		batch.recordResult(batchID, len(audiences), err)
+	}()
 	kafkaMessage := communicator.QMessage{
 		BatchID:      batchID,
 		Audiences:    audiences,
@@ -79,10 +126,13 @@ func (batch *BatchChannelBased) sendBatchToQueue(c context.Context, audiences []
 
 	err = batch.KafkaProducer.Publish(c, batch.TopicName, messageBytes)
 	if err != nil {
+		metrics.BatchPublishFailures.WithLabelValues(batch.TopicName).Inc()
 		log.WithFields(log.Fields{
 			"error":        err,
 			"publish_data": string(messageBytes),
 		}).Errorln("BatchFailedToPublish")
+		return
 	}
+	metrics.BatchesPublished.WithLabelValues(batch.TopicName).Inc()
 	return
 }
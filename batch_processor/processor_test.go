@@ -8,6 +8,8 @@ import (
 
 	"github.com/kp/pager/common"
 	"github.com/kp/pager/communicator"
+	"github.com/kp/pager/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
@@ -154,6 +156,45 @@ func TestProcess_Error(t *testing.T) {
 	assert.Contains(t, err.Error(), "kafka error")
 }
 
+func TestProcess_Results(t *testing.T) {
+	ctx := context.Background()
+	audiences := make([]common.AudienceType, 12)
+	for i := 0; i < 12; i++ {
+		audiences[i] = common.AudienceType{
+			Email:   string(rune(i+1)) + "@example.com",
+			Context: map[string]string{},
+		}
+	}
+
+	model := communicator.NotificationType{
+		To:         "test@example.com",
+		TemplateID: 123,
+		RequestId:  "req123",
+	}
+	topic := "results-topic"
+	producer := &MockKafkaProducer{}
+	producer.On("Publish", mock.Anything, topic, mock.Anything).Return(errors.New("kafka error")).Once()
+	producer.On("Publish", mock.Anything, topic, mock.Anything).Return(nil)
+
+	processor := NewBatchProcessor(ctx, audiences, model, topic, producer)
+	err := processor.Process(ctx)
+
+	assert.ErrorContains(t, err, "kafka error")
+	results := processor.Results()
+	assert.Len(t, results, 3)
+	failed, total := 0, 0
+	for _, result := range results {
+		assert.NotEmpty(t, result.BatchID)
+		total += result.Audiences
+		if result.Error != "" {
+			failed++
+		}
+	}
+	assert.Equal(t, 1, failed)
+	assert.Equal(t, 12, total)
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.BatchPublishFailures.WithLabelValues(topic)))
+}
+
 func TestSendBatchToQueue_Success(t *testing.T) {
 	ctx := context.Background()
 	audiences := []common.AudienceType{
@@ -167,7 +208,8 @@ func TestSendBatchToQueue_Success(t *testing.T) {
 	}
 	topic := "test-topic"
 	producer := &MockKafkaProducer{}
-	producer.On("Publish", ctx, topic, mock.Anything).Return(nil)
+	// Publish receives ctx wrapped in the batch.publish span
+	producer.On("Publish", mock.Anything, topic, mock.Anything).Return(nil)
 
 	processor := &BatchChannelBased{
 		Model:         model,
@@ -180,7 +222,7 @@ func TestSendBatchToQueue_Success(t *testing.T) {
 	assert.NoError(t, err)
 
 	// Verify the published message contains expected data
-	producer.AssertCalled(t, "Publish", ctx, topic, mock.Anything)
+	producer.AssertCalled(t, "Publish", mock.Anything, topic, mock.Anything)
 	capturedMsg := producer.Calls[0].Arguments[2].([]byte)
 
 	var msg communicator.QMessage
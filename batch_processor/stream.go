@@ -0,0 +1,116 @@
+package batchprocessor
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/kp/pager/common"
+	"github.com/kp/pager/communicator"
+	"github.com/kp/pager/databases/kafka"
+)
+
+const (
+	// streamConcurrency is how many batches a stream publishes at once, Add
+	// blocks while they are all in flight
+	streamConcurrency = 20
+	// maxStreamFailures is how many failed batches a stream keeps
+	maxStreamFailures = 100
+)
+
+// StreamBatchProcessor publishes audiences in batches while they are added,
+// so audiences which do not fit in memory can be sent. It holds one partial
+// batch and the batches being published, results are only kept for failed batches.
+type StreamBatchProcessor struct {
+	batch     *BatchChannelBased
+	pending   []common.AudienceType
+	semaphore chan struct{}
+	wg        sync.WaitGroup
+
+	mu        sync.Mutex
+	batches   int
+	published int
+	failures  []BatchResult
+	errs      []error
+}
+
+func NewStreamBatchProcessor(model communicator.NotificationType, topicName string, kafkaProducer kafka.KafkaProducer) *StreamBatchProcessor {
+	stream := &StreamBatchProcessor{
+		semaphore: make(chan struct{}, streamConcurrency),
+		pending:   make([]common.AudienceType, 0, DefaultBatchSize),
+	}
+	stream.batch = &BatchChannelBased{
+		Model:         model,
+		TopicName:     topicName,
+		KafkaProducer: kafkaProducer,
+		onResult:      stream.record,
+	}
+	return stream
+}
+
+// Add queues audience, publishing its batch once it is full
+func (s *StreamBatchProcessor) Add(ctx context.Context, audience common.AudienceType) {
+	s.pending = append(s.pending, audience)
+	if len(s.pending) == DefaultBatchSize {
+		s.flush(ctx)
+	}
+}
+
+// Close publishes the last partial batch and waits for all batches, returning
+// the errors of the batches which could not be delivered
+func (s *StreamBatchProcessor) Close(ctx context.Context) error {
+	if len(s.pending) > 0 {
+		s.flush(ctx)
+	}
+	s.wg.Wait()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return errors.Join(s.errs...)
+}
+
+// Batches returns how many batches were published and how many were delivered
+func (s *StreamBatchProcessor) Batches() (total, published int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.batches, s.published
+}
+
+// Failures returns up to maxStreamFailures batches which could not be delivered
+func (s *StreamBatchProcessor) Failures() []BatchResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	failures := make([]BatchResult, len(s.failures))
+	copy(failures, s.failures)
+	return failures
+}
+
+func (s *StreamBatchProcessor) flush(ctx context.Context) {
+	audiences := s.pending
+	s.pending = make([]common.AudienceType, 0, DefaultBatchSize)
+	s.semaphore <- struct{}{}
+	s.wg.Add(1)
+	go func() {
+		defer func() {
+			<-s.semaphore
+			s.wg.Done()
+		}()
+		if err := s.batch.sendBatchToQueue(ctx, audiences); err != nil {
+			s.mu.Lock()
+			if len(s.errs) < maxStreamFailures {
+				s.errs = append(s.errs, err)
+			}
+			s.mu.Unlock()
+		}
+	}()
+}
+
+func (s *StreamBatchProcessor) record(result BatchResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batches++
+	if result.Error == "" {
+		s.published++
+	} else if len(s.failures) < maxStreamFailures {
+		s.failures = append(s.failures, result)
+	}
+}
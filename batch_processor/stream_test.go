@@ -0,0 +1,56 @@
+package batchprocessor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/kp/pager/common"
+	"github.com/kp/pager/communicator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamBatchProcessor(t *testing.T) {
+	ctx := context.Background()
+	producer := &MockKafkaProducer{}
+	producer.On("Publish", mock.Anything, "test-topic", mock.Anything).Return(nil)
+
+	stream := NewStreamBatchProcessor(communicator.NotificationType{TemplateID: 123, RequestId: "req123"}, "test-topic", producer)
+	for i := 0; i < 2*DefaultBatchSize+1; i++ {
+		stream.Add(ctx, common.AudienceType{Email: string(rune('a'+i)) + "@example.com"})
+	}
+	require.NoError(t, stream.Close(ctx))
+
+	total, published := stream.Batches()
+	assert.Equal(t, 3, total)
+	assert.Equal(t, 3, published)
+	assert.Empty(t, stream.Failures())
+
+	audiences := 0
+	for _, call := range producer.Calls {
+		var message communicator.QMessage
+		require.NoError(t, json.Unmarshal(call.Arguments[2].([]byte), &message))
+		assert.Equal(t, "req123", message.GenericModel.RequestId)
+		audiences += len(message.Audiences)
+	}
+	assert.Equal(t, 2*DefaultBatchSize+1, audiences)
+}
+
+func TestStreamBatchProcessor_Failures(t *testing.T) {
+	ctx := context.Background()
+	producer := &MockKafkaProducer{}
+	producer.On("Publish", mock.Anything, "test-topic", mock.Anything).Return(errors.New("broker down"))
+
+	stream := NewStreamBatchProcessor(communicator.NotificationType{TemplateID: 123, RequestId: "req123"}, "test-topic", producer)
+	stream.Add(ctx, common.AudienceType{Email: "user@example.com"})
+	assert.Error(t, stream.Close(ctx))
+
+	total, published := stream.Batches()
+	assert.Equal(t, 1, total)
+	assert.Zero(t, published)
+	require.Len(t, stream.Failures(), 1)
+	assert.Equal(t, "broker down", stream.Failures()[0].Error)
+}
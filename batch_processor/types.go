@@ -2,14 +2,32 @@ package batchprocessor
 
 import (
 	"context"
+	"sync"
 
 	"github.com/kp/pager/common"
 	"github.com/kp/pager/communicator"
 	"github.com/kp/pager/databases/kafka"
 )
 
+// DefaultBatchSize is the number of audiences published in one queue message
+const DefaultBatchSize = 5
+
+// BatchCount returns how many queue messages totalAudience audiences are split into
+func BatchCount(totalAudience int) int {
+	return (totalAudience + DefaultBatchSize - 1) / DefaultBatchSize
+}
+
 type BatchProcessor interface {
 	Process(ctx context.Context) error
+	Results() []BatchResult
+}
+
+// BatchResult is the publish outcome of one batch, Error is empty once the
+// broker has confirmed delivery
+type BatchResult struct {
+	BatchID   string `json:"batch_id"`
+	Audiences int    `json:"audiences"`
+	Error     string `json:"error,omitempty"`
 }
 
 type BatchChannelBased struct {
@@ -17,4 +35,9 @@ type BatchChannelBased struct {
 	Model         communicator.NotificationType
 	Audiences     []common.AudienceType
 	KafkaProducer kafka.KafkaProducer
+
+	mu      sync.Mutex
+	results []BatchResult
+	// onResult replaces keeping the results, see StreamBatchProcessor
+	onResult func(BatchResult)
 }
@@ -4,8 +4,8 @@ import (
 	"log/slog"
 	"os"
 
-	"github.com/kp/pager/common"
 	"github.com/kp/pager/databases/sql"
+	"github.com/kp/pager/login"
 	login_models "github.com/kp/pager/login/models"
 	"github.com/spf13/cobra"
 )
@@ -18,12 +18,26 @@ var registerCmd = &cobra.Command{
 		username, _ := cmd.Flags().GetString("username")
 		password, _ := cmd.Flags().GetString("password")
 
-		encryptedPass := common.Encryptbase64(password)
+		if err := login.ValidatePassword(username, password); err != nil {
+			slog.Error("Failed to register user",
+				"error", err,
+				"username", username,
+			)
+			os.Exit(1)
+		}
+		hash, err := login.HashPassword(password)
+		if err != nil {
+			slog.Error("Failed to register user",
+				"error", err,
+				"username", username,
+			)
+			os.Exit(1)
+		}
 
 		userType, _ := cmd.Flags().GetString("usertype")
 		user := &login_models.User{
 			Username: username,
-			Password: encryptedPass,
+			Password: hash,
 			UserType: userType,
 		}
 
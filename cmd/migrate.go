@@ -1,12 +1,16 @@
 package cmd
 
 import (
+	"context"
 	"log/slog"
 
 	comm_models "github.com/kp/pager/communicator/models"
+	"github.com/kp/pager/databases/pgqueue"
 	"github.com/kp/pager/databases/sql"
+	"github.com/kp/pager/login"
 	login_models "github.com/kp/pager/login/models"
 	notification_models "github.com/kp/pager/notification/models"
+	"github.com/kp/pager/suppression"
 	"github.com/kp/pager/templates"
 	"github.com/spf13/cobra"
 )
@@ -25,14 +29,36 @@ var migrateCmd = &cobra.Command{
 func dbMigrate() {
 	// Notification system tables
 	sql.PagerOrm.AutoMigrate(&notification_models.NotificationSession{})
+	sql.PagerOrm.AutoMigrate(&notification_models.ProcessedBatch{})
+	sql.PagerOrm.AutoMigrate(&notification_models.IdempotencyKey{})
 	// Template system tables
 	sql.PagerOrm.AutoMigrate(&templates.NotificationTemplate{})
+	sql.PagerOrm.AutoMigrate(&templates.NotificationTemplateVersion{})
+	sql.PagerOrm.AutoMigrate(&templates.NotificationTemplateVariant{})
+	if err := templates.BackfillTemplateVersions(context.Background(), sql.PagerOrm); err != nil {
+		slog.Error("errorBackfillingTemplateVersions", slog.String("error", err.Error()))
+	}
 	// Communication system tables
 	sql.PagerOrm.AutoMigrate(&comm_models.CommunicationLogs{})
+	if err := comm_models.DeduplicateCommunicationLogs(context.Background(), sql.PagerOrm); err != nil {
+		slog.Error("errorDeduplicatingCommunicationLogs", slog.String("error", err.Error()))
+	}
+	// Suppression list
+	sql.PagerOrm.AutoMigrate(&suppression.Suppression{})
 	// Auth system tables
 	sql.PagerOrm.AutoMigrate(&login_models.User{})
 	sql.PagerOrm.AutoMigrate(&login_models.Permission{})
 	sql.PagerOrm.AutoMigrate(&login_models.UserPermission{})
+	sql.PagerOrm.AutoMigrate(&login_models.RefreshToken{})
+	sql.PagerOrm.AutoMigrate(&login_models.APIKey{})
+	sql.PagerOrm.AutoMigrate(&login_models.Role{})
+	sql.PagerOrm.AutoMigrate(&login_models.RolePermission{})
+	sql.PagerOrm.AutoMigrate(&login_models.UserRole{})
+	if err := login.SeedDefaultRoles(context.Background(), sql.PagerOrm); err != nil {
+		slog.Error("errorSeedingDefaultRoles", slog.String("error", err.Error()))
+	}
+	// Postgres queue backend
+	sql.PagerOrm.AutoMigrate(&pgqueue.QueueJob{})
 }
 
 func init() {
@@ -4,19 +4,32 @@ import (
 	"context"
 	"encoding/json"
 	"log/slog"
+	"net"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/kp/pager/consumers"
+	"github.com/kp/pager/communicator"
 	aws_db "github.com/kp/pager/databases/aws"
 	"github.com/kp/pager/databases/kafka"
+	"github.com/kp/pager/databases/pgqueue"
+	"github.com/kp/pager/databases/sql"
+	"github.com/kp/pager/login"
+	"github.com/kp/pager/suppression"
+	"github.com/kp/pager/tracing"
 	"github.com/spf13/cobra"
 )
 
+const (
+	queueBackendKafka    = "kafka"
+	queueBackendPostgres = "postgres"
+)
+
 var (
-	appConfig  *AppConfig
+	appConfig  = &AppConfig{}
 	envVarList = []string{
 		"AWS_ACCESS_KEY_ID",
 		"AWS_SECRET_ACCESS_KEY",
@@ -34,6 +47,40 @@ var (
 		"KAFKA_TOPIC",
 		"KAFKA_USERNAME",
 		"KAFKA_PASSWORD",
+		"KAFKA_CONSUMER_GROUP",
+		"QUEUE_BACKEND",
+		"TRUSTED_PROXIES",
+		"NOTIFICATION_PROVIDER",
+		"SMS_PROVIDER",
+		"PUSH_PROVIDER",
+		"IN_APP_PROVIDER",
+		"SMTP_HOST",
+		"SMTP_PORT",
+		"SMTP_USERNAME",
+		"SMTP_PASSWORD",
+		"SMTP_FROM",
+		"SMTP_TLS_MODE",
+		"SMTP_POOL_SIZE",
+		"RETRY_MAX_ATTEMPTS",
+		"RETRY_BASE_DELAY",
+		"RETRY_MAX_DELAY",
+		"WORKER_CONCURRENCY",
+		"WORKER_DRAIN_TIMEOUT",
+		"WORKER_METRICS_ADDR",
+		"SCHEDULER_INTERVAL",
+		"FREQUENCY_CAPS",
+		"QUIET_HOURS",
+		"DEFAULT_TIMEZONE",
+		"UNSUBSCRIBE_SECRET",
+		"UNSUBSCRIBE_BASE_URL",
+		"PASSWORD_BCRYPT_COST",
+		"PASSWORD_MIN_LENGTH",
+		"JWT_SIGNING_KEYS",
+		"JWT_ACTIVE_KEY_ID",
+		"ACCESS_TOKEN_TTL",
+		"REFRESH_TOKEN_TTL",
+		"OTEL_EXPORTER_OTLP_ENDPOINT",
+		"OTEL_SERVICE_NAME",
 	}
 	rootCmd = &cobra.Command{
 		Use:   "pager-cli",
@@ -67,21 +114,131 @@ func setupDeps() {
 		os.Exit(1)
 	}
 
-	// Initialize Kafka
-	brokers := strings.Split(appConfig.KafkaConfig.Brokers, ",")
-	err = kafka.RunMigrations(brokers) // Add this migration in CLI
-	if err != nil {
-		slog.Error("errorInitializingKafka",
+	// Initialize notification provider
+	if err := communicator.InitProvider(appConfig.ProviderConfig); err != nil {
+		slog.Error("errorInitializingNotificationProvider",
 			slog.String("error", err.Error()),
-			slog.String("brokers", appConfig.KafkaConfig.Brokers),
+			slog.String("provider", appConfig.ProviderConfig.Provider),
+		)
+		os.Exit(1)
+	}
+	if err := communicator.InitFrequencyCaps(appConfig.FrequencyCapConfig); err != nil {
+		slog.Error("errorInitializingFrequencyCaps", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	if err := communicator.InitQuietHours(appConfig.QuietHoursConfig); err != nil {
+		slog.Error("errorInitializingQuietHours", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	suppression.Init(appConfig.SuppressionConfig)
+	if err := login.InitPassword(appConfig.PasswordConfig); err != nil {
+		slog.Error("errorInitializingPasswordPolicy", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	if appConfig.JWTConfig.SigningKeys == "" && IsEnvProd() {
+		slog.Error("errorInitializingSigningKeys", slog.String("error", "JWT_SIGNING_KEYS is required in production"))
+		os.Exit(1)
+	}
+	if err := login.InitSigningKeys(appConfig.JWTConfig); err != nil {
+		slog.Error("errorInitializingSigningKeys", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	if err := login.InitTokenLifetimes(appConfig.TokenConfig); err != nil {
+		slog.Error("errorInitializingTokenLifetimes", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	// Redis holds the permission cache and revoked access tokens
+	if appConfig.RedisConfig.Host != "" {
+		redisDB, err := strconv.Atoi(appConfig.RedisConfig.DB)
+		if appConfig.RedisConfig.DB != "" && err != nil {
+			slog.Error("errorReadingRedisConfig", slog.String("error", "invalid REDIS_DB "+appConfig.RedisConfig.DB))
+			os.Exit(1)
+		}
+		login.InitRedis(redisAddr(), appConfig.RedisConfig.Password, redisDB)
+	} else {
+		slog.Warn("redisNotConfigured", slog.String("msg", "access tokens cannot be revoked before they expire"))
+	}
+
+	switch appConfig.QueueConfig.Backend {
+	case "", queueBackendKafka:
+		// Initialize Kafka
+		brokers := kafkaBrokers()
+		err = kafka.RunMigrations(brokers) // Add this migration in CLI
+		if err != nil {
+			slog.Error("errorInitializingKafka",
+				slog.String("error", err.Error()),
+				slog.String("brokers", appConfig.KafkaConfig.Brokers),
+			)
+			os.Exit(1)
+		}
+	case queueBackendPostgres:
+		// The queue_jobs table is created by dbMigrate
+	default:
+		slog.Error("errorReadingQueueConfig",
+			slog.String("queue_backend", appConfig.QueueConfig.Backend),
 		)
 		os.Exit(1)
 	}
-	// Start batch consumer
-	go consumers.StartBatchConsumer(brokers)
 	dbMigrate()
 }
 
+// initTracing sets up span export for a command, serviceName is used unless
+// OTEL_SERVICE_NAME is set. The returned function flushes pending spans.
+func initTracing(serviceName string) func() {
+	config := appConfig.TracingConfig
+	if config.ServiceName == "" {
+		config.ServiceName = serviceName
+	}
+	shutdown, err := tracing.Init(context.Background(), config)
+	if err != nil {
+		slog.Error("errorInitializingTracing", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdown(ctx); err != nil {
+			slog.Error("errorFlushingTraces", slog.String("error", err.Error()))
+		}
+	}
+}
+
+// newQueueProducer returns the producer of the configured queue backend
+func newQueueProducer() (kafka.KafkaProducer, error) {
+	if appConfig.QueueConfig.Backend == queueBackendPostgres {
+		return pgqueue.NewPostgresProducer(sql.PagerOrm), nil
+	}
+	return kafka.NewKafkaProducer(kafkaBrokers())
+}
+
+// kafkaBrokers returns the configured brokers, defaulting to a local broker
+func kafkaBrokers() []string {
+	if appConfig.KafkaConfig.Brokers == "" {
+		return []string{"localhost:9092"}
+	}
+	return strings.Split(appConfig.KafkaConfig.Brokers, ",")
+}
+
+// trustedProxies returns the configured proxies, none by default
+func trustedProxies() []string {
+	var proxies []string
+	for _, proxy := range strings.Split(appConfig.ServerConfig.TrustedProxies, ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	return proxies
+}
+
+// redisAddr returns the address of the configured redis server
+func redisAddr() string {
+	port := appConfig.RedisConfig.Port
+	if port == "" {
+		port = "6379"
+	}
+	return net.JoinHostPort(appConfig.RedisConfig.Host, port)
+}
+
 func getAppConfig(ctx context.Context) *AppConfig {
 	accessKey := os.Getenv("AWS_ACCESS_KEY_ID")
 	secretKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
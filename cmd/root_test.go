@@ -1,12 +1,18 @@
 package cmd
 
 import (
+	"bytes"
 	"context"
+	"log/slog"
 	"os"
 	"testing"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/kp/pager/communicator"
+	"github.com/kp/pager/databases/sql"
+	"github.com/kp/pager/login"
+	"github.com/kp/pager/suppression"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -103,3 +109,27 @@ func TestGetAppConfig(t *testing.T) {
 		assert.NotNil(t, config)
 	})
 }
+
+func TestAppConfigLogValue(t *testing.T) {
+	config := &AppConfig{
+		AWSConfig:          AWSConfig{AccessKey: "AKIAEXAMPLE", SecretKey: "aws-secret"},
+		DatabaseConfigType: sql.DatabaseConfigType{Host: "db.internal", Password: "db-secret"},
+		RedisConfig:        RedisConfig{Password: "redis-secret"},
+		KafkaConfig:        KafkaConfig{Password: "kafka-secret"},
+		ProviderConfig:     communicator.ProviderConfig{SMTPHost: "smtp.example.com", SMTPPassword: "smtp-secret"},
+		JWTConfig:          login.JWTConfig{SigningKeys: `[{"kid":"k1","alg":"HS256","key":"jwt-secret"}]`, ActiveKeyID: "k1"},
+		SuppressionConfig:  suppression.SuppressionConfig{Secret: "unsubscribe-secret"},
+	}
+
+	var out bytes.Buffer
+	slog.New(slog.NewJSONHandler(&out, nil)).Info("appConfig", "config", config)
+
+	logged := out.String()
+	for _, secret := range []string{"aws-secret", "db-secret", "redis-secret", "kafka-secret", "smtp-secret", "jwt-secret", "unsubscribe-secret"} {
+		assert.NotContains(t, logged, secret)
+	}
+	for _, value := range []string{"AKIAEXAMPLE", "db.internal", "smtp.example.com", "k1", redacted} {
+		assert.Contains(t, logged, value)
+	}
+	assert.Equal(t, "db-secret", config.DatabaseConfigType.Password, "the config itself is not changed")
+}
@@ -11,7 +11,9 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/kp/pager/databases/kafka"
 	"github.com/kp/pager/databases/sql"
+	"github.com/kp/pager/notification"
 	"github.com/kp/pager/server"
 	"github.com/spf13/cobra"
 )
@@ -29,16 +31,37 @@ var apiCmd = &cobra.Command{
 		templatePrefix := servicePrefix + "/template"
 		notificationPrefix := servicePrefix + "/notification"
 		loginPrefix := servicePrefix + "/user"
+		suppressionPrefix := servicePrefix + "/suppression"
+		unsubscribePrefix := servicePrefix + "/unsubscribe"
+		wellKnownPrefix := "/.well-known"
+		apiKeyPrefix := servicePrefix + "/api-key"
+		rolePrefix := servicePrefix + "/role"
+		shutdownTracing := initTracing("pager-api")
+		defer shutdownTracing()
 		middlewares := []gin.HandlerFunc{
 			server.AuthPermissionMiddleware(sql.PagerOrm),
 			server.RecoveryMiddleware(),
 		}
-		brokers := []string{"localhost:9092"} // Replace with your Kafka broker addresses
-		router := server.InitServer(middlewares, server.WithTimeOut(0*time.Second),
+		kafkaProducer, err := newQueueProducer()
+		if err != nil {
+			panic(err)
+		}
+		// Observability wraps every route once, including the ones rejected by auth
+		commonMiddlewares := append([]gin.HandlerFunc{
+			server.MetricsMiddleware(),
+			server.TracingMiddleware(),
+		}, middlewares...)
+		router := server.InitServer(commonMiddlewares, server.WithTimeOut(0*time.Second),
+			server.WithTrustedProxies(trustedProxies()),
 			server.CreateRoutes(
 				server.TemplateRouterGroup(templatePrefix, sql.PagerOrm, middlewares...),
-				server.NotificationRouterGroup(notificationPrefix, brokers, middlewares...),
+				server.NotificationRouterGroupWithProducer(notificationPrefix, kafkaProducer, middlewares...),
 				server.AuthRouterGroup(loginPrefix, sql.PagerOrm, middlewares...),
+				server.SuppressionRouterGroup(suppressionPrefix, sql.PagerOrm, middlewares...),
+				server.UnsubscribeRouterGroup(unsubscribePrefix, sql.PagerOrm, middlewares...),
+				server.WellKnownRouterGroup(wellKnownPrefix, middlewares...),
+				server.APIKeyRouterGroup(apiKeyPrefix, sql.PagerOrm, middlewares...),
+				server.RoleRouterGroup(rolePrefix, sql.PagerOrm, middlewares...),
 			),
 		)
 
@@ -52,14 +75,29 @@ var apiCmd = &cobra.Command{
 			}
 		}()
 		fmt.Println("httpServerListeningAndServingOn ", server.Addr)
+
+		// Dispatch scheduled notifications alongside the api
+		scheduler, err := notification.NewScheduler(sql.PagerOrm, kafkaProducer, appConfig.SchedulerConfig)
+		if err != nil {
+			panic(err)
+		}
+		schedulerCtx, stopScheduler := context.WithCancel(context.Background())
+		defer stopScheduler()
+		go scheduler.Run(schedulerCtx)
+
 		quit := make(chan os.Signal, 1)
 		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 		<-quit
+		stopScheduler()
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
 		if err := server.Shutdown(ctx); err != nil {
 			log.Fatal("ServerShutdown:", err)
 		}
+		// Deliver batches produced by the last requests before exiting
+		if err := kafka.CloseProducer(ctx, kafkaProducer); err != nil {
+			log.Println("ProducerFlushFailed:", err)
+		}
 		log.Println("ExitingServer...")
 	},
 }
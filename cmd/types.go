@@ -1,6 +1,16 @@
 package cmd
 
-import "github.com/kp/pager/databases/sql"
+import (
+	"log/slog"
+
+	"github.com/kp/pager/communicator"
+	"github.com/kp/pager/consumers"
+	"github.com/kp/pager/databases/sql"
+	"github.com/kp/pager/login"
+	"github.com/kp/pager/notification"
+	"github.com/kp/pager/suppression"
+	"github.com/kp/pager/tracing"
+)
 
 type AWSConfig struct {
 	AccessKey string `json:"AWS_ACCESS_KEY_ID"`
@@ -20,6 +30,20 @@ type KafkaConfig struct {
 	Topic    string `json:"KAFKA_TOPIC"`
 	Username string `json:"KAFKA_USERNAME"`
 	Password string `json:"KAFKA_PASSWORD"`
+	// ConsumerGroup is the consumer group joined by the worker command
+	ConsumerGroup string `json:"KAFKA_CONSUMER_GROUP"`
+}
+
+// QueueConfig selects the transport for notification batches, kafka or postgres
+type QueueConfig struct {
+	Backend string `json:"QUEUE_BACKEND"`
+}
+
+// ServerConfig configures the api server. TrustedProxies lists the comma
+// separated addresses or CIDR ranges of the proxies in front of it, clients
+// are identified by the X-Forwarded-For header only behind these.
+type ServerConfig struct {
+	TrustedProxies string `json:"TRUSTED_PROXIES"`
 }
 
 type AppConfig struct {
@@ -27,4 +51,40 @@ type AppConfig struct {
 	sql.DatabaseConfigType
 	RedisConfig
 	KafkaConfig
+	QueueConfig
+	ServerConfig
+	communicator.ProviderConfig
+	communicator.FrequencyCapConfig
+	communicator.QuietHoursConfig
+	consumers.RetryConfig
+	consumers.WorkerConfig
+	login.PasswordConfig
+	login.JWTConfig
+	login.TokenConfig
+	notification.SchedulerConfig
+	suppression.SuppressionConfig
+	tracing.TracingConfig
+}
+
+// redactedAppConfig is AppConfig without its LogValue
+type redactedAppConfig AppConfig
+
+const redacted = "[REDACTED]"
+
+// LogValue logs the config with its passwords, secrets and keys redacted
+func (c AppConfig) LogValue() slog.Value {
+	for _, secret := range []*string{
+		&c.AWSConfig.SecretKey,
+		&c.DatabaseConfigType.Password,
+		&c.RedisConfig.Password,
+		&c.KafkaConfig.Password,
+		&c.ProviderConfig.SMTPPassword,
+		&c.JWTConfig.SigningKeys,
+		&c.SuppressionConfig.Secret,
+	} {
+		if *secret != "" {
+			*secret = redacted
+		}
+	}
+	return slog.AnyValue(redactedAppConfig(c))
 }
@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/kp/pager/consumers"
+	"github.com/kp/pager/databases/sql"
+	"github.com/kp/pager/metrics"
+	"github.com/spf13/cobra"
+)
+
+const defaultWorkerMetricsAddr = ":9100"
+
+var workerCmd = &cobra.Command{
+	Use:   "worker",
+	Short: "Start the notification worker",
+	Long:  `Consume notification batches and retries until SIGINT or SIGTERM, then drain in-flight batches`,
+	Run: func(cmd *cobra.Command, args []string) {
+		groupID, _ := cmd.Flags().GetString("group")
+		if groupID == "" {
+			groupID = appConfig.KafkaConfig.ConsumerGroup
+		}
+		options, err := consumers.NewWorkerOptions(appConfig.WorkerConfig, groupID)
+		if err != nil {
+			slog.Error("errorReadingWorkerConfig", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+		if concurrency, _ := cmd.Flags().GetInt("concurrency"); concurrency > 0 {
+			options.Concurrency = concurrency
+		}
+		retryPolicy, err := consumers.NewRetryPolicy(appConfig.RetryConfig)
+		if err != nil {
+			slog.Error("errorReadingRetryConfig", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+
+		shutdownTracing := initTracing("pager-worker")
+		defer shutdownTracing()
+
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+
+		metricsServer := startMetricsServer(cmd, appConfig.WorkerConfig.MetricsAddr)
+		defer metricsServer.Close()
+
+		slog.Info("workerStarting",
+			slog.String("queue_backend", appConfig.QueueConfig.Backend),
+			slog.String("group", options.GroupID),
+			slog.Int("concurrency", options.Concurrency))
+		if appConfig.QueueConfig.Backend == queueBackendPostgres {
+			err = consumers.RunPostgresWorker(ctx, sql.PagerOrm, options, retryPolicy)
+		} else {
+			err = consumers.RunKafkaWorker(ctx, kafkaBrokers(), sql.PagerOrm, options, retryPolicy)
+		}
+		if err != nil {
+			slog.Error("workerFailed", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+		slog.Info("workerStopped")
+	},
+}
+
+// startMetricsServer exposes /metrics for the worker, which has no api server
+func startMetricsServer(cmd *cobra.Command, configAddr string) *http.Server {
+	addr, _ := cmd.Flags().GetString("metrics-addr")
+	if addr == "" {
+		addr = configAddr
+	}
+	if addr == "" {
+		addr = defaultWorkerMetricsAddr
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	server := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			slog.Error("workerMetricsServerFailed", slog.String("error", err.Error()))
+		}
+	}()
+	return server
+}
+
+func init() {
+	workerCmd.Flags().String("group", "", "Kafka consumer group, defaults to KAFKA_CONSUMER_GROUP")
+	workerCmd.Flags().String("metrics-addr", "", "Address of the /metrics endpoint, defaults to WORKER_METRICS_ADDR")
+	workerCmd.Flags().Int("concurrency", 0, "Batches processed at once per topic, defaults to WORKER_CONCURRENCY")
+	rootCmd.AddCommand(workerCmd)
+}
@@ -0,0 +1,38 @@
+package common
+
+// Channels a notification can be delivered on
+const (
+	ChannelEmail = "email"
+	ChannelSMS   = "sms"
+	ChannelPush  = "push"
+	ChannelInApp = "in_app"
+)
+
+var channels = []string{ChannelEmail, ChannelSMS, ChannelPush, ChannelInApp}
+
+// IsChannel reports whether channel is one of the supported channels
+func IsChannel(channel string) bool {
+	for _, c := range channels {
+		if c == channel {
+			return true
+		}
+	}
+	return false
+}
+
+// Address returns where the audience receives notifications on channel,
+// empty when the audience cannot be reached there
+func (a AudienceType) Address(channel string) string {
+	switch channel {
+	case ChannelEmail:
+		return a.Email
+	case ChannelSMS:
+		return a.Phone
+	case ChannelPush:
+		return a.DeviceToken
+	case ChannelInApp:
+		return a.UserID
+	default:
+		return ""
+	}
+}
@@ -10,7 +10,14 @@ type Response struct {
 // which can be used to personalize the notification template.
 // Note: The "Email" field is used to store the audience's email address because
 // we are planning to attach an email service for testing in phase 2.
+// Phone, DeviceToken and UserID address the audience on the SMS, push and
+// in-app channels. Channels lists the channels the audience prefers, most
+// preferred first, and is used when the request does not choose a channel.
 type AudienceType struct {
-	Email   string            `json:"email"`
-	Context map[string]string `json:"context"`
+	Email       string            `json:"email"`
+	Phone       string            `json:"phone,omitempty"`
+	DeviceToken string            `json:"device_token,omitempty"`
+	UserID      string            `json:"user_id,omitempty"`
+	Channels    []string          `json:"channels,omitempty"`
+	Context     map[string]string `json:"context"`
 }
@@ -0,0 +1,86 @@
+package communicator
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kp/pager/communicator/models"
+)
+
+// FrequencyCapConfig limits how many notifications of a template category a
+// recipient receives in a rolling window, e.g.
+// FREQUENCY_CAPS=marketing=3/24h,general=20/24h. Categories without a cap are
+// not limited.
+type FrequencyCapConfig struct {
+	FrequencyCaps string `json:"FREQUENCY_CAPS"`
+}
+
+// FrequencyCap allows at most Limit notifications of a category per Window
+type FrequencyCap struct {
+	Limit  int
+	Window time.Duration
+}
+
+// frequencyCaps holds the cap of every capped category
+var frequencyCaps = map[string]FrequencyCap{}
+
+// InitFrequencyCaps makes the caps of config the ones checked by
+// NotificationType.Validate. It should be called once during startup.
+func InitFrequencyCaps(config FrequencyCapConfig) error {
+	caps, err := ParseFrequencyCaps(config.FrequencyCaps)
+	if err != nil {
+		return err
+	}
+	frequencyCaps = caps
+	for category, limit := range caps {
+		slog.Info("frequency cap initialized", "category", category, "limit", limit.Limit, "window", limit.Window.String())
+	}
+	return nil
+}
+
+// ParseFrequencyCaps parses comma separated category=limit/window caps
+func ParseFrequencyCaps(value string) (map[string]FrequencyCap, error) {
+	caps := map[string]FrequencyCap{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		category, rule, ok := strings.Cut(entry, "=")
+		limitValue, windowValue, hasWindow := strings.Cut(rule, "/")
+		category = strings.ToLower(strings.TrimSpace(category))
+		if !ok || !hasWindow || category == "" {
+			return nil, fmt.Errorf("invalid FREQUENCY_CAPS entry %q, expected category=limit/window", entry)
+		}
+		limit, err := strconv.Atoi(strings.TrimSpace(limitValue))
+		if err != nil || limit < 0 {
+			return nil, fmt.Errorf("invalid limit in FREQUENCY_CAPS entry %q", entry)
+		}
+		window, err := time.ParseDuration(strings.TrimSpace(windowValue))
+		if err != nil || window <= 0 {
+			return nil, fmt.Errorf("invalid window in FREQUENCY_CAPS entry %q", entry)
+		}
+		caps[category] = FrequencyCap{Limit: limit, Window: window}
+	}
+	return caps, nil
+}
+
+// isCapped reports whether address already received the most notifications of
+// category its cap allows. Recipients of concurrent batches are counted when
+// they are sent, so a cap can be exceeded by the notifications in flight.
+// Replaced in tests.
+var isCapped = func(ctx context.Context, address, category string) (bool, error) {
+	limit, ok := frequencyCaps[category]
+	if !ok {
+		return false, nil
+	}
+	sent, err := models.CountSentCommunicationLogs(ctx, nil, address, category, time.Now().Add(-limit.Window))
+	if err != nil {
+		return false, err
+	}
+	return sent >= limit.Limit, nil
+}
@@ -0,0 +1,27 @@
+package communicator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFrequencyCaps(t *testing.T) {
+	caps, err := ParseFrequencyCaps(" Marketing=3/24h, general=20/1h ,")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]FrequencyCap{
+		"marketing": {Limit: 3, Window: 24 * time.Hour},
+		"general":   {Limit: 20, Window: time.Hour},
+	}, caps)
+
+	caps, err = ParseFrequencyCaps("")
+	require.NoError(t, err)
+	assert.Empty(t, caps)
+
+	for _, invalid := range []string{"marketing", "marketing=3", "=3/24h", "marketing=x/24h", "marketing=-1/24h", "marketing=3/day", "marketing=3/0s"} {
+		_, err := ParseFrequencyCaps(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
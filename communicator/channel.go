@@ -0,0 +1,24 @@
+package communicator
+
+import "github.com/kp/pager/common"
+
+// resolveChannel picks the channel the audience is notified on. A channel
+// chosen by the request is always used, otherwise the first channel the
+// audience prefers which the template supports and the audience has an
+// address for. Audiences without a usable preference get email.
+func resolveChannel(requested string, supported []string, audience common.AudienceType) string {
+	if requested != "" {
+		return requested
+	}
+	for _, preferred := range audience.Channels {
+		if audience.Address(preferred) == "" {
+			continue
+		}
+		for _, channel := range supported {
+			if channel == preferred {
+				return channel
+			}
+		}
+	}
+	return common.ChannelEmail
+}
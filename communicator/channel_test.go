@@ -0,0 +1,37 @@
+package communicator
+
+import (
+	"testing"
+
+	"github.com/kp/pager/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveChannel(t *testing.T) {
+	audience := common.AudienceType{
+		Email:       "user@example.com",
+		Phone:       "+15550100",
+		DeviceToken: "",
+		Channels:    []string{common.ChannelPush, common.ChannelSMS, common.ChannelEmail},
+	}
+	all := []string{common.ChannelEmail, common.ChannelSMS, common.ChannelPush, common.ChannelInApp}
+
+	tests := []struct {
+		name      string
+		requested string
+		supported []string
+		audience  common.AudienceType
+		want      string
+	}{
+		{name: "requested channel wins", requested: common.ChannelInApp, supported: all, audience: audience, want: common.ChannelInApp},
+		{name: "skips preference without address", supported: all, audience: audience, want: common.ChannelSMS},
+		{name: "skips preference the template lacks", supported: []string{common.ChannelEmail}, audience: audience, want: common.ChannelEmail},
+		{name: "no preferences", supported: all, audience: common.AudienceType{Email: "user@example.com"}, want: common.ChannelEmail},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, resolveChannel(tt.requested, tt.supported, tt.audience))
+		})
+	}
+}
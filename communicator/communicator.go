@@ -2,30 +2,64 @@ package communicator
 
 import (
 	"context"
+	"errors"
 	"fmt"
+
+	"github.com/kp/pager/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 )
 
 func (c *communicator) Run(ctx context.Context) error {
-	// save the notification
-	if err := c.NotificationHanlder.Save(ctx); err != nil {
-		return fmt.Errorf("save failed: %v", err)
+	// save the notification, recipients already notified are done
+	if err := step(ctx, "notification.save", c.NotificationHanlder.Save); err != nil {
+		if errors.Is(err, ErrSkipped) {
+			return nil
+		}
+		return fmt.Errorf("save failed: %w", err)
 	}
 
-	// Validate the notification
-	if err := c.NotificationHanlder.Validate(ctx); err != nil {
-		return fmt.Errorf("validation failed: %v", err)
+	// Validate the notification, skipped recipients are done
+	if err := step(ctx, "notification.validate", c.NotificationHanlder.Validate); err != nil {
+		if errors.Is(err, ErrSkipped) {
+			return nil
+		}
+		return fmt.Errorf("validation failed: %w", err)
 	}
 
 	// Prepare the notification
-	payload, err := c.NotificationHanlder.Prepare(ctx)
+	var payload interface{}
+	err := step(ctx, "notification.prepare", func(ctx context.Context) (err error) {
+		payload, err = c.NotificationHanlder.Prepare(ctx)
+		return err
+	})
 	if err != nil {
-		return fmt.Errorf("preparation failed: %v", err)
+		return fmt.Errorf("preparation failed: %w", err)
 	}
 
 	// Send the notification
-	if err := c.NotificationHanlder.Send(ctx, payload); err != nil {
-		return fmt.Errorf("sending failed: %v", err)
+	err = step(ctx, "notification.send", func(ctx context.Context) error {
+		return c.NotificationHanlder.Send(ctx, payload)
+	})
+	if err != nil {
+		return fmt.Errorf("sending failed: %w", err)
 	}
 
 	return nil
 }
+
+// step runs one stage of the notification in its own span
+func step(ctx context.Context, name string, run func(ctx context.Context) error) error {
+	ctx, span := tracing.Tracer().Start(ctx, name)
+	defer span.End()
+	if err := run(ctx); err != nil {
+		if _, deferred := DeferredUntil(err); deferred || errors.Is(err, ErrSkipped) {
+			span.SetAttributes(attribute.String(tracing.AttrSkipped, err.Error()))
+			return err
+		}
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package communicator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeHandler struct {
+	saveErr, validateErr, sendErr error
+	sent                          bool
+}
+
+func (h *fakeHandler) Save(ctx context.Context) error     { return h.saveErr }
+func (h *fakeHandler) Validate(ctx context.Context) error { return h.validateErr }
+func (h *fakeHandler) Prepare(ctx context.Context) (interface{}, error) {
+	return NotificationPayload{Channel: "email"}, nil
+}
+func (h *fakeHandler) Send(ctx context.Context, payload interface{}) error {
+	h.sent = true
+	return h.sendErr
+}
+
+func TestCommunicator_Run(t *testing.T) {
+	tests := []struct {
+		name     string
+		handler  *fakeHandler
+		wantErr  bool
+		wantSent bool
+	}{
+		{"sent", &fakeHandler{}, false, true},
+		{"already sent", &fakeHandler{saveErr: fmt.Errorf("%w: recipient is already sent", ErrSkipped)}, false, false},
+		{"suppressed", &fakeHandler{validateErr: fmt.Errorf("%w: suppressed", ErrSkipped)}, false, false},
+		{"save failed", &fakeHandler{saveErr: errors.New("database down")}, true, false},
+		{"send failed", &fakeHandler{sendErr: errors.New("provider down")}, true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := NewCommunicationService(context.Background(), tt.handler).Run(context.Background())
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.wantSent, tt.handler.sent)
+		})
+	}
+}
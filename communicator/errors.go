@@ -0,0 +1,60 @@
+package communicator
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrSkipped is returned by Save and Validate for recipients which must not
+// be notified, such as suppressed addresses or recipients already sent to in
+// a redelivered batch. They are neither sent nor retried.
+var ErrSkipped = errors.New("recipient skipped")
+
+// PermanentError marks a recipient failure which will not succeed on retry,
+// such as an invalid address or a template that fails to render.
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+func NewPermanentError(err error) error {
+	return &PermanentError{Err: err}
+}
+
+// IsPermanent reports whether err, or any error it wraps, is a PermanentError
+func IsPermanent(err error) bool {
+	var permanent *PermanentError
+	return errors.As(err, &permanent)
+}
+
+// DeferredError holds a recipient back until Until, e.g. during their quiet
+// hours. Deferred recipients are requeued without counting an attempt.
+type DeferredError struct {
+	Until  time.Time
+	Reason string
+}
+
+func (e *DeferredError) Error() string {
+	return fmt.Sprintf("deferred until %s: %s", e.Until.Format(time.RFC3339), e.Reason)
+}
+
+func NewDeferredError(until time.Time, reason string) error {
+	return &DeferredError{Until: until, Reason: reason}
+}
+
+// DeferredUntil returns until when err defers the recipient, if it is or wraps a DeferredError
+func DeferredUntil(err error) (time.Time, bool) {
+	var deferred *DeferredError
+	if errors.As(err, &deferred) {
+		return deferred.Until, true
+	}
+	return time.Time{}, false
+}
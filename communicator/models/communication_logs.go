@@ -2,6 +2,7 @@ package models
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"github.com/kp/pager/databases/sql"
@@ -9,35 +10,130 @@ import (
 
 const CommunicationLogsTableName = "communication_logs"
 
+const communicationLogRecipientIndex = "idx_communication_log_recipient"
+
+const (
+	CommunicationStatusCreated = "created"
+	// recipients claimed by a worker which is notifying them
+	CommunicationStatusSending = "sending"
+	CommunicationStatusSent    = "sent"
+	CommunicationStatusFailed  = "failed"
+	// failed recipients waiting on the retry topic
+	CommunicationStatusRetrying = "retrying"
+	// recipients skipped because they are on the suppression list
+	CommunicationStatusSuppressed = "suppressed"
+	// recipients skipped because they reached the frequency cap of the category
+	CommunicationStatusCapped = "capped"
+	// recipients held back until their local send time or the end of their quiet hours
+	CommunicationStatusDeferred = "deferred"
+)
+
 type CommunicationLogs struct {
-	ID         int64     `gorm:"column:id;primaryKey;autoIncrement"`
-	Email      string    `gorm:"column:email"`
-	TemplateID int64     `gorm:"column:template_id"`
-	RequestID  string    `gorm:"column:request_id;index"`
-	Status     string    `gorm:"column:status"`
-	Payload    string    `gorm:"column:payload;type:text"`
-	CreatedAt  time.Time `gorm:"column:created_at"`
-	UpdatedAt  time.Time `gorm:"column:updated_at"`
+	ID int64 `gorm:"column:id;primaryKey;autoIncrement"`
+	// Email is the address of the recipient on Channel, a request logs every
+	// recipient and channel once
+	Email   string `gorm:"column:email;unique_index:idx_communication_log_recipient"`
+	Channel string `gorm:"column:channel;default:'email';unique_index:idx_communication_log_recipient"`
+	// Category of the template, frequency caps count the logs of a category
+	Category        string    `gorm:"column:category;default:'general'"`
+	TemplateID      int64     `gorm:"column:template_id"`
+	TemplateVersion int       `gorm:"column:template_version;default:0"`
+	RequestID       string    `gorm:"column:request_id;index;unique_index:idx_communication_log_recipient"`
+	Status          string    `gorm:"column:status"`
+	Payload         string    `gorm:"column:payload;type:text"`
+	Provider        string    `gorm:"column:provider"`
+	Error           string    `gorm:"column:error_message;type:text"`
+	Attempts        int       `gorm:"column:attempts;default:0"`
+	CreatedAt       time.Time `gorm:"column:created_at"`
+	UpdatedAt       time.Time `gorm:"column:updated_at"`
 }
 
 func (CommunicationLogs) TableName() string {
 	return CommunicationLogsTableName
 }
 
-func NewCommunicationLogEntry(ctx context.Context, tx interface{}, email string, templateID int64, requestID string) (*CommunicationLogs, error) {
+// UpsertCommunicationLogEntry returns the log of the recipient on channel for
+// the request, creating it on the first attempt. Later attempts count one more
+// attempt, logs of recipients which are done or being sent are left as they
+// are, see IsFinalCommunicationStatus and ClaimCommunicationLog.
+func UpsertCommunicationLogEntry(ctx context.Context, tx interface{}, email, channel, category string, templateID int64, templateVersion int, requestID string) (*CommunicationLogs, error) {
 	database := sql.GetOrmQuearyable(ctx, tx)
-	entry := CommunicationLogs{
-		Email:      email,
-		TemplateID: templateID,
-		RequestID:  requestID,
-		Status:     "created",
-		CreatedAt:  time.Now(),
-		UpdatedAt:  time.Now(),
+	now := time.Now()
+	// deferred recipients made no attempt, the updated_at of sent logs is the
+	// send time frequency caps count from
+	err := database.Exec(fmt.Sprintf("INSERT INTO %[1]s "+
+		"(email, channel, category, template_id, template_version, request_id, status, attempts, created_at, updated_at) "+
+		"VALUES (?, ?, ?, ?, ?, ?, ?, 1, ?, ?) "+
+		"ON CONFLICT (request_id, email, channel) DO UPDATE SET "+
+		"attempts = CASE WHEN %[1]s.status = '%[2]s' THEN %[1]s.attempts ELSE %[1]s.attempts + 1 END, "+
+		"updated_at = EXCLUDED.updated_at "+
+		"WHERE %[1]s.status NOT IN ('%[3]s', '%[4]s', '%[5]s', '%[6]s')",
+		CommunicationLogsTableName, CommunicationStatusDeferred,
+		CommunicationStatusSent, CommunicationStatusSuppressed, CommunicationStatusCapped, CommunicationStatusSending),
+		email, channel, category, templateID, templateVersion, requestID, CommunicationStatusCreated, now, now).Error
+	if err != nil {
+		return nil, err
 	}
-	err := database.Create(&entry).Error
+	entry := CommunicationLogs{}
+	err = database.Where("request_id = ? AND email = ? AND channel = ?", requestID, email, channel).First(&entry).Error
 	return &entry, err
 }
 
+// ClaimCommunicationLog moves the log to sending unless the recipient is done
+// or another worker claimed it after staleBefore. Only the worker which gets
+// true may notify the recipient, a claim older than staleBefore is taken over
+// as its worker stopped before recording an outcome.
+func ClaimCommunicationLog(ctx context.Context, tx interface{}, id int64, staleBefore time.Time) (bool, error) {
+	db := sql.GetOrmQuearyable(ctx, tx)
+	result := db.Model(&CommunicationLogs{}).
+		Where("id = ? AND status NOT IN (?)", id, []string{
+			CommunicationStatusSent, CommunicationStatusSuppressed, CommunicationStatusCapped, CommunicationStatusSending,
+		}).
+		Or("id = ? AND status = ? AND updated_at < ?", id, CommunicationStatusSending, staleBefore).
+		Updates(map[string]interface{}{
+			"status":     CommunicationStatusSending,
+			"updated_at": time.Now(),
+		})
+	return result.RowsAffected > 0, result.Error
+}
+
+// IsFinalCommunicationStatus reports whether a recipient in status must not be notified again
+func IsFinalCommunicationStatus(status string) bool {
+	return status == CommunicationStatusSent || status == CommunicationStatusSuppressed || status == CommunicationStatusCapped
+}
+
+// CountSentCommunicationLogs returns how many notifications of category were
+// sent to email since
+func CountSentCommunicationLogs(ctx context.Context, tx interface{}, email, category string, since time.Time) (int, error) {
+	var count int
+	db := sql.GetOrmQuearyable(ctx, tx)
+	err := db.Model(&CommunicationLogs{}).
+		Where("email = ? AND category = ? AND status = ? AND updated_at >= ?", email, category, CommunicationStatusSent, since).
+		Count(&count).Error
+	return count, err
+}
+
+// DeduplicateCommunicationLogs prepares logs written before recipients were
+// unique per request and channel for the unique index, keeping the sent log
+// or else the latest one of every recipient
+func DeduplicateCommunicationLogs(ctx context.Context, tx interface{}) error {
+	db := sql.GetOrmQuearyable(ctx, tx)
+	if db.Dialect().HasIndex(CommunicationLogsTableName, communicationLogRecipientIndex) {
+		return nil
+	}
+	err := db.Exec(fmt.Sprintf(`DELETE FROM %[1]s WHERE id IN (
+		SELECT id FROM (
+			SELECT id, ROW_NUMBER() OVER (
+				PARTITION BY request_id, email, channel ORDER BY (status = ?) DESC, id DESC
+			) AS position FROM %[1]s
+		) ranked WHERE position > 1)`, CommunicationLogsTableName), CommunicationStatusSent).Error
+	if err != nil {
+		return err
+	}
+	return db.Model(&CommunicationLogs{}).
+		AddUniqueIndex(communicationLogRecipientIndex, "request_id", "email", "channel").Error
+}
+
 func GetCommunicationLogByID(ctx context.Context, tx interface{}, id int64) (*CommunicationLogs, error) {
 	db := sql.GetOrmQuearyable(ctx, tx)
 	entry := CommunicationLogs{}
@@ -52,6 +148,43 @@ func GetAllCommunicationLogsByRequestID(ctx context.Context, tx interface{}, req
 	return logs, err
 }
 
+// GetCommunicationLogsPageByRequestID returns one page of the request's logs
+// along with the total number of logs for the request
+func GetCommunicationLogsPageByRequestID(ctx context.Context, tx interface{}, requestID string, limit, offset int) ([]CommunicationLogs, int, error) {
+	var logs []CommunicationLogs
+	var total int
+	db := sql.GetOrmQuearyable(ctx, tx)
+	query := db.Model(&CommunicationLogs{}).Where("request_id = ?", requestID)
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err := query.Order("id").Limit(limit).Offset(offset).Find(&logs).Error
+	return logs, total, err
+}
+
+// CountCommunicationLogsByStatus returns the number of logs of a request per status
+func CountCommunicationLogsByStatus(ctx context.Context, tx interface{}, requestID string) (map[string]int, error) {
+	var rows []struct {
+		Status string
+		Count  int
+	}
+	db := sql.GetOrmQuearyable(ctx, tx)
+	err := db.Model(&CommunicationLogs{}).
+		Select("status, count(*) as count").
+		Where("request_id = ?", requestID).
+		Group("status").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int, len(rows))
+	for _, row := range rows {
+		counts[row.Status] = row.Count
+	}
+	return counts, nil
+}
+
 func GetAllCommunicationLogs(ctx context.Context, tx interface{}, limit, offset int) ([]CommunicationLogs, error) {
 	var logs []CommunicationLogs
 	db := sql.GetOrmQuearyable(ctx, tx)
@@ -70,6 +203,15 @@ func GetAllCommunicationLogs(ctx context.Context, tx interface{}, limit, offset
 	return logs, err
 }
 
+func UpdateCommunicationLogStatus(ctx context.Context, tx interface{}, id int64, status, errorMessage string) error {
+	db := sql.GetOrmQuearyable(ctx, tx)
+	return db.Model(&CommunicationLogs{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"status":        status,
+		"error_message": errorMessage,
+		"updated_at":    time.Now(),
+	}).Error
+}
+
 func (log CommunicationLogs) Save(ctx context.Context, tx interface{}) error {
 	db := sql.GetOrmQuearyable(ctx, tx)
 	return db.Save(log).Error
@@ -0,0 +1,96 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestDB opens an in-memory database with the communication logs, shared
+// by the connections of the test only
+func newTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open("sqlite3", fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name()))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	require.NoError(t, db.AutoMigrate(&CommunicationLogs{}).Error)
+	return db
+}
+
+func upsertLog(t *testing.T, db *gorm.DB) *CommunicationLogs {
+	entry, err := UpsertCommunicationLogEntry(context.Background(), db, "ada@example.com", "email", "general", 1, 1, "req1")
+	require.NoError(t, err)
+	return entry
+}
+
+func TestUpsertCommunicationLogEntry(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	first := upsertLog(t, db)
+	assert.Equal(t, CommunicationStatusCreated, first.Status)
+	assert.Equal(t, 1, first.Attempts)
+
+	// a retry counts one more attempt on the same log
+	require.NoError(t, UpdateCommunicationLogStatus(ctx, db, first.ID, CommunicationStatusRetrying, "smtp timeout"))
+	retried := upsertLog(t, db)
+	assert.Equal(t, first.ID, retried.ID)
+	assert.Equal(t, 2, retried.Attempts)
+
+	// deferred recipients made no attempt
+	require.NoError(t, UpdateCommunicationLogStatus(ctx, db, first.ID, CommunicationStatusDeferred, "quiet hours"))
+	assert.Equal(t, 2, upsertLog(t, db).Attempts)
+}
+
+func TestUpsertCommunicationLogEntry_SentIsUntouched(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	entry := upsertLog(t, db)
+	sentAt := time.Now().Add(-time.Hour)
+	require.NoError(t, db.Model(&CommunicationLogs{}).Where("id = ?", entry.ID).UpdateColumns(map[string]interface{}{
+		"status":     CommunicationStatusSent,
+		"updated_at": sentAt,
+	}).Error)
+
+	redelivered := upsertLog(t, db)
+	assert.Equal(t, CommunicationStatusSent, redelivered.Status)
+	assert.Equal(t, 1, redelivered.Attempts)
+	assert.WithinDuration(t, sentAt, redelivered.UpdatedAt, time.Millisecond)
+
+	// the send keeps counting from when it happened
+	count, err := CountSentCommunicationLogs(ctx, db, "ada@example.com", "general", time.Now().Add(-30*time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+}
+
+func TestClaimCommunicationLog(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	staleBefore := time.Now().Add(-10 * time.Minute)
+
+	// two copies of a recipient in one batch share a log, only one claims it
+	first := upsertLog(t, db)
+	second := upsertLog(t, db)
+	require.Equal(t, first.ID, second.ID)
+	claimed, err := ClaimCommunicationLog(ctx, db, first.ID, staleBefore)
+	require.NoError(t, err)
+	assert.True(t, claimed)
+	claimed, err = ClaimCommunicationLog(ctx, db, second.ID, staleBefore)
+	require.NoError(t, err)
+	assert.False(t, claimed)
+
+	// the claim of a worker which stopped is taken over once stale
+	claimed, err = ClaimCommunicationLog(ctx, db, first.ID, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.True(t, claimed)
+
+	require.NoError(t, UpdateCommunicationLogStatus(ctx, db, first.ID, CommunicationStatusSent, ""))
+	claimed, err = ClaimCommunicationLog(ctx, db, first.ID, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.False(t, claimed, "sent recipients are never claimed again")
+}
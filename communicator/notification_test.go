@@ -116,6 +116,15 @@ func TestNotificationType_Save(t *testing.T) {
 }
 
 func TestNotificationType_Validate(t *testing.T) {
+	defer func(original func(context.Context, string, string) (bool, error)) { isSuppressed = original }(isSuppressed)
+	isSuppressed = func(ctx context.Context, address, category string) (bool, error) {
+		return address == "unsubscribed@example.com" && category == "general", nil
+	}
+	defer func(original func(context.Context, string, string) (bool, error)) { isCapped = original }(isCapped)
+	isCapped = func(ctx context.Context, address, category string) (bool, error) {
+		return address == "frequent@example.com", nil
+	}
+
 	tests := []struct {
 		name         string
 		notification *NotificationType
@@ -131,6 +140,26 @@ func TestNotificationType_Validate(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "suppressed recipient",
+			notification: &NotificationType{
+				To:         "unsubscribed@example.com",
+				TemplateID: 123,
+				RequestId:  "req123",
+			},
+			wantErr: true,
+			errMsg:  "is suppressed for general notifications",
+		},
+		{
+			name: "capped recipient",
+			notification: &NotificationType{
+				To:         "frequent@example.com",
+				TemplateID: 123,
+				RequestId:  "req123",
+			},
+			wantErr: true,
+			errMsg:  "reached the frequency cap of general notifications",
+		},
 		{
 			name: "empty To field",
 			notification: &NotificationType{
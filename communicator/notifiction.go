@@ -5,26 +5,103 @@ import (
 	"fmt"
 	"log/slog"
 	"strings"
+	"time"
 
+	"github.com/kp/pager/common"
 	"github.com/kp/pager/communicator/models"
 	"github.com/kp/pager/databases/sql"
+	"github.com/kp/pager/metrics"
+	"github.com/kp/pager/suppression"
 	template "github.com/kp/pager/templates"
+	"github.com/kp/pager/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// isSuppressed reports whether the recipient unsubscribed from category,
+// replaced in tests
+var isSuppressed = func(ctx context.Context, address, category string) (bool, error) {
+	return suppression.NewSuppressionService(sql.PagerOrm).IsSuppressed(ctx, address, category)
+}
+
+// sendingClaimTimeout is how long a recipient claimed by a worker is skipped
+// by the others, claims of workers which stopped mid-send expire after it
+var sendingClaimTimeout = 10 * time.Minute
+
+// Save logs the recipient for the request and claims them for this worker.
+// Redelivered batches and retries find the log of their first attempt,
+// recipients which are already done or claimed by another worker are skipped
+// so they are never notified twice.
 func (n *NotificationType) Save(ctx context.Context) error {
-	entry, err := models.NewCommunicationLogEntry(ctx, nil,
-		n.To, n.TemplateID, n.RequestId)
+	entry, err := models.UpsertCommunicationLogEntry(ctx, nil,
+		n.To, n.channel(), n.category(), n.TemplateID, n.TemplateVersion, n.RequestId)
 	if err != nil {
 		return fmt.Errorf("failed to save communication log: %v", err)
 	}
 
 	n.LogID = entry.ID
+	if models.IsFinalCommunicationStatus(entry.Status) {
+		slog.Info("save:recipientAlreadyDone",
+			slog.Int64("log_id", n.LogID),
+			slog.String("request_id", n.RequestId),
+			slog.String("status", entry.Status))
+		return fmt.Errorf("%w: recipient is already %s", ErrSkipped, entry.Status)
+	}
+
+	claimed, err := models.ClaimCommunicationLog(ctx, nil, n.LogID, time.Now().Add(-sendingClaimTimeout))
+	if err != nil {
+		return fmt.Errorf("failed to claim communication log: %v", err)
+	}
+	if !claimed {
+		slog.Info("save:recipientClaimed",
+			slog.Int64("log_id", n.LogID),
+			slog.String("request_id", n.RequestId))
+		return fmt.Errorf("%w: recipient is being notified by another worker", ErrSkipped)
+	}
 	return nil
 }
 
 func (n *NotificationType) Validate(ctx context.Context) error {
 	if strings.EqualFold(n.To, "") {
-		return fmt.Errorf("recipient (To) field cannot be empty")
+		err := NewPermanentError(fmt.Errorf("recipient (To) field cannot be empty"))
+		n.markFailed(ctx, err)
+		return err
+	}
+
+	suppressed, err := isSuppressed(ctx, n.To, n.category())
+	if err != nil {
+		return fmt.Errorf("failed to check suppression list: %v", err)
+	}
+	if suppressed {
+		slog.Info("validate:recipientSuppressed",
+			slog.Int64("log_id", n.LogID),
+			slog.String("request_id", n.RequestId),
+			slog.String("category", n.category()))
+		n.setStatus(ctx, models.CommunicationStatusSuppressed, "")
+		return fmt.Errorf("%w: %s is suppressed for %s notifications", ErrSkipped, n.To, n.category())
+	}
+
+	if until, reason, deferred := n.deferral(time.Now()); deferred {
+		slog.Info("validate:recipientDeferred",
+			slog.Int64("log_id", n.LogID),
+			slog.String("request_id", n.RequestId),
+			slog.Time("until", until),
+			slog.String("reason", reason))
+		n.setStatus(ctx, models.CommunicationStatusDeferred, reason)
+		return NewDeferredError(until, reason)
+	}
+
+	capped, err := isCapped(ctx, n.To, n.category())
+	if err != nil {
+		return fmt.Errorf("failed to check frequency cap: %v", err)
+	}
+	if capped {
+		slog.Info("validate:recipientCapped",
+			slog.Int64("log_id", n.LogID),
+			slog.String("request_id", n.RequestId),
+			slog.String("category", n.category()))
+		n.setStatus(ctx, models.CommunicationStatusCapped, "")
+		return fmt.Errorf("%w: %s reached the frequency cap of %s notifications", ErrSkipped, n.To, n.category())
 	}
 	return nil
 }
@@ -32,41 +109,128 @@ func (n *NotificationType) Validate(ctx context.Context) error {
 func (n *NotificationType) Prepare(ctx context.Context) (interface{}, error) {
 	var payload NotificationPayload
 	templateService := template.NewTemplateService(sql.PagerOrm)
-	templateData, err := templateService.GetTemplate(ctx, n.TemplateID)
+	// render the version pinned when the notification was triggered
+	var templateData *template.Template
+	var err error
+	if n.TemplateVersion > 0 {
+		templateData, err = templateService.GetTemplateVersion(ctx, n.TemplateID, n.TemplateVersion)
+	} else {
+		templateData, err = templateService.GetTemplate(ctx, n.TemplateID)
+	}
 	if err != nil {
 		slog.Error("prepare:failedToGetTemplate",
 			slog.Int64("template_id", n.TemplateID),
+			slog.Int("template_version", n.TemplateVersion),
 			slog.Any("error", err))
 		return nil, fmt.Errorf("failed to get template: %v", err)
 	}
 
-	payload.Body = templateData.Content
-	payload.Subject = templateData.Subject
-	payload.Name = templateData.Name
-	return payload, err
+	// the recipient locale picks the closest variant, e.g. pt-BR, then pt, then the default
+	templateData = templateData.Localize(n.Context[template.LocaleContextKey])
+	data := template.RenderData(n.Context, n.To, n.category())
+	rendered, err := template.RenderChannel(templateData, n.channel(), data)
+	if err != nil {
+		slog.Error("prepare:failedToRenderTemplate",
+			slog.Int64("template_id", n.TemplateID),
+			slog.Int64("log_id", n.LogID),
+			slog.Any("error", err))
+		err = NewPermanentError(err)
+		n.markFailed(ctx, err)
+		return nil, err
+	}
+
+	payload.Channel = rendered.Channel
+	payload.Body = rendered.Body
+	payload.Subject = rendered.Subject
+	payload.Text = rendered.Text
+	payload.Name = rendered.Name
+	payload.UnsubscribeURL = data[template.UnsubscribeURLContextKey]
+	return payload, nil
+}
+
+// channel returns the channel of the notification, messages queued before
+// channels existed are email
+func (n *NotificationType) channel() string {
+	if n.Channel == "" {
+		return common.ChannelEmail
+	}
+	return n.Channel
+}
+
+// category returns the template category the recipient may have unsubscribed from
+func (n *NotificationType) category() string {
+	if n.Category == "" {
+		return template.DefaultCategory
+	}
+	return n.Category
+}
+
+// markFailed records the failure reason on the recipient's communication log
+func (n *NotificationType) markFailed(ctx context.Context, reason error) {
+	n.setStatus(ctx, models.CommunicationStatusFailed, reason.Error())
+}
+
+// setStatus records the outcome of the recipient on their communication log
+func (n *NotificationType) setStatus(ctx context.Context, status, errorMessage string) {
+	if n.LogID == 0 {
+		return
+	}
+	if err := models.UpdateCommunicationLogStatus(ctx, nil, n.LogID, status, errorMessage); err != nil {
+		slog.Error("setStatus:failedToUpdateCommunicationLog",
+			slog.Int64("log_id", n.LogID),
+			slog.Any("error", err))
+	}
 }
 
 func (n *NotificationType) Send(ctx context.Context, payload interface{}) error {
+	message, ok := payload.(NotificationPayload)
+	if !ok {
+		return fmt.Errorf("unexpected payload type %T", payload)
+	}
+	provider := GetProvider(message.Channel)
+	if provider == nil {
+		return fmt.Errorf("no notification provider is configured for channel %q", message.Channel)
+	}
+
 	// Fetch existing log entry
-	tx := sql.PagerOrm.Begin()
-	defer tx.Rollback()
-	entry, err := models.GetCommunicationLogByID(ctx, tx, n.LogID)
+	entry, err := models.GetCommunicationLogByID(ctx, nil, n.LogID)
 	if err != nil {
 		return fmt.Errorf("failed to fetch communication log: %v", err)
 	}
 
-	// Update status and payload
-	entry.Status = "sent"
+	// Update status and payload based on the provider outcome
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String(tracing.AttrProvider, provider.Name()))
+	start := time.Now()
+	sendErr := provider.Send(ctx, n.To, message)
+	outcome := metrics.Outcome(sendErr)
+	labels := []string{metrics.TemplateLabel(n.TemplateID), message.Channel, provider.Name(), outcome}
+	metrics.RecipientSends.WithLabelValues(labels...).Inc()
+	metrics.RecipientSendDuration.WithLabelValues(labels...).Observe(time.Since(start).Seconds())
+	entry.Provider = provider.Name()
 	entry.Payload = fmt.Sprintf("%v", payload)
+	entry.UpdatedAt = time.Now()
+	if sendErr != nil {
+		entry.Status = models.CommunicationStatusFailed
+		entry.Error = sendErr.Error()
+	} else {
+		entry.Status = models.CommunicationStatusSent
+		entry.Error = ""
+	}
 
 	// Save updated entry
-	if err := entry.Save(ctx, tx); err != nil {
+	if err := entry.Save(ctx, nil); err != nil {
 		slog.Error("send:failedToUpdateCommunicationLog",
 			slog.Int64("log_id", n.LogID),
 			slog.Any("error", err))
 		return fmt.Errorf("failed to update communication log: %v", err)
 	}
 
-	tx.Commit()
-	return err
+	if sendErr != nil {
+		slog.Error("send:providerFailed",
+			slog.Int64("log_id", n.LogID),
+			slog.String("provider", provider.Name()),
+			slog.Any("error", sendErr))
+		return fmt.Errorf("%s provider failed: %v", provider.Name(), sendErr)
+	}
+	return nil
 }
@@ -0,0 +1,98 @@
+package communicator
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/kp/pager/common"
+)
+
+const (
+	ProviderLog  = "log"
+	ProviderSMTP = "smtp"
+)
+
+// Provider delivers a prepared notification to its final destination
+// (mail server, SMS gateway, ...). Implementations must be safe for
+// concurrent use since recipients of a batch are sent in parallel.
+type Provider interface {
+	Name() string
+	Send(ctx context.Context, to string, payload NotificationPayload) error
+	Close() error
+}
+
+// providers holds the provider of each channel, channels without a
+// configured provider only log their notifications
+var providers = map[string]Provider{
+	common.ChannelEmail: &logProvider{},
+	common.ChannelSMS:   &logProvider{},
+	common.ChannelPush:  &logProvider{},
+	common.ChannelInApp: &logProvider{},
+}
+
+// InitProvider builds the provider selected in config for every channel and
+// makes them the ones used by NotificationType.Send. It should be called once
+// during startup.
+func InitProvider(config ProviderConfig) error {
+	for _, selected := range []struct{ channel, name string }{
+		{common.ChannelEmail, config.Provider},
+		{common.ChannelSMS, config.SMSProvider},
+		{common.ChannelPush, config.PushProvider},
+		{common.ChannelInApp, config.InAppProvider},
+	} {
+		provider, err := NewProvider(selected.channel, selected.name, config)
+		if err != nil {
+			return err
+		}
+		SetProvider(selected.channel, provider)
+		slog.Info("notification provider initialized", "channel", selected.channel, "provider", provider.Name())
+	}
+	return nil
+}
+
+// NewProvider returns the provider called name for channel, defaulting to
+// the log provider when none is configured. SMTP only delivers email.
+func NewProvider(channel, name string, config ProviderConfig) (Provider, error) {
+	switch strings.ToLower(name) {
+	case "", ProviderLog:
+		return &logProvider{}, nil
+	case ProviderSMTP:
+		if channel != common.ChannelEmail {
+			return nil, fmt.Errorf("notification provider %q cannot deliver %s", name, channel)
+		}
+		return NewSMTPProvider(config)
+	default:
+		return nil, fmt.Errorf("unknown %s notification provider %q", channel, name)
+	}
+}
+
+func SetProvider(channel string, provider Provider) {
+	providers[channel] = provider
+}
+
+// GetProvider returns the provider of channel, nil for an unknown channel
+func GetProvider(channel string) Provider {
+	return providers[channel]
+}
+
+// logProvider only logs the notification, useful for local development
+type logProvider struct{}
+
+func (p *logProvider) Name() string {
+	return ProviderLog
+}
+
+func (p *logProvider) Send(ctx context.Context, to string, payload NotificationPayload) error {
+	slog.Info("logProvider:send",
+		slog.String("channel", payload.Channel),
+		slog.String("to", to),
+		slog.String("subject", payload.Subject),
+		slog.String("template", payload.Name))
+	return nil
+}
+
+func (p *logProvider) Close() error {
+	return nil
+}
@@ -0,0 +1,166 @@
+package communicator
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// TimezoneContextKey is the audience context key holding the IANA timezone of
+// the recipient, e.g. Europe/Berlin
+const TimezoneContextKey = "timezone"
+
+// QuietHoursCategoryAll is the quiet hours entry of categories without their own
+const QuietHoursCategoryAll = "all"
+
+// QuietHoursConfig holds recipients back during quiet hours in their local
+// time, per template category, e.g. QUIET_HOURS=marketing=21:00-09:00,all=23:00-07:00.
+// DEFAULT_TIMEZONE is used for recipients without a timezone and defaults to UTC.
+type QuietHoursConfig struct {
+	QuietHours      string `json:"QUIET_HOURS"`
+	DefaultTimezone string `json:"DEFAULT_TIMEZONE"`
+}
+
+// QuietWindow is a daily period of local time in which recipients are not
+// notified, Start and End are offsets from midnight. A window ending before it
+// starts spans midnight.
+type QuietWindow struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+var (
+	quietHours      = map[string]QuietWindow{}
+	defaultLocation = time.UTC
+)
+
+// InitQuietHours makes the quiet hours of config the ones checked by
+// NotificationType.Validate. It should be called once during startup.
+func InitQuietHours(config QuietHoursConfig) error {
+	windows, err := ParseQuietHours(config.QuietHours)
+	if err != nil {
+		return err
+	}
+	location := time.UTC
+	if config.DefaultTimezone != "" {
+		if location, err = time.LoadLocation(config.DefaultTimezone); err != nil {
+			return fmt.Errorf("invalid DEFAULT_TIMEZONE %q: %v", config.DefaultTimezone, err)
+		}
+	}
+	quietHours, defaultLocation = windows, location
+	return nil
+}
+
+// ParseQuietHours parses comma separated category=HH:MM-HH:MM windows
+func ParseQuietHours(value string) (map[string]QuietWindow, error) {
+	windows := map[string]QuietWindow{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		category, period, ok := strings.Cut(entry, "=")
+		start, end, hasEnd := strings.Cut(period, "-")
+		category = strings.ToLower(strings.TrimSpace(category))
+		if !ok || !hasEnd || category == "" {
+			return nil, fmt.Errorf("invalid QUIET_HOURS entry %q, expected category=HH:MM-HH:MM", entry)
+		}
+		var window QuietWindow
+		var err error
+		if window.Start, err = ParseClock(start); err != nil {
+			return nil, fmt.Errorf("invalid QUIET_HOURS entry %q: %v", entry, err)
+		}
+		if window.End, err = ParseClock(end); err != nil {
+			return nil, fmt.Errorf("invalid QUIET_HOURS entry %q: %v", entry, err)
+		}
+		if window.Start == window.End {
+			return nil, fmt.Errorf("invalid QUIET_HOURS entry %q, the window is empty", entry)
+		}
+		windows[category] = window
+	}
+	return windows, nil
+}
+
+// ParseClock parses a HH:MM time of day into its offset from midnight
+func ParseClock(value string) (time.Duration, error) {
+	clock, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q, expected HH:MM", value)
+	}
+	return time.Duration(clock.Hour())*time.Hour + time.Duration(clock.Minute())*time.Minute, nil
+}
+
+// Until returns when the window ends if now falls inside it, in the location of now
+func (w QuietWindow) Until(now time.Time) (time.Time, bool) {
+	clock := time.Duration(now.Hour())*time.Hour + time.Duration(now.Minute())*time.Minute + time.Duration(now.Second())*time.Second
+	switch {
+	case w.Start < w.End && clock >= w.Start && clock < w.End:
+		return atClock(now, 0, w.End), true
+	case w.Start > w.End && clock >= w.Start:
+		return atClock(now, 1, w.End), true
+	case w.Start > w.End && clock < w.End:
+		return atClock(now, 0, w.End), true
+	default:
+		return time.Time{}, false
+	}
+}
+
+// NextClock returns the first time at or after after which is clock in the location of after
+func NextClock(after time.Time, clock time.Duration) time.Time {
+	next := atClock(after, 0, clock)
+	if next.Before(after) {
+		next = atClock(after, 1, clock)
+	}
+	return next
+}
+
+// atClock returns the wall time clock days after the date of t, so days
+// with a daylight saving change keep the local time
+func atClock(t time.Time, days int, clock time.Duration) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day+days, int(clock/time.Hour), int(clock%time.Hour/time.Minute), 0, 0, t.Location())
+}
+
+// location returns the timezone of the recipient, the default timezone when
+// they have none or it is unknown
+func (n *NotificationType) location() *time.Location {
+	timezone := n.Context[TimezoneContextKey]
+	if timezone == "" {
+		return defaultLocation
+	}
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		slog.Warn("location:unknownTimezone",
+			slog.String("request_id", n.RequestId),
+			slog.String("timezone", timezone))
+		return defaultLocation
+	}
+	return location
+}
+
+// deferral returns until when the recipient must be held back at now, either
+// for the local send time of the request or for the quiet hours of the category
+func (n *NotificationType) deferral(now time.Time) (time.Time, string, bool) {
+	local := now.In(n.location())
+	if n.LocalTime != "" && !n.DispatchedAt.IsZero() {
+		clock, err := ParseClock(n.LocalTime)
+		if err == nil {
+			if sendAt := NextClock(n.DispatchedAt.In(local.Location()), clock); local.Before(sendAt) {
+				return sendAt, fmt.Sprintf("waiting for %s local time", n.LocalTime), true
+			}
+		}
+	}
+
+	window, ok := quietHours[n.category()]
+	if !ok {
+		window, ok = quietHours[QuietHoursCategoryAll]
+	}
+	if !ok {
+		return time.Time{}, "", false
+	}
+	if until, quiet := window.Until(local); quiet {
+		return until, "quiet hours of the recipient", true
+	}
+	return time.Time{}, "", false
+}
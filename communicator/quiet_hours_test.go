@@ -0,0 +1,82 @@
+package communicator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseQuietHours(t *testing.T) {
+	windows, err := ParseQuietHours("Marketing=21:00-09:00, all=23:30-07:00")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]QuietWindow{
+		"marketing": {Start: 21 * time.Hour, End: 9 * time.Hour},
+		"all":       {Start: 23*time.Hour + 30*time.Minute, End: 7 * time.Hour},
+	}, windows)
+
+	for _, invalid := range []string{"marketing", "marketing=21:00", "=21:00-09:00", "marketing=25:00-09:00", "marketing=9-10", "marketing=09:00-09:00"} {
+		_, err := ParseQuietHours(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestQuietWindow_Until(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	require.NoError(t, err)
+	overnight := QuietWindow{Start: 21 * time.Hour, End: 9 * time.Hour}
+	daytime := QuietWindow{Start: 12 * time.Hour, End: 14 * time.Hour}
+
+	tests := []struct {
+		name      string
+		window    QuietWindow
+		now       time.Time
+		wantUntil time.Time
+		wantQuiet bool
+	}{
+		{"before midnight", overnight, time.Date(2030, 3, 1, 22, 0, 0, 0, berlin), time.Date(2030, 3, 2, 9, 0, 0, 0, berlin), true},
+		{"after midnight", overnight, time.Date(2030, 3, 2, 3, 0, 0, 0, berlin), time.Date(2030, 3, 2, 9, 0, 0, 0, berlin), true},
+		{"window end", overnight, time.Date(2030, 3, 2, 9, 0, 0, 0, berlin), time.Time{}, false},
+		{"daytime", overnight, time.Date(2030, 3, 2, 15, 0, 0, 0, berlin), time.Time{}, false},
+		{"inside daytime window", daytime, time.Date(2030, 3, 2, 13, 0, 0, 0, berlin), time.Date(2030, 3, 2, 14, 0, 0, 0, berlin), true},
+		{"across daylight saving", overnight, time.Date(2030, 3, 30, 23, 0, 0, 0, berlin), time.Date(2030, 3, 31, 9, 0, 0, 0, berlin), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			until, quiet := tt.window.Until(tt.now)
+			assert.Equal(t, tt.wantQuiet, quiet)
+			assert.True(t, tt.wantUntil.Equal(until), "until %s", until)
+		})
+	}
+}
+
+func TestNotificationType_Deferral(t *testing.T) {
+	defer func(windows map[string]QuietWindow) { quietHours = windows }(quietHours)
+	quietHours = map[string]QuietWindow{"marketing": {Start: 21 * time.Hour, End: 9 * time.Hour}}
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	require.NoError(t, err)
+
+	// 23:00 in Tokyo, 14:00 UTC
+	now := time.Date(2030, 3, 1, 14, 0, 0, 0, time.UTC)
+	recipient := NotificationType{Category: "marketing", Context: map[string]string{TimezoneContextKey: "Asia/Tokyo"}}
+	until, _, deferred := recipient.deferral(now)
+	assert.True(t, deferred)
+	assert.True(t, time.Date(2030, 3, 2, 9, 0, 0, 0, tokyo).Equal(until))
+
+	otherCategory := NotificationType{Category: "general", Context: map[string]string{TimezoneContextKey: "Asia/Tokyo"}}
+	_, _, deferred = otherCategory.deferral(now)
+	assert.False(t, deferred)
+
+	// without a timezone the default one is used, 14:00 UTC is not quiet
+	unknown := NotificationType{Category: "marketing", Context: map[string]string{TimezoneContextKey: "Mars/Olympus"}}
+	_, _, deferred = unknown.deferral(now)
+	assert.False(t, deferred)
+
+	localTime := NotificationType{LocalTime: "18:00", DispatchedAt: now, Context: map[string]string{TimezoneContextKey: "Europe/Berlin"}}
+	until, _, deferred = localTime.deferral(now)
+	assert.True(t, deferred)
+	assert.True(t, time.Date(2030, 3, 1, 17, 0, 0, 0, time.UTC).Equal(until))
+	_, _, deferred = localTime.deferral(until)
+	assert.False(t, deferred)
+}
@@ -0,0 +1,328 @@
+package communicator
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kp/pager/common"
+)
+
+const (
+	SMTPTLSModeNone     = "none"
+	SMTPTLSModeStartTLS = "starttls"
+	SMTPTLSModeImplicit = "tls"
+)
+
+var (
+	defaultSMTPPoolSize    = 5
+	defaultSMTPDialTimeout = 10 * time.Second
+	defaultSMTPSendTimeout = 30 * time.Second
+	// connections idle for longer than this are closed instead of reused,
+	// most servers drop idle clients after a minute or so
+	defaultSMTPIdleTimeout = 30 * time.Second
+)
+
+type smtpProvider struct {
+	host      string
+	addr      string
+	from      string
+	envelope  string
+	tlsMode   string
+	tlsConfig *tls.Config
+	auth      smtp.Auth
+	pool      chan *smtpConn
+}
+
+type smtpConn struct {
+	conn     net.Conn
+	client   *smtp.Client
+	lastUsed time.Time
+}
+
+// NewSMTPProvider creates a provider which delivers mail through the
+// configured SMTP server, keeping up to SMTP_POOL_SIZE connections open
+// between sends.
+func NewSMTPProvider(config ProviderConfig) (Provider, error) {
+	if config.SMTPHost == "" {
+		return nil, errors.New("SMTP_HOST is required for the smtp provider")
+	}
+	from, err := mail.ParseAddress(config.SMTPFrom)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SMTP_FROM %q: %v", config.SMTPFrom, err)
+	}
+
+	tlsMode := strings.ToLower(config.SMTPTLSMode)
+	if tlsMode == "" {
+		tlsMode = SMTPTLSModeStartTLS
+	}
+	port := config.SMTPPort
+	switch tlsMode {
+	case SMTPTLSModeImplicit:
+		if port == "" {
+			port = "465"
+		}
+	case SMTPTLSModeStartTLS, SMTPTLSModeNone:
+		if port == "" {
+			port = "587"
+		}
+	default:
+		return nil, fmt.Errorf("invalid SMTP_TLS_MODE %q", config.SMTPTLSMode)
+	}
+
+	poolSize := defaultSMTPPoolSize
+	if config.SMTPPoolSize != "" {
+		poolSize, err = strconv.Atoi(config.SMTPPoolSize)
+		if err != nil || poolSize < 1 {
+			return nil, fmt.Errorf("invalid SMTP_POOL_SIZE %q", config.SMTPPoolSize)
+		}
+	}
+
+	provider := &smtpProvider{
+		host:      config.SMTPHost,
+		addr:      net.JoinHostPort(config.SMTPHost, port),
+		from:      from.String(),
+		envelope:  from.Address,
+		tlsMode:   tlsMode,
+		tlsConfig: &tls.Config{ServerName: config.SMTPHost},
+		pool:      make(chan *smtpConn, poolSize),
+	}
+	if config.SMTPUsername != "" {
+		provider.auth = smtp.PlainAuth("", config.SMTPUsername, config.SMTPPassword, config.SMTPHost)
+	}
+	return provider, nil
+}
+
+func (p *smtpProvider) Name() string {
+	return ProviderSMTP
+}
+
+func (p *smtpProvider) Send(ctx context.Context, to string, payload NotificationPayload) error {
+	message, err := buildMailMessage(p.from, to, payload)
+	if err != nil {
+		return err
+	}
+
+	conn, err := p.acquire(ctx)
+	if err != nil {
+		return err
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(defaultSMTPSendTimeout)
+	}
+	conn.conn.SetDeadline(deadline)
+
+	if err := p.deliver(conn.client, to, message); err != nil {
+		// the connection state is unknown after a failed transaction
+		conn.client.Close()
+		return err
+	}
+	p.release(conn)
+	return nil
+}
+
+// Close closes every pooled connection
+func (p *smtpProvider) Close() error {
+	for {
+		select {
+		case conn := <-p.pool:
+			conn.client.Quit()
+		default:
+			return nil
+		}
+	}
+}
+
+func (p *smtpProvider) deliver(client *smtp.Client, to string, message []byte) error {
+	if err := client.Mail(p.envelope); err != nil {
+		return fmt.Errorf("smtp MAIL FROM failed: %v", err)
+	}
+	if err := client.Rcpt(to); err != nil {
+		return fmt.Errorf("smtp RCPT TO failed: %v", err)
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("smtp DATA failed: %v", err)
+	}
+	if _, err := writer.Write(message); err != nil {
+		return fmt.Errorf("smtp write failed: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("smtp message rejected: %v", err)
+	}
+	return nil
+}
+
+// acquire returns a pooled connection which is still alive, or dials a new one
+func (p *smtpProvider) acquire(ctx context.Context) (*smtpConn, error) {
+	for {
+		select {
+		case conn := <-p.pool:
+			if time.Since(conn.lastUsed) > defaultSMTPIdleTimeout {
+				conn.client.Close()
+				continue
+			}
+			conn.conn.SetDeadline(time.Now().Add(defaultSMTPDialTimeout))
+			if err := conn.client.Noop(); err != nil {
+				conn.client.Close()
+				continue
+			}
+			return conn, nil
+		default:
+			return p.dial(ctx)
+		}
+	}
+}
+
+func (p *smtpProvider) release(conn *smtpConn) {
+	if err := conn.client.Reset(); err != nil {
+		conn.client.Close()
+		return
+	}
+	conn.lastUsed = time.Now()
+	select {
+	case p.pool <- conn:
+	default:
+		conn.client.Quit()
+	}
+}
+
+func (p *smtpProvider) dial(ctx context.Context) (*smtpConn, error) {
+	dialer := &net.Dialer{Timeout: defaultSMTPDialTimeout}
+	var (
+		conn net.Conn
+		err  error
+	)
+	if p.tlsMode == SMTPTLSModeImplicit {
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: p.tlsConfig}
+		conn, err = tlsDialer.DialContext(ctx, "tcp", p.addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", p.addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to smtp server %s: %v", p.addr, err)
+	}
+	conn.SetDeadline(time.Now().Add(defaultSMTPDialTimeout))
+
+	client, err := smtp.NewClient(conn, p.host)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("smtp handshake failed: %v", err)
+	}
+
+	if p.tlsMode == SMTPTLSModeStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			client.Close()
+			return nil, fmt.Errorf("smtp server %s does not support STARTTLS", p.addr)
+		}
+		if err := client.StartTLS(p.tlsConfig); err != nil {
+			client.Close()
+			return nil, fmt.Errorf("smtp STARTTLS failed: %v", err)
+		}
+	}
+
+	if p.auth != nil {
+		if err := client.Auth(p.auth); err != nil {
+			client.Close()
+			return nil, fmt.Errorf("smtp authentication failed: %v", err)
+		}
+	}
+
+	return &smtpConn{conn: conn, client: client, lastUsed: time.Now()}, nil
+}
+
+// buildMailMessage renders the payload as a quoted-printable HTML mail, with
+// a plaintext alternative and unsubscribe headers when the payload has them
+func buildMailMessage(from, to string, payload NotificationPayload) ([]byte, error) {
+	var body bytes.Buffer
+	contentType := `text/html; charset="UTF-8"`
+	if payload.Text == "" {
+		if err := writeQuotedPrintable(&body, payload.Body); err != nil {
+			return nil, err
+		}
+	} else {
+		parts := multipart.NewWriter(&body)
+		contentType = fmt.Sprintf("multipart/alternative; boundary=%q", parts.Boundary())
+		// clients show the last alternative they support, so HTML goes last
+		for _, alternative := range []struct{ contentType, content string }{
+			{`text/plain; charset="UTF-8"`, payload.Text},
+			{`text/html; charset="UTF-8"`, payload.Body},
+		} {
+			part, err := parts.CreatePart(textproto.MIMEHeader{
+				"Content-Type":              {alternative.contentType},
+				"Content-Transfer-Encoding": {"quoted-printable"},
+			})
+			if err != nil {
+				return nil, err
+			}
+			if err := writeQuotedPrintable(part, alternative.content); err != nil {
+				return nil, err
+			}
+		}
+		if err := parts.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	var message bytes.Buffer
+	headers := []struct{ key, value string }{
+		{"From", from},
+		{"To", to},
+		{"Subject", mime.QEncoding.Encode("utf-8", payload.Subject)},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"Message-ID", fmt.Sprintf("<%s@%s>", common.GenerateUUID(), messageIDDomain(from))},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", contentType},
+	}
+	if payload.Text == "" {
+		headers = append(headers, struct{ key, value string }{"Content-Transfer-Encoding", "quoted-printable"})
+	}
+	if payload.UnsubscribeURL != "" {
+		// RFC 8058 one-click unsubscribe, mail clients POST to the link
+		headers = append(headers,
+			struct{ key, value string }{"List-Unsubscribe", "<" + payload.UnsubscribeURL + ">"},
+			struct{ key, value string }{"List-Unsubscribe-Post", "List-Unsubscribe=One-Click"},
+		)
+	}
+	for _, header := range headers {
+		if strings.ContainsAny(header.value, "\r\n") {
+			return nil, fmt.Errorf("invalid %s header value", header.key)
+		}
+		fmt.Fprintf(&message, "%s: %s\r\n", header.key, header.value)
+	}
+	message.WriteString("\r\n")
+	message.Write(body.Bytes())
+	return message.Bytes(), nil
+}
+
+func writeQuotedPrintable(w io.Writer, content string) error {
+	writer := quotedprintable.NewWriter(w)
+	if _, err := writer.Write([]byte(content)); err != nil {
+		return err
+	}
+	return writer.Close()
+}
+
+func messageIDDomain(from string) string {
+	if address, err := mail.ParseAddress(from); err == nil {
+		if at := strings.LastIndex(address.Address, "@"); at >= 0 {
+			return address.Address[at+1:]
+		}
+	}
+	return "localhost"
+}
@@ -0,0 +1,234 @@
+package communicator
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type sinkMessage struct {
+	from string
+	to   []string
+	data string
+}
+
+// smtpSink is a minimal in-process SMTP server which records every message
+type smtpSink struct {
+	listener    net.Listener
+	mu          sync.Mutex
+	connections int
+	authed      bool
+	messages    []sinkMessage
+}
+
+func newSMTPSink(t *testing.T) *smtpSink {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	sink := &smtpSink{listener: listener}
+	go sink.serve()
+	t.Cleanup(func() { listener.Close() })
+	return sink
+}
+
+func (s *smtpSink) port() string {
+	return strings.TrimPrefix(s.listener.Addr().String(), "127.0.0.1:")
+}
+
+func (s *smtpSink) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.connections++
+		s.mu.Unlock()
+		go s.handle(conn)
+	}
+}
+
+func (s *smtpSink) handle(conn net.Conn) {
+	defer conn.Close()
+	tp := textproto.NewConn(conn)
+	tp.PrintfLine("220 localhost ESMTP sink")
+	var message sinkMessage
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(command, "EHLO"):
+			tp.PrintfLine("250-localhost")
+			tp.PrintfLine("250 AUTH PLAIN")
+		case strings.HasPrefix(command, "AUTH"):
+			s.mu.Lock()
+			s.authed = true
+			s.mu.Unlock()
+			tp.PrintfLine("235 2.7.0 Authentication successful")
+		case strings.HasPrefix(command, "MAIL FROM:"):
+			message = sinkMessage{from: strings.Trim(line[len("MAIL FROM:"):], "<> ")}
+			tp.PrintfLine("250 OK")
+		case strings.HasPrefix(command, "RCPT TO:"):
+			message.to = append(message.to, strings.Trim(line[len("RCPT TO:"):], "<> "))
+			tp.PrintfLine("250 OK")
+		case command == "DATA":
+			tp.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+			data, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			message.data = string(data)
+			s.mu.Lock()
+			s.messages = append(s.messages, message)
+			s.mu.Unlock()
+			tp.PrintfLine("250 OK")
+		case command == "RSET", command == "NOOP":
+			tp.PrintfLine("250 OK")
+		case command == "QUIT":
+			tp.PrintfLine("221 Bye")
+			return
+		default:
+			tp.PrintfLine("502 Command not implemented")
+		}
+	}
+}
+
+func TestSMTPProvider_Send(t *testing.T) {
+	sink := newSMTPSink(t)
+	provider, err := NewSMTPProvider(ProviderConfig{
+		SMTPHost:     "127.0.0.1",
+		SMTPPort:     sink.port(),
+		SMTPUsername: "pager",
+		SMTPPassword: "secret",
+		SMTPFrom:     "Pager <no-reply@example.com>",
+		SMTPTLSMode:  SMTPTLSModeNone,
+		SMTPPoolSize: "1",
+	})
+	require.NoError(t, err)
+	defer provider.Close()
+
+	ctx := context.Background()
+	for _, to := range []string{"first@example.com", "second@example.com"} {
+		err := provider.Send(ctx, to, NotificationPayload{
+			Subject: "Welcome",
+			Body:    "<p>Hello there</p>",
+			Name:    "welcome",
+		})
+		require.NoError(t, err)
+	}
+
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	assert.True(t, sink.authed)
+	assert.Equal(t, 1, sink.connections, "connection should be reused from the pool")
+	require.Len(t, sink.messages, 2)
+	assert.Equal(t, "no-reply@example.com", sink.messages[0].from)
+	assert.Equal(t, []string{"first@example.com"}, sink.messages[0].to)
+	assert.Equal(t, []string{"second@example.com"}, sink.messages[1].to)
+	assert.Contains(t, sink.messages[0].data, "Subject: Welcome")
+	assert.Contains(t, sink.messages[0].data, "<p>Hello there</p>")
+}
+
+func TestNewSMTPProvider_InvalidConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config ProviderConfig
+		errMsg string
+	}{
+		{
+			name:   "missing host",
+			config: ProviderConfig{SMTPFrom: "no-reply@example.com"},
+			errMsg: "SMTP_HOST is required",
+		},
+		{
+			name:   "invalid from",
+			config: ProviderConfig{SMTPHost: "localhost", SMTPFrom: "not an address"},
+			errMsg: "invalid SMTP_FROM",
+		},
+		{
+			name:   "invalid tls mode",
+			config: ProviderConfig{SMTPHost: "localhost", SMTPFrom: "no-reply@example.com", SMTPTLSMode: "ssl3"},
+			errMsg: "invalid SMTP_TLS_MODE",
+		},
+		{
+			name:   "invalid pool size",
+			config: ProviderConfig{SMTPHost: "localhost", SMTPFrom: "no-reply@example.com", SMTPPoolSize: "0"},
+			errMsg: "invalid SMTP_POOL_SIZE",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewSMTPProvider(tt.config)
+			assert.Error(t, err)
+			assert.Contains(t, err.Error(), tt.errMsg)
+		})
+	}
+}
+
+func TestBuildMailMessage_RejectsHeaderInjection(t *testing.T) {
+	_, err := buildMailMessage("no-reply@example.com", "victim@example.com\r\nBcc: other@example.com", NotificationPayload{})
+	assert.Error(t, err)
+}
+
+func TestBuildMailMessage_PlaintextAlternative(t *testing.T) {
+	message, err := buildMailMessage("no-reply@example.com", "user@example.com", NotificationPayload{
+		Channel: "email",
+		Subject: "Welcome",
+		Body:    "<p>Hello there</p>",
+		Text:    "Hello there",
+	})
+	require.NoError(t, err)
+
+	parsed, err := mail.ReadMessage(bytes.NewReader(message))
+	require.NoError(t, err)
+	mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	require.NoError(t, err)
+	assert.Equal(t, "multipart/alternative", mediaType)
+
+	reader := multipart.NewReader(parsed.Body, params["boundary"])
+	var types []string
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		types = append(types, part.Header.Get("Content-Type"))
+	}
+	assert.Equal(t, []string{`text/plain; charset="UTF-8"`, `text/html; charset="UTF-8"`}, types)
+}
+
+func TestBuildMailMessage_UnsubscribeHeaders(t *testing.T) {
+	message, err := buildMailMessage("no-reply@example.com", "user@example.com", NotificationPayload{
+		Channel:        "email",
+		Subject:        "Weekly digest",
+		Body:           "<p>News</p>",
+		UnsubscribeURL: "https://pager.example.com/pager/v1/unsubscribe/?token=abc.def",
+	})
+	require.NoError(t, err)
+
+	parsed, err := mail.ReadMessage(bytes.NewReader(message))
+	require.NoError(t, err)
+	assert.Equal(t, "<https://pager.example.com/pager/v1/unsubscribe/?token=abc.def>", parsed.Header.Get("List-Unsubscribe"))
+	assert.Equal(t, "List-Unsubscribe=One-Click", parsed.Header.Get("List-Unsubscribe-Post"))
+
+	message, err = buildMailMessage("no-reply@example.com", "user@example.com", NotificationPayload{Body: "<p>News</p>"})
+	require.NoError(t, err)
+	parsed, err = mail.ReadMessage(bytes.NewReader(message))
+	require.NoError(t, err)
+	assert.Empty(t, parsed.Header.Get("List-Unsubscribe"))
+}
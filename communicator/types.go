@@ -2,20 +2,32 @@ package communicator
 
 import (
 	"context"
+	"time"
 
 	"github.com/jinzhu/gorm"
 	"github.com/kp/pager/common"
 )
 
 type NotificationType struct {
-	To         string            `json:"to"`
-	TemplateID int64             `json:"template_id"`
-	RequestId  string            `json:"request_id"`
-	Subject    string            `json:"subject"`
-	Body       string            `json:"body"`
-	Context    map[string]string `json:"context"`
-	SessionID  int64             `json:"session_id"`
-	LogID      int64             `json:"log_id"`
+	To         string `json:"to"`
+	TemplateID int64  `json:"template_id"`
+	// TemplateVersion is the pinned version to render, 0 renders the current one
+	TemplateVersion int `json:"template_version,omitempty"`
+	// Channel is the channel chosen by the request, or per recipient the
+	// channel they are notified on. Channels lists the channels the template supports.
+	Channel  string   `json:"channel,omitempty"`
+	Channels []string `json:"channels,omitempty"`
+	// Category of the template, recipients who unsubscribed from it are skipped
+	Category string `json:"category,omitempty"`
+	// LocalTime delivers at the first HH:MM in the recipient's timezone after DispatchedAt
+	LocalTime    string            `json:"local_time,omitempty"`
+	DispatchedAt time.Time         `json:"dispatched_at,omitempty"`
+	RequestId    string            `json:"request_id"`
+	Subject      string            `json:"subject"`
+	Body         string            `json:"body"`
+	Context      map[string]string `json:"context"`
+	SessionID    int64             `json:"session_id"`
+	LogID        int64             `json:"log_id"`
 }
 
 type CommunicationHandler interface {
@@ -35,13 +47,22 @@ type communicator struct {
 	NotificationHanlder CommunicatorNotificationHandler
 }
 
-func NewCommunicatornNotificationSevice(notification NotificationType, to string, context map[string]string) CommunicatorNotificationHandler {
+// NewCommunicatornNotificationSevice prepares the notification of the batch
+// for one audience, addressed on the channel resolved for them
+func NewCommunicatornNotificationSevice(notification NotificationType, audience common.AudienceType) CommunicatorNotificationHandler {
+	channel := resolveChannel(notification.Channel, notification.Channels, audience)
 	return &NotificationType{
-		To:         to,
-		TemplateID: notification.TemplateID,
-		RequestId:  notification.RequestId,
-		Context:    notification.Context,
-		SessionID:  notification.SessionID,
+		To:              audience.Address(channel),
+		TemplateID:      notification.TemplateID,
+		TemplateVersion: notification.TemplateVersion,
+		Channel:         channel,
+		Category:        notification.Category,
+		LocalTime:       notification.LocalTime,
+		DispatchedAt:    notification.DispatchedAt,
+		RequestId:       notification.RequestId,
+		Context:         audience.Context,
+		SessionID:       notification.SessionID,
+		LogID:           notification.LogID,
 	}
 }
 
@@ -53,10 +74,40 @@ type QMessage struct {
 	BatchID      string                `json:"batch_id"`
 	GenericModel NotificationType      `json:"model"`
 	Audiences    []common.AudienceType `json:"audiences"`
+	// Attempt is the number of delivery attempts already made for the audiences
+	Attempt int `json:"attempt,omitempty"`
+	// NotBefore delays processing of retried messages until the backoff expires
+	NotBefore time.Time `json:"not_before,omitempty"`
+	// Deferred messages hold recipients back without a failed attempt, see DeferredError
+	Deferred    bool   `json:"deferred,omitempty"`
+	ErrorReason string `json:"error_reason,omitempty"`
 }
 
+// NotificationPayload is a notification rendered for one channel. Subject is
+// the email subject or the push title, Body the email HTML, SMS text, push
+// body or in-app message, and Text the plaintext alternative of an email.
+// UnsubscribeURL is the recipient's one-click unsubscribe link when configured.
 type NotificationPayload struct {
-	Subject string `json:"subject"`
-	Body    string `json:"body"`
-	Name    string `json:"name"`
+	Channel        string `json:"channel"`
+	Subject        string `json:"subject,omitempty"`
+	Body           string `json:"body"`
+	Text           string `json:"text,omitempty"`
+	Name           string `json:"name"`
+	UnsubscribeURL string `json:"unsubscribe_url,omitempty"`
+}
+
+// ProviderConfig selects and configures the providers used to deliver notifications
+// per channel. NOTIFICATION_PROVIDER delivers email.
+type ProviderConfig struct {
+	Provider      string `json:"NOTIFICATION_PROVIDER"`
+	SMSProvider   string `json:"SMS_PROVIDER"`
+	PushProvider  string `json:"PUSH_PROVIDER"`
+	InAppProvider string `json:"IN_APP_PROVIDER"`
+	SMTPHost      string `json:"SMTP_HOST"`
+	SMTPPort      string `json:"SMTP_PORT"`
+	SMTPUsername  string `json:"SMTP_USERNAME"`
+	SMTPPassword  string `json:"SMTP_PASSWORD"`
+	SMTPFrom      string `json:"SMTP_FROM"`
+	SMTPTLSMode   string `json:"SMTP_TLS_MODE"`
+	SMTPPoolSize  string `json:"SMTP_POOL_SIZE"`
 }
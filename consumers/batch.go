@@ -5,86 +5,191 @@ import (
 	"encoding/json"
 	"fmt"
 	"log/slog"
-	"os"
-	"os/signal"
+	"strconv"
 	"sync"
-	"syscall"
+	"time"
 
-	"github.com/confluentinc/confluent-kafka-go/kafka"
+	confluent "github.com/confluentinc/confluent-kafka-go/kafka"
 	"github.com/kp/pager/common"
 	"github.com/kp/pager/communicator"
+	"github.com/kp/pager/databases/kafka"
+	"github.com/kp/pager/databases/sql"
+	"github.com/kp/pager/metrics"
+	"github.com/kp/pager/notification"
+	"github.com/kp/pager/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
-// StartBatchConsumer starts a Kafka consumer for notification_batch topic
-func StartBatchConsumer(brokers []string) {
-	config := &kafka.ConfigMap{
+// messageHandler processes the raw value of one queued message
+type messageHandler func(ctx context.Context, value []byte) error
+
+func batchMessageHandler(retryHandler *RetryHandler) messageHandler {
+	return func(ctx context.Context, value []byte) error {
+		return ProcessBatchMessages(ctx, value, retryHandler)
+	}
+}
+
+// closeRetryHandler flushes requeued recipients which are still waiting for delivery
+func closeRetryHandler(retryHandler *RetryHandler) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := kafka.CloseProducer(ctx, retryHandler.producer); err != nil {
+		slog.Error("worker:producerFlushFailed", slog.Any("error", err))
+	}
+}
+
+// runKafkaConsumer reads topic until ctx is cancelled, handing up to
+// concurrency messages at a time to handle. Offsets are committed once a
+// message and all messages before it on its partition have been handled.
+// Delayed messages are published once due, see delayedKafkaProducer.
+func runKafkaConsumer(ctx, workCtx context.Context, brokers []string, groupID, topic string, concurrency int, handle messageHandler) error {
+	config := &confluent.ConfigMap{
 		"bootstrap.servers":  brokers[0],
-		"group.id":           "go-kafka-consumer",
+		"group.id":           groupID,
 		"auto.offset.reset":  "earliest",
-		"enable.auto.commit": "true",
+		"enable.auto.commit": "false",
 	}
 
-	consumer, err := kafka.NewConsumer(config)
+	consumer, err := confluent.NewConsumer(config)
 	if err != nil {
-		fmt.Printf("Failed to create consumer: %s\n", err)
-		os.Exit(1)
+		return fmt.Errorf("failed to create consumer: %w", err)
 	}
 	defer consumer.Close()
 
-	topic := "notification_batch"
-	err = consumer.SubscribeTopics([]string{topic}, nil)
+	tracker := newOffsetTracker()
+	commit := func() {
+		offsets := tracker.ready()
+		if len(offsets) == 0 {
+			return
+		}
+		if _, err := consumer.CommitOffsets(offsets); err != nil {
+			slog.Error("worker:commitFailed",
+				slog.String("topic", topic),
+				slog.Any("error", err))
+		}
+	}
+
+	err = consumer.SubscribeTopics([]string{topic}, func(c *confluent.Consumer, event confluent.Event) error {
+		if revoked, ok := event.(confluent.RevokedPartitions); ok {
+			// commit what is done before another consumer takes over
+			commit()
+			tracker.revoke(revoked.Partitions)
+		}
+		return nil
+	})
 	if err != nil {
-		fmt.Printf("Failed to subscribe to topic: %s\n", err)
-		os.Exit(1)
+		return fmt.Errorf("failed to subscribe to topic %s: %w", topic, err)
 	}
-	fmt.Printf("Subscribed to topic: %s\n", topic)
+	slog.Info("worker:subscribed", slog.String("topic", topic), slog.String("group", groupID))
 
-	// Graceful shutdown
-	sigchan := make(chan os.Signal, 1)
-	signal.Notify(sigchan, syscall.SIGINT, syscall.SIGTERM)
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, concurrency)
+	for ctx.Err() == nil {
+		commit()
 
-	run := true
-	for run {
 		select {
-		case sig := <-sigchan:
-			fmt.Printf("Caught signal %v: terminating\n", sig)
-			run = false
-		default:
-			msg, err := consumer.ReadMessage(100)
-			if err == nil {
-				if err := ProcessBatchMessages(context.Background(), msg); err != nil {
-					fmt.Printf("Failed to process message: %v\n", err)
-				}
-			} else {
-				// Only print real errors, not timeouts
-				if err.(kafka.Error).Code() != kafka.ErrTimedOut {
-					fmt.Printf("Error consuming message: %v\n", err)
-				}
+		case semaphore <- struct{}{}:
+		case <-ctx.Done():
+			continue
+		}
+		msg, err := consumer.ReadMessage(100 * time.Millisecond)
+		if err != nil {
+			<-semaphore
+			// Only log real errors, not timeouts
+			if kafkaErr, ok := err.(confluent.Error); !ok || kafkaErr.Code() != confluent.ErrTimedOut {
+				slog.Error("worker:consumeFailed",
+					slog.String("topic", topic),
+					slog.Any("error", err))
 			}
+			continue
 		}
+
+		tracker.start(msg.TopicPartition)
+		recordLag(consumer, msg.TopicPartition)
+		wg.Add(1)
+		go func(msg *confluent.Message) {
+			defer func() {
+				tracker.finish(msg.TopicPartition)
+				<-semaphore
+				wg.Done()
+			}()
+			// Continue the trace of the producer from the message headers
+			msgCtx := tracing.Extract(workCtx, kafka.NewHeaderCarrier(&msg.Headers))
+			err := handle(msgCtx, msg.Value)
+			metrics.MessagesConsumed.WithLabelValues(topic, metrics.Outcome(err)).Inc()
+			if err != nil {
+				slog.Error("worker:handleFailed",
+					slog.String("topic", topic),
+					slog.Int64("offset", int64(msg.TopicPartition.Offset)),
+					slog.Any("error", err))
+			}
+		}(msg)
 	}
+
+	slog.Info("worker:draining", slog.String("topic", topic))
+	wg.Wait()
+	commit()
+	return nil
 }
 
-func ProcessBatchMessages(ctx context.Context, message *kafka.Message) error {
+// recordLag reports the messages behind the high watermark of the partition,
+// the watermark is the one cached from the last fetch
+func recordLag(consumer *confluent.Consumer, tp confluent.TopicPartition) {
+	_, high, err := consumer.GetWatermarkOffsets(*tp.Topic, tp.Partition)
+	if err != nil || high < 0 {
+		return
+	}
+	lag := high - int64(tp.Offset) - 1
+	if lag < 0 {
+		lag = 0
+	}
+	metrics.ConsumerLag.WithLabelValues(*tp.Topic, strconv.Itoa(int(tp.Partition))).Set(float64(lag))
+}
+
+func ProcessBatchMessages(ctx context.Context, value []byte, retryHandler *RetryHandler) error {
+	ctx, span := tracing.Tracer().Start(ctx, "batch.process", trace.WithSpanKind(trace.SpanKindConsumer))
+	defer span.End()
+
 	// Parse message
 	var qMessage communicator.QMessage
-	if err := json.Unmarshal(message.Value, &qMessage); err != nil {
+	if err := json.Unmarshal(value, &qMessage); err != nil {
+		span.SetStatus(codes.Error, "invalid message")
 		return fmt.Errorf("failed to parse message: %v", err)
 	}
 
-	notification := qMessage.GenericModel
-	errChan := make(chan error, len(qMessage.Audiences))
+	notificationModel := qMessage.GenericModel
+	span.SetAttributes(
+		attribute.String(tracing.AttrBatchID, qMessage.BatchID),
+		attribute.String(tracing.AttrRequestID, notificationModel.RequestId),
+		attribute.Int64(tracing.AttrTemplateID, notificationModel.TemplateID),
+		attribute.Int(tracing.AttrAudiences, len(qMessage.Audiences)),
+		attribute.Int(tracing.AttrAttempt, qMessage.Attempt),
+	)
+	failures := make(chan recipientFailure, len(qMessage.Audiences))
 	var wg sync.WaitGroup
 
 	for _, audience := range qMessage.Audiences {
 		wg.Add(1)
 		go func(aud common.AudienceType) {
 			defer wg.Done()
-			notificationService := communicator.NewCommunicatornNotificationSevice(notification, aud.Email, aud.Context)
+			ctx, span := tracing.Tracer().Start(ctx, "recipient.deliver")
+			defer span.End()
+
+			notificationService := communicator.NewCommunicatornNotificationSevice(notificationModel, aud)
 			commService := communicator.NewCommunicationService(ctx, notificationService)
 
 			if err := commService.Run(ctx); err != nil {
-				errChan <- fmt.Errorf("failed to process audience %v: %w", aud, err)
+				if _, deferred := communicator.DeferredUntil(err); !deferred {
+					span.RecordError(err)
+					span.SetStatus(codes.Error, "delivery failed")
+				}
+				failure := recipientFailure{audience: aud, err: err}
+				if n, ok := notificationService.(*communicator.NotificationType); ok {
+					failure.logID = n.LogID
+				}
+				failures <- failure
 			}
 		}(audience)
 	}
@@ -92,17 +197,44 @@ func ProcessBatchMessages(ctx context.Context, message *kafka.Message) error {
 	// Wait for all goroutines to complete
 	go func() {
 		wg.Wait()
-		close(errChan)
+		close(failures)
 	}()
 
-	// Collect any errors
-	for err := range errChan {
-		if err != nil {
-			slog.Error("batch processing error",
+	// Hand failed recipients over to the retry topic or the dead-letter queue
+	var requeueErr error
+	for failure := range failures {
+		slog.Error("batch processing error",
+			slog.String("batch_id", qMessage.BatchID),
+			slog.String("email", failure.audience.Email),
+			slog.String("error", failure.err.Error()),
+		)
+		if err := retryHandler.handleFailure(ctx, qMessage, failure); err != nil {
+			slog.Error("batch processing requeue error",
+				slog.String("batch_id", qMessage.BatchID),
+				slog.String("email", failure.audience.Email),
 				slog.String("error", err.Error()),
 			)
+			requeueErr = err
 		}
 	}
 
-	return nil
+	// Track session progress, batches count once while retries and deferred
+	// recipients only refresh the status
+	sessionService := notification.NewNotificationSessionService(sql.PagerOrm)
+	requestID := notificationModel.RequestId
+	var err error
+	if qMessage.Attempt == 0 && !qMessage.Deferred {
+		err = sessionService.MarkBatchProcessed(ctx, requestID, qMessage.BatchID)
+	} else {
+		err = sessionService.Refresh(ctx, requestID)
+	}
+	if err != nil {
+		slog.Error("batch processing session update error",
+			slog.String("batch_id", qMessage.BatchID),
+			slog.String("request_id", requestID),
+			slog.String("error", err.Error()),
+		)
+	}
+
+	return requeueErr
 }
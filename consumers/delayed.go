@@ -0,0 +1,48 @@
+package consumers
+
+import (
+	"context"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/kp/pager/databases/kafka"
+	"github.com/kp/pager/databases/pgqueue"
+)
+
+// delayedKafkaProducer publishes due messages to Kafka and keeps the others in
+// the Postgres queue, which hands them out by due time. relayDelayedMessages
+// moves them to Kafka once due, so a message held for hours never delays the
+// ones due sooner.
+type delayedKafkaProducer struct {
+	kafka.KafkaProducer
+	delayed kafka.DelayedProducer
+}
+
+func newDelayedKafkaProducer(producer kafka.KafkaProducer, db *gorm.DB) *delayedKafkaProducer {
+	return &delayedKafkaProducer{
+		KafkaProducer: producer,
+		delayed:       pgqueue.NewPostgresProducer(db).(kafka.DelayedProducer),
+	}
+}
+
+// PublishAt publishes to Kafka at once when at has passed
+func (p *delayedKafkaProducer) PublishAt(ctx context.Context, topic string, data []byte, at time.Time) error {
+	if !at.After(time.Now()) {
+		return p.Publish(ctx, topic, data)
+	}
+	return p.delayed.PublishAt(ctx, topic, data, at)
+}
+
+// Close flushes the Kafka producer
+func (p *delayedKafkaProducer) Close(ctx context.Context) error {
+	return kafka.CloseProducer(ctx, p.KafkaProducer)
+}
+
+// relayDelayedMessages publishes the messages of topic kept in the Postgres
+// queue to Kafka once they are due, until ctx is cancelled. A message is only
+// removed from the queue once Kafka confirmed it.
+func relayDelayedMessages(ctx context.Context, db *gorm.DB, topic string, producer kafka.KafkaProducer) {
+	pgqueue.NewPostgresConsumer(db, topic).Run(ctx, ctx, func(msgCtx context.Context, value []byte) error {
+		return producer.Publish(msgCtx, topic, value)
+	})
+}
@@ -0,0 +1,96 @@
+package consumers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"github.com/kp/pager/common"
+	"github.com/kp/pager/communicator"
+	"github.com/kp/pager/databases/kafka"
+	"github.com/kp/pager/databases/pgqueue"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestQueueDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open("sqlite3", fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name()))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	require.NoError(t, db.AutoMigrate(&pgqueue.QueueJob{}).Error)
+	return db
+}
+
+func TestDelayedKafkaProducer_OutOfOrderDeferrals(t *testing.T) {
+	db := newTestQueueDB(t)
+	producer := &MockKafkaProducer{}
+	handler := NewRetryHandler(newDelayedKafkaProducer(producer, db), DefaultRetryPolicy)
+
+	batch := communicator.QMessage{
+		BatchID:      "batch1",
+		GenericModel: communicator.NotificationType{TemplateID: 1, RequestId: "req1"},
+	}
+	later := common.AudienceType{Email: "tokyo@example.com"}
+	sooner := common.AudienceType{Email: "london@example.com"}
+	deferrals := []struct {
+		audience common.AudienceType
+		until    time.Time
+	}{
+		// the recipient due later is deferred first
+		{later, time.Now().Add(8 * time.Hour)},
+		{sooner, time.Now().Add(20 * time.Millisecond)},
+	}
+	for _, deferral := range deferrals {
+		err := handler.handleFailure(context.Background(), batch, recipientFailure{
+			audience: deferral.audience,
+			err:      communicator.NewDeferredError(deferral.until, "quiet hours"),
+		})
+		require.NoError(t, err)
+	}
+	// nothing reaches Kafka before it is due
+	producer.AssertNotCalled(t, "Publish", mock.Anything, mock.Anything, mock.Anything)
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	producer.On("Publish", mock.Anything, kafka.NotificationDeferredTopic, mock.Anything).
+		Run(func(mock.Arguments) { cancel() }).
+		Return(nil)
+	relayDelayedMessages(ctx, db, kafka.NotificationDeferredTopic, producer)
+
+	// the recipient due sooner is relayed without waiting for the one ahead of it
+	producer.AssertNumberOfCalls(t, "Publish", 1)
+	var published communicator.QMessage
+	require.NoError(t, json.Unmarshal(producer.Calls[0].Arguments[2].([]byte), &published))
+	assert.Equal(t, []common.AudienceType{sooner}, published.Audiences)
+
+	var remaining []pgqueue.QueueJob
+	require.NoError(t, db.Find(&remaining).Error)
+	require.Len(t, remaining, 1)
+	assert.WithinDuration(t, deferrals[0].until, remaining[0].AvailableAt, time.Millisecond)
+}
+
+func TestDelayedKafkaProducer_KeepsUnconfirmed(t *testing.T) {
+	db := newTestQueueDB(t)
+	producer := &MockKafkaProducer{}
+	delayed := newDelayedKafkaProducer(producer, db)
+	require.NoError(t, delayed.PublishAt(context.Background(), kafka.NotificationRetryTopic, []byte(`{}`), time.Now().Add(10*time.Millisecond)))
+	time.Sleep(20 * time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	producer.On("Publish", mock.Anything, kafka.NotificationRetryTopic, mock.Anything).
+		Run(func(mock.Arguments) { cancel() }).
+		Return(errors.New("broker unavailable"))
+	relayDelayedMessages(ctx, db, kafka.NotificationRetryTopic, producer)
+
+	count := 0
+	require.NoError(t, db.Model(&pgqueue.QueueJob{}).Count(&count).Error)
+	assert.Equal(t, 1, count, "messages Kafka did not confirm stay queued")
+}
@@ -0,0 +1,91 @@
+package consumers
+
+import (
+	"sync"
+
+	confluent "github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+type partitionKey struct {
+	topic     string
+	partition int32
+}
+
+// partitionOffsets keeps the offsets of one partition in the order they were
+// read, messages of a partition are always read in offset order
+type partitionOffsets struct {
+	inflight []int64
+	done     map[int64]bool
+	// commit is the next offset to commit, -1 when nothing new is done
+	commit confluent.Offset
+}
+
+// offsetTracker decides which offsets are safe to commit while messages are
+// processed concurrently: an offset is only committed once it and every
+// offset read before it on the same partition have been processed
+type offsetTracker struct {
+	mu         sync.Mutex
+	partitions map[partitionKey]*partitionOffsets
+}
+
+func newOffsetTracker() *offsetTracker {
+	return &offsetTracker{partitions: map[partitionKey]*partitionOffsets{}}
+}
+
+func (t *offsetTracker) start(tp confluent.TopicPartition) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key := partitionKey{topic: *tp.Topic, partition: tp.Partition}
+	offsets, ok := t.partitions[key]
+	if !ok {
+		offsets = &partitionOffsets{done: map[int64]bool{}, commit: -1}
+		t.partitions[key] = offsets
+	}
+	offsets.inflight = append(offsets.inflight, int64(tp.Offset))
+}
+
+func (t *offsetTracker) finish(tp confluent.TopicPartition) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	offsets, ok := t.partitions[partitionKey{topic: *tp.Topic, partition: tp.Partition}]
+	if !ok {
+		// the partition was revoked while the message was processed
+		return
+	}
+	offsets.done[int64(tp.Offset)] = true
+	for len(offsets.inflight) > 0 && offsets.done[offsets.inflight[0]] {
+		delete(offsets.done, offsets.inflight[0])
+		offsets.commit = confluent.Offset(offsets.inflight[0] + 1)
+		offsets.inflight = offsets.inflight[1:]
+	}
+}
+
+// ready returns the offsets to commit and forgets them
+func (t *offsetTracker) ready() []confluent.TopicPartition {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var ready []confluent.TopicPartition
+	for key, offsets := range t.partitions {
+		if offsets.commit < 0 {
+			continue
+		}
+		topic := key.topic
+		ready = append(ready, confluent.TopicPartition{
+			Topic:     &topic,
+			Partition: key.partition,
+			Offset:    offsets.commit,
+		})
+		offsets.commit = -1
+	}
+	return ready
+}
+
+// revoke drops partitions assigned to another consumer, their unfinished
+// messages are redelivered there from the last committed offset
+func (t *offsetTracker) revoke(partitions []confluent.TopicPartition) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, tp := range partitions {
+		delete(t.partitions, partitionKey{topic: *tp.Topic, partition: tp.Partition})
+	}
+}
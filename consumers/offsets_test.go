@@ -0,0 +1,43 @@
+package consumers
+
+import (
+	"testing"
+
+	confluent "github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/stretchr/testify/assert"
+)
+
+func topicPartition(partition int32, offset int64) confluent.TopicPartition {
+	topic := "notification_batch"
+	return confluent.TopicPartition{Topic: &topic, Partition: partition, Offset: confluent.Offset(offset)}
+}
+
+func TestOffsetTracker(t *testing.T) {
+	tracker := newOffsetTracker()
+	for offset := int64(10); offset < 13; offset++ {
+		tracker.start(topicPartition(0, offset))
+	}
+	tracker.start(topicPartition(1, 5))
+
+	// later offsets finishing first must not be committed
+	tracker.finish(topicPartition(0, 11))
+	tracker.finish(topicPartition(0, 12))
+	assert.Empty(t, tracker.ready())
+
+	tracker.finish(topicPartition(0, 10))
+	tracker.finish(topicPartition(1, 5))
+	ready := tracker.ready()
+	assert.ElementsMatch(t, []confluent.TopicPartition{topicPartition(0, 13), topicPartition(1, 6)}, ready)
+
+	// committed offsets are only returned once
+	assert.Empty(t, tracker.ready())
+}
+
+func TestOffsetTracker_Revoke(t *testing.T) {
+	tracker := newOffsetTracker()
+	tracker.start(topicPartition(0, 1))
+	tracker.revoke([]confluent.TopicPartition{topicPartition(0, 0)})
+
+	tracker.finish(topicPartition(0, 1))
+	assert.Empty(t, tracker.ready())
+}
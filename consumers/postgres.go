@@ -0,0 +1,61 @@
+package consumers
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/kp/pager/databases/pgqueue"
+	"github.com/kp/pager/metrics"
+)
+
+var postgresLagInterval = 15 * time.Second
+
+// runPostgresConsumer polls topic with concurrency consumers until ctx is
+// cancelled, each job is leased by one consumer at a time. A job is deleted
+// once handle succeeds and handed out again after a backoff when it fails.
+func runPostgresConsumer(ctx, workCtx context.Context, db *gorm.DB, topic string, concurrency int, handle messageHandler) error {
+	slog.Info("worker:consumingPostgresQueue", slog.String("topic", topic))
+
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pgqueue.NewPostgresConsumer(db, topic).Run(ctx, workCtx, func(msgCtx context.Context, value []byte) error {
+				err := handle(msgCtx, value)
+				metrics.MessagesConsumed.WithLabelValues(topic, metrics.Outcome(err)).Inc()
+				return err
+			})
+		}()
+	}
+	go recordPostgresLag(ctx, db, topic)
+
+	wg.Wait()
+	slog.Info("worker:drained", slog.String("topic", topic))
+	return nil
+}
+
+// recordPostgresLag periodically reports the jobs of topic which are due but
+// not consumed yet, the queue has a single partition
+func recordPostgresLag(ctx context.Context, db *gorm.DB, topic string) {
+	ticker := time.NewTicker(postgresLagInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			count, err := pgqueue.CountAvailableQueueJobs(ctx, db, topic, time.Now())
+			if err != nil {
+				slog.Error("worker:countQueueJobsFailed",
+					slog.String("topic", topic),
+					slog.Any("error", err))
+				continue
+			}
+			metrics.ConsumerLag.WithLabelValues(topic, "0").Set(float64(count))
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package consumers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/kp/pager/common"
+	"github.com/kp/pager/communicator"
+	comm_models "github.com/kp/pager/communicator/models"
+	"github.com/kp/pager/databases/kafka"
+)
+
+// RetryPolicy decides how often and how late a failed recipient is retried
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 5,
+	BaseDelay:   30 * time.Second,
+	MaxDelay:    30 * time.Minute,
+}
+
+// NewRetryPolicy builds a policy from config, falling back to
+// DefaultRetryPolicy for unset values
+func NewRetryPolicy(config RetryConfig) (RetryPolicy, error) {
+	policy := DefaultRetryPolicy
+	if config.MaxAttempts != "" {
+		maxAttempts, err := strconv.Atoi(config.MaxAttempts)
+		if err != nil || maxAttempts < 1 {
+			return policy, fmt.Errorf("invalid RETRY_MAX_ATTEMPTS %q", config.MaxAttempts)
+		}
+		policy.MaxAttempts = maxAttempts
+	}
+	if config.BaseDelay != "" {
+		baseDelay, err := time.ParseDuration(config.BaseDelay)
+		if err != nil || baseDelay <= 0 {
+			return policy, fmt.Errorf("invalid RETRY_BASE_DELAY %q", config.BaseDelay)
+		}
+		policy.BaseDelay = baseDelay
+	}
+	if config.MaxDelay != "" {
+		maxDelay, err := time.ParseDuration(config.MaxDelay)
+		if err != nil || maxDelay <= 0 {
+			return policy, fmt.Errorf("invalid RETRY_MAX_DELAY %q", config.MaxDelay)
+		}
+		policy.MaxDelay = maxDelay
+	}
+	return policy, nil
+}
+
+// Backoff returns the delay before the next attempt once attempt attempts
+// have failed, doubling from BaseDelay and capped at MaxDelay
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempt; i++ {
+		delay *= 2
+		if delay >= p.MaxDelay {
+			return p.MaxDelay
+		}
+	}
+	if delay > p.MaxDelay {
+		return p.MaxDelay
+	}
+	return delay
+}
+
+// recipientFailure is a single audience of a batch which could not be sent
+type recipientFailure struct {
+	audience common.AudienceType
+	logID    int64
+	err      error
+}
+
+// RetryHandler republishes failed recipients to the retry topic, deferred ones
+// to the deferred topic, and moves them to the dead-letter topic once they run
+// out of attempts
+type RetryHandler struct {
+	producer kafka.KafkaProducer
+	policy   RetryPolicy
+}
+
+func NewRetryHandler(producer kafka.KafkaProducer, policy RetryPolicy) *RetryHandler {
+	return &RetryHandler{producer: producer, policy: policy}
+}
+
+func (h *RetryHandler) handleFailure(ctx context.Context, batch communicator.QMessage, failure recipientFailure) error {
+	model := batch.GenericModel
+	model.LogID = failure.logID
+	message := communicator.QMessage{
+		BatchID:      batch.BatchID,
+		GenericModel: model,
+		Audiences:    []common.AudienceType{failure.audience},
+		Attempt:      batch.Attempt + 1,
+	}
+
+	topic := kafka.NotificationRetryTopic
+	status := comm_models.CommunicationStatusRetrying
+	if until, deferred := communicator.DeferredUntil(failure.err); deferred {
+		// held back recipients were not attempted, e.g. during their quiet hours
+		topic = kafka.NotificationDeferredTopic
+		message.Attempt = batch.Attempt
+		message.Deferred = true
+		message.NotBefore = until
+		status = comm_models.CommunicationStatusDeferred
+	} else if communicator.IsPermanent(failure.err) || message.Attempt >= h.policy.MaxAttempts {
+		topic = kafka.NotificationDeadLetterTopic
+		status = comm_models.CommunicationStatusFailed
+		message.ErrorReason = failure.err.Error()
+	} else {
+		message.NotBefore = time.Now().Add(h.policy.Backoff(message.Attempt))
+	}
+	if failure.logID != 0 {
+		if err := comm_models.UpdateCommunicationLogStatus(ctx, nil, failure.logID, status, failure.err.Error()); err != nil {
+			slog.Error("handleFailure:failedToUpdateCommunicationLog",
+				slog.Int64("log_id", failure.logID),
+				slog.Any("error", err))
+		}
+	}
+
+	messageBytes, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("failed to marshal retry message: %w", err)
+	}
+	if err := h.publish(ctx, topic, messageBytes, message.NotBefore); err != nil {
+		return fmt.Errorf("failed to publish to %s: %w", topic, err)
+	}
+
+	slog.Info("recipient requeued",
+		slog.String("topic", topic),
+		slog.String("batch_id", batch.BatchID),
+		slog.Int64("log_id", failure.logID),
+		slog.Int("attempt", message.Attempt),
+		slog.String("error", failure.err.Error()))
+	return nil
+}
+
+// publish delays the message in the queue when the producer supports it
+func (h *RetryHandler) publish(ctx context.Context, topic string, data []byte, notBefore time.Time) error {
+	if delayed, ok := h.producer.(kafka.DelayedProducer); ok && !notBefore.IsZero() {
+		return delayed.PublishAt(ctx, topic, data, notBefore)
+	}
+	return h.producer.Publish(ctx, topic, data)
+}
@@ -0,0 +1,146 @@
+package consumers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kp/pager/common"
+	"github.com/kp/pager/communicator"
+	"github.com/kp/pager/databases/kafka"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type MockKafkaProducer struct {
+	mock.Mock
+}
+
+func (m *MockKafkaProducer) Publish(ctx context.Context, topic string, message []byte) error {
+	args := m.Called(ctx, topic, message)
+	return args.Error(0)
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 5, BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{50, 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, policy.Backoff(tt.attempt), "attempt %d", tt.attempt)
+	}
+}
+
+func TestNewRetryPolicy(t *testing.T) {
+	policy, err := NewRetryPolicy(RetryConfig{})
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultRetryPolicy, policy)
+
+	policy, err = NewRetryPolicy(RetryConfig{MaxAttempts: "3", BaseDelay: "5s", MaxDelay: "1m"})
+	assert.NoError(t, err)
+	assert.Equal(t, RetryPolicy{MaxAttempts: 3, BaseDelay: 5 * time.Second, MaxDelay: time.Minute}, policy)
+
+	for _, config := range []RetryConfig{
+		{MaxAttempts: "zero"},
+		{MaxAttempts: "0"},
+		{BaseDelay: "soon"},
+		{MaxDelay: "-1s"},
+	} {
+		_, err := NewRetryPolicy(config)
+		assert.Error(t, err, "config %+v", config)
+	}
+}
+
+func TestRetryHandler_HandleFailure(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Minute, MaxDelay: time.Hour}
+	audience := common.AudienceType{Email: "test@example.com"}
+
+	tests := []struct {
+		name        string
+		attempt     int
+		err         error
+		wantTopic   string
+		wantAttempt int
+	}{
+		{"first failure is retried", 0, errors.New("smtp timeout"), kafka.NotificationRetryTopic, 1},
+		{"second failure is retried", 1, errors.New("smtp timeout"), kafka.NotificationRetryTopic, 2},
+		{"attempts exhausted", 2, errors.New("smtp timeout"), kafka.NotificationDeadLetterTopic, 3},
+		{"permanent error", 0, communicator.NewPermanentError(errors.New("bad template")), kafka.NotificationDeadLetterTopic, 1},
+		{"deferral is not an attempt", 2, communicator.NewDeferredError(time.Now().Add(8*time.Hour), "quiet hours"), kafka.NotificationDeferredTopic, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			producer := &MockKafkaProducer{}
+			producer.On("Publish", mock.Anything, tt.wantTopic, mock.Anything).Return(nil)
+			handler := NewRetryHandler(producer, policy)
+
+			batch := communicator.QMessage{
+				BatchID:      "batch1",
+				GenericModel: communicator.NotificationType{TemplateID: 1, RequestId: "req1"},
+				Audiences:    []common.AudienceType{audience, {Email: "other@example.com"}},
+				Attempt:      tt.attempt,
+			}
+			err := handler.handleFailure(context.Background(), batch, recipientFailure{audience: audience, err: tt.err})
+			require.NoError(t, err)
+			producer.AssertExpectations(t)
+
+			var published communicator.QMessage
+			require.NoError(t, json.Unmarshal(producer.Calls[0].Arguments[2].([]byte), &published))
+			assert.Equal(t, []common.AudienceType{audience}, published.Audiences)
+			assert.Equal(t, tt.wantAttempt, published.Attempt)
+			until, deferred := communicator.DeferredUntil(tt.err)
+			assert.Equal(t, deferred, published.Deferred)
+			if deferred {
+				assert.True(t, until.Equal(published.NotBefore))
+			}
+			if tt.wantTopic == kafka.NotificationDeadLetterTopic {
+				assert.Equal(t, tt.err.Error(), published.ErrorReason)
+			} else {
+				assert.True(t, published.NotBefore.After(time.Now()))
+			}
+		})
+	}
+}
+
+type MockDelayedProducer struct {
+	MockKafkaProducer
+}
+
+func (m *MockDelayedProducer) PublishAt(ctx context.Context, topic string, message []byte, at time.Time) error {
+	args := m.Called(ctx, topic, message, at)
+	return args.Error(0)
+}
+
+func TestRetryHandler_HandleFailure_DelayedProducer(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Minute, MaxDelay: time.Hour}
+	producer := &MockDelayedProducer{}
+	producer.On("PublishAt", mock.Anything, kafka.NotificationRetryTopic, mock.Anything, mock.Anything).Return(nil)
+	handler := NewRetryHandler(producer, policy)
+
+	batch := communicator.QMessage{
+		BatchID:      "batch1",
+		GenericModel: communicator.NotificationType{TemplateID: 1, RequestId: "req1"},
+		Audiences:    []common.AudienceType{{Email: "test@example.com"}},
+	}
+	err := handler.handleFailure(context.Background(), batch, recipientFailure{audience: batch.Audiences[0], err: errors.New("smtp timeout")})
+	require.NoError(t, err)
+	producer.AssertExpectations(t)
+	producer.AssertNotCalled(t, "Publish", mock.Anything, mock.Anything, mock.Anything)
+
+	var published communicator.QMessage
+	require.NoError(t, json.Unmarshal(producer.Calls[0].Arguments[2].([]byte), &published))
+	assert.WithinDuration(t, published.NotBefore, producer.Calls[0].Arguments[3].(time.Time), time.Millisecond)
+}
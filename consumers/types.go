@@ -0,0 +1,17 @@
+package consumers
+
+// RetryConfig configures how failed recipients are retried, durations use
+// time.ParseDuration syntax such as "30s" or "10m"
+type RetryConfig struct {
+	MaxAttempts string `json:"RETRY_MAX_ATTEMPTS"`
+	BaseDelay   string `json:"RETRY_BASE_DELAY"`
+	MaxDelay    string `json:"RETRY_MAX_DELAY"`
+}
+
+// WorkerConfig configures the worker command, the consumer group is read
+// from KAFKA_CONSUMER_GROUP
+type WorkerConfig struct {
+	Concurrency  string `json:"WORKER_CONCURRENCY"`
+	DrainTimeout string `json:"WORKER_DRAIN_TIMEOUT"`
+	MetricsAddr  string `json:"WORKER_METRICS_ADDR"`
+}
@@ -0,0 +1,154 @@
+package consumers
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/kp/pager/databases/kafka"
+	"github.com/kp/pager/databases/pgqueue"
+)
+
+const DefaultConsumerGroup = "go-kafka-consumer"
+
+// WorkerOptions controls how a worker consumes notification batches
+type WorkerOptions struct {
+	// GroupID is the consumer group of the batch topic, the retry and
+	// deferred topics are consumed by GroupID with a "-retry" and "-deferred"
+	// suffix
+	GroupID string
+	// Concurrency bounds the messages processed at once per topic
+	Concurrency int
+	// DrainTimeout is how long in-flight messages may run after shutdown
+	DrainTimeout time.Duration
+}
+
+var DefaultWorkerOptions = WorkerOptions{
+	GroupID:      DefaultConsumerGroup,
+	Concurrency:  10,
+	DrainTimeout: 30 * time.Second,
+}
+
+// NewWorkerOptions builds worker options from config, falling back to
+// DefaultWorkerOptions for unset values
+func NewWorkerOptions(config WorkerConfig, groupID string) (WorkerOptions, error) {
+	options := DefaultWorkerOptions
+	if groupID != "" {
+		options.GroupID = groupID
+	}
+	if config.Concurrency != "" {
+		concurrency, err := strconv.Atoi(config.Concurrency)
+		if err != nil || concurrency < 1 {
+			return options, fmt.Errorf("invalid WORKER_CONCURRENCY %q", config.Concurrency)
+		}
+		options.Concurrency = concurrency
+	}
+	if config.DrainTimeout != "" {
+		drainTimeout, err := time.ParseDuration(config.DrainTimeout)
+		if err != nil || drainTimeout <= 0 {
+			return options, fmt.Errorf("invalid WORKER_DRAIN_TIMEOUT %q", config.DrainTimeout)
+		}
+		options.DrainTimeout = drainTimeout
+	}
+	return options, nil
+}
+
+// consumeFunc reads topic until ctx is cancelled and waits for the messages
+// it handed to handle, handle runs with workCtx
+type consumeFunc func(ctx, workCtx context.Context, groupID, topic string, handle messageHandler) error
+
+// RunKafkaWorker consumes the batch, retry and deferred topics from Kafka until ctx is
+// cancelled, then drains the in-flight messages. Retries and deferrals wait in
+// the Postgres queue of db until due and are relayed to Kafka meanwhile.
+func RunKafkaWorker(ctx context.Context, brokers []string, db *gorm.DB, options WorkerOptions, retryPolicy RetryPolicy) error {
+	producer, err := kafka.NewKafkaProducer(brokers)
+	if err != nil {
+		return err
+	}
+	retryHandler := NewRetryHandler(newDelayedKafkaProducer(producer, db), retryPolicy)
+	defer closeRetryHandler(retryHandler)
+
+	// The relays stop with the worker, before the producer is closed
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+	var relays sync.WaitGroup
+	for _, topic := range []string{kafka.NotificationRetryTopic, kafka.NotificationDeferredTopic} {
+		relays.Add(1)
+		go func(topic string) {
+			defer relays.Done()
+			relayDelayedMessages(ctx, db, topic, producer)
+		}(topic)
+	}
+
+	err = runWorker(ctx, options, retryHandler, func(ctx, workCtx context.Context, groupID, topic string, handle messageHandler) error {
+		return runKafkaConsumer(ctx, workCtx, brokers, groupID, topic, options.Concurrency, handle)
+	})
+	stop()
+	relays.Wait()
+	return err
+}
+
+// RunPostgresWorker consumes the batch, retry and deferred topics from the Postgres
+// queue until ctx is cancelled, then drains the in-flight messages
+func RunPostgresWorker(ctx context.Context, db *gorm.DB, options WorkerOptions, retryPolicy RetryPolicy) error {
+	retryHandler := NewRetryHandler(pgqueue.NewPostgresProducer(db), retryPolicy)
+
+	return runWorker(ctx, options, retryHandler, func(ctx, workCtx context.Context, groupID, topic string, handle messageHandler) error {
+		return runPostgresConsumer(ctx, workCtx, db, topic, options.Concurrency, handle)
+	})
+}
+
+func runWorker(ctx context.Context, options WorkerOptions, retryHandler *RetryHandler, consume consumeFunc) error {
+	// A consumer failing stops the other one as well
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+
+	// In-flight messages keep running after shutdown until the drain timeout
+	workCtx, cancelWork := context.WithCancel(context.Background())
+	defer cancelWork()
+	go func() {
+		<-ctx.Done()
+		select {
+		case <-time.After(options.DrainTimeout):
+			cancelWork()
+		case <-workCtx.Done():
+		}
+	}()
+
+	consumers := []struct {
+		groupID string
+		topic   string
+		handle  messageHandler
+	}{
+		{options.GroupID, kafka.NotificationBatchTopic, batchMessageHandler(retryHandler)},
+		{options.GroupID + "-retry", kafka.NotificationRetryTopic, batchMessageHandler(retryHandler)},
+		{options.GroupID + "-deferred", kafka.NotificationDeferredTopic, batchMessageHandler(retryHandler)},
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(consumers))
+	for i, consumer := range consumers {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer stop()
+			errs[i] = consume(ctx, workCtx, consumer.groupID, consumer.topic, consumer.handle)
+			if errs[i] != nil {
+				slog.Error("worker:consumerFailed",
+					slog.String("topic", consumer.topic),
+					slog.Any("error", errs[i]))
+			}
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package consumers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewWorkerOptions(t *testing.T) {
+	options, err := NewWorkerOptions(WorkerConfig{}, "")
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultWorkerOptions, options)
+
+	options, err = NewWorkerOptions(WorkerConfig{Concurrency: "4", DrainTimeout: "1m"}, "pager-workers")
+	assert.NoError(t, err)
+	assert.Equal(t, WorkerOptions{GroupID: "pager-workers", Concurrency: 4, DrainTimeout: time.Minute}, options)
+
+	for _, config := range []WorkerConfig{
+		{Concurrency: "many"},
+		{Concurrency: "0"},
+		{DrainTimeout: "later"},
+		{DrainTimeout: "0s"},
+	} {
+		_, err := NewWorkerOptions(config, "")
+		assert.Error(t, err, "config %+v", config)
+	}
+}
@@ -0,0 +1,41 @@
+package kafka
+
+import (
+	"context"
+	"testing"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/kp/pager/tracing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestHeaderCarrier_PropagatesTrace(t *testing.T) {
+	_, err := tracing.Init(context.Background(), tracing.TracingConfig{})
+	require.NoError(t, err)
+
+	ctx, span := sdktrace.NewTracerProvider().Tracer("test").Start(context.Background(), "publish")
+	defer span.End()
+
+	headers := []kafka.Header{{Key: "other", Value: []byte("kept")}}
+	tracing.Inject(ctx, NewHeaderCarrier(&headers))
+	assert.Len(t, headers, 2)
+
+	extracted := trace.SpanContextFromContext(tracing.Extract(context.Background(), NewHeaderCarrier(&headers)))
+	assert.True(t, extracted.IsRemote())
+	assert.Equal(t, span.SpanContext().TraceID(), extracted.TraceID())
+	assert.Equal(t, span.SpanContext().SpanID(), extracted.SpanID())
+}
+
+func TestHeaderCarrier_Set(t *testing.T) {
+	var headers []kafka.Header
+	carrier := NewHeaderCarrier(&headers)
+	carrier.Set("traceparent", "a")
+	carrier.Set("traceparent", "b")
+
+	assert.Equal(t, "b", carrier.Get("traceparent"))
+	assert.Equal(t, []string{"traceparent"}, carrier.Keys())
+	assert.Empty(t, carrier.Get("missing"))
+}
@@ -3,14 +3,29 @@ package kafka
 import (
 	"context"
 	"fmt"
+	"log/slog"
+	"time"
 
 	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/kp/pager/tracing"
+)
+
+const (
+	NotificationBatchTopic      = "notification_batch"
+	NotificationRetryTopic      = "notification_batch_retry"
+	NotificationDeadLetterTopic = "notification_batch_dlq"
+	// NotificationDeferredTopic holds recipients deferred for hours, e.g. by
+	// quiet hours, apart from retries so they do not delay short backoffs
+	NotificationDeferredTopic = "notification_batch_deferred"
 )
 
 // RunMigrations creates default Kafka topics
 func RunMigrations(brokers []string) error {
 	defaultTopics := []string{
-		"notification_batch",
+		NotificationBatchTopic,
+		NotificationRetryTopic,
+		NotificationDeadLetterTopic,
+		NotificationDeferredTopic,
 		// Add more default topics here as needed
 	}
 
@@ -22,6 +37,25 @@ type KafkaProducer interface {
 	Publish(ctx context.Context, topic string, data []byte) error
 }
 
+// DelayedProducer is implemented by producers which can hold a message back
+// until a given time, consumers then never see it early
+type DelayedProducer interface {
+	PublishAt(ctx context.Context, topic string, data []byte, at time.Time) error
+}
+
+// ClosableProducer flushes messages still waiting for delivery and releases the producer
+type ClosableProducer interface {
+	Close(ctx context.Context) error
+}
+
+// CloseProducer closes producer when it holds resources, other producers are left untouched
+func CloseProducer(ctx context.Context, producer KafkaProducer) error {
+	if closable, ok := producer.(ClosableProducer); ok {
+		return closable.Close(ctx)
+	}
+	return nil
+}
+
 type kafkaProducer struct {
 	producer *kafka.Producer
 }
@@ -39,26 +73,73 @@ func NewKafkaProducer(brokers []string) (KafkaProducer, error) {
 		return nil, fmt.Errorf("failed to create producer: %v", err)
 	}
 
-	return &kafkaProducer{
+	k := &kafkaProducer{
 		producer: producer,
-	}, nil
+	}
+	go k.handleEvents()
+	return k, nil
 }
 
-// Publish publishes a message to a Kafka topic
+// Publish publishes a message to a Kafka topic and waits for the broker to
+// acknowledge it
 func (k *kafkaProducer) Publish(ctx context.Context, topic string, data []byte) error {
-	deliveryChan := make(chan kafka.Event)
+	deliveryChan := make(chan kafka.Event, 1)
+
+	// Carry the trace context so consumers continue the same trace
+	var headers []kafka.Header
+	tracing.Inject(ctx, NewHeaderCarrier(&headers))
 
 	err := k.producer.Produce(&kafka.Message{
 		TopicPartition: kafka.TopicPartition{
 			Topic:     &topic,
 			Partition: kafka.PartitionAny,
 		},
-		Value: data,
+		Value:   data,
+		Headers: headers,
 	}, deliveryChan)
 
 	if err != nil {
 		return fmt.Errorf("failed to produce message: %v", err)
 	}
 
+	select {
+	case event := <-deliveryChan:
+		return deliveryError(event)
+	case <-ctx.Done():
+		return fmt.Errorf("delivery to %s not confirmed: %w", topic, ctx.Err())
+	}
+}
+
+// Close waits until every produced message is delivered or ctx expires
+func (k *kafkaProducer) Close(ctx context.Context) error {
+	defer k.producer.Close()
+	for {
+		remaining := k.producer.Flush(100)
+		if remaining == 0 {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("%d messages not delivered before close: %w", remaining, ctx.Err())
+		}
+	}
+}
+
+// handleEvents logs producer level errors, it returns once the producer is closed
+func (k *kafkaProducer) handleEvents() {
+	for event := range k.producer.Events() {
+		if e, ok := event.(kafka.Error); ok {
+			slog.Error("kafkaProducer:error", slog.Any("error", e))
+		}
+	}
+}
+
+func deliveryError(event kafka.Event) error {
+	message, ok := event.(*kafka.Message)
+	if !ok {
+		return fmt.Errorf("unexpected delivery event: %v", event)
+	}
+	if message.TopicPartition.Error != nil {
+		return fmt.Errorf("failed to deliver message: %w", message.TopicPartition.Error)
+	}
 	return nil
 }
@@ -0,0 +1,98 @@
+package pgqueue
+
+import (
+	"context"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/kp/pager/databases/sql"
+)
+
+const QueueJobTableName = "queue_jobs"
+
+// QueueJob is a message waiting in the Postgres queue, rows are deleted once
+// consumed. A consumer leases a job by setting LockedUntil, other consumers
+// skip it until the lease runs out.
+type QueueJob struct {
+	ID          int64      `gorm:"column:id;primary_key"`
+	Topic       string     `gorm:"column:topic;not null;index:idx_queue_jobs_topic_available_at"`
+	Payload     string     `gorm:"column:payload;type:text"`
+	Headers     string     `gorm:"column:headers;type:text"`
+	AvailableAt time.Time  `gorm:"column:available_at;index:idx_queue_jobs_topic_available_at"`
+	Attempts    int        `gorm:"column:attempts;not null;default:0"`
+	LockedUntil *time.Time `gorm:"column:locked_until"`
+	CreatedAt   time.Time  `gorm:"column:created_at"`
+}
+
+func (QueueJob) TableName() string {
+	return QueueJobTableName
+}
+
+func NewQueueJobEntry(ctx context.Context, tx interface{}, topic string, payload []byte, headers string, availableAt time.Time) (*QueueJob, error) {
+	database := sql.GetOrmQuearyable(ctx, tx)
+	entry := QueueJob{
+		Topic:       topic,
+		Payload:     string(payload),
+		Headers:     headers,
+		AvailableAt: availableAt,
+		CreatedAt:   time.Now(),
+	}
+	err := database.Create(&entry).Error
+	return &entry, err
+}
+
+// ClaimNextQueueJob leases the oldest available job of topic until now+lease
+// and counts the attempt, skipping jobs locked or leased by other consumers.
+// tx must be a transaction, the lease holds once it is committed.
+func ClaimNextQueueJob(ctx context.Context, tx interface{}, topic string, now time.Time, lease time.Duration) (*QueueJob, error) {
+	db := sql.GetOrmQuearyable(ctx, tx)
+	query := db
+	if db.Dialect().GetName() == "postgres" {
+		// other databases lock the table for the write of the transaction
+		query = db.Set("gorm:query_option", "FOR UPDATE SKIP LOCKED")
+	}
+	entry := QueueJob{}
+	err := query.
+		Where("topic = ? AND available_at <= ?", topic, now).
+		Where("locked_until IS NULL OR locked_until <= ?", now).
+		Order("id").
+		First(&entry).Error
+	if err != nil {
+		return &entry, err
+	}
+
+	lockedUntil := now.Add(lease)
+	err = db.Model(&QueueJob{}).Where("id = ?", entry.ID).Updates(map[string]interface{}{
+		"attempts":     gorm.Expr("attempts + 1"),
+		"locked_until": lockedUntil,
+	}).Error
+	entry.Attempts++
+	entry.LockedUntil = &lockedUntil
+	return &entry, err
+}
+
+// ReleaseQueueJob ends the lease of a job which was not consumed, it can be
+// claimed again from availableAt
+func ReleaseQueueJob(ctx context.Context, tx interface{}, id int64, availableAt time.Time) error {
+	db := sql.GetOrmQuearyable(ctx, tx)
+	return db.Model(&QueueJob{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"available_at": availableAt,
+		"locked_until": gorm.Expr("NULL"),
+	}).Error
+}
+
+// CountAvailableQueueJobs counts the jobs of topic which can be claimed at now
+func CountAvailableQueueJobs(ctx context.Context, tx interface{}, topic string, now time.Time) (int, error) {
+	db := sql.GetOrmQuearyable(ctx, tx)
+	count := 0
+	err := db.Model(&QueueJob{}).
+		Where("topic = ? AND available_at <= ?", topic, now).
+		Where("locked_until IS NULL OR locked_until <= ?", now).
+		Count(&count).Error
+	return count, err
+}
+
+func DeleteQueueJob(ctx context.Context, tx interface{}, id int64) error {
+	db := sql.GetOrmQuearyable(ctx, tx)
+	return db.Where("id = ?", id).Delete(&QueueJob{}).Error
+}
@@ -0,0 +1,161 @@
+package pgqueue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/kp/pager/databases/kafka"
+	"github.com/kp/pager/tracing"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+var (
+	defaultPollInterval = time.Second
+	// defaultLeaseDuration must outlast handling a job, an expired lease lets
+	// another consumer claim the job again
+	defaultLeaseDuration = 10 * time.Minute
+	defaultRetryDelay    = 10 * time.Second
+	defaultMaxRetryDelay = 10 * time.Minute
+)
+
+type postgresProducer struct {
+	db *gorm.DB
+}
+
+// NewPostgresProducer creates a KafkaProducer which stores messages in the
+// queue_jobs table instead of a Kafka topic
+func NewPostgresProducer(db *gorm.DB) kafka.KafkaProducer {
+	return &postgresProducer{db: db}
+}
+
+func (p *postgresProducer) Publish(ctx context.Context, topic string, data []byte) error {
+	return p.PublishAt(ctx, topic, data, time.Now())
+}
+
+// PublishAt stores a message which is not handed to consumers before at
+func (p *postgresProducer) PublishAt(ctx context.Context, topic string, data []byte, at time.Time) error {
+	// Carry the trace context so consumers continue the same trace
+	carrier := propagation.MapCarrier{}
+	tracing.Inject(ctx, carrier)
+	headers, err := json.Marshal(carrier)
+	if err != nil {
+		return fmt.Errorf("failed to marshal headers: %v", err)
+	}
+	if _, err := NewQueueJobEntry(ctx, p.db, topic, data, string(headers), at); err != nil {
+		return fmt.Errorf("failed to enqueue message: %v", err)
+	}
+	return nil
+}
+
+// Consumer reads messages of one topic from the queue_jobs table. A job is
+// leased for the time it is handled, so several consumers can share a topic
+// without holding a transaction open during handling.
+type Consumer struct {
+	db            *gorm.DB
+	topic         string
+	pollInterval  time.Duration
+	leaseDuration time.Duration
+	retryDelay    time.Duration
+	maxRetryDelay time.Duration
+}
+
+func NewPostgresConsumer(db *gorm.DB, topic string) *Consumer {
+	return &Consumer{
+		db:            db,
+		topic:         topic,
+		pollInterval:  defaultPollInterval,
+		leaseDuration: defaultLeaseDuration,
+		retryDelay:    defaultRetryDelay,
+		maxRetryDelay: defaultMaxRetryDelay,
+	}
+}
+
+// Run hands each job to handle until ctx is cancelled. handle runs with
+// workCtx joined to the job's trace, so a job in progress may finish after
+// ctx is cancelled. A job is removed once handle succeeds, a failed job is
+// kept and handed out again after a backoff like an uncommitted Kafka offset.
+func (c *Consumer) Run(ctx, workCtx context.Context, handle func(ctx context.Context, data []byte) error) {
+	for ctx.Err() == nil {
+		claimed, err := c.processNext(ctx, workCtx, handle)
+		if err != nil {
+			slog.Error("pgqueue:consumeFailed",
+				slog.String("topic", c.topic),
+				slog.Any("error", err))
+		}
+		if claimed && err == nil {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(c.pollInterval):
+		}
+	}
+}
+
+func (c *Consumer) processNext(ctx, workCtx context.Context, handle func(ctx context.Context, data []byte) error) (bool, error) {
+	job, err := c.claim(ctx)
+	if gorm.IsRecordNotFoundError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	carrier := propagation.MapCarrier{}
+	if job.Headers != "" {
+		if err := json.Unmarshal([]byte(job.Headers), &carrier); err != nil {
+			slog.Error("pgqueue:invalidHeaders",
+				slog.Int64("job_id", job.ID),
+				slog.Any("error", err))
+		}
+	}
+	if err := handle(tracing.Extract(workCtx, carrier), []byte(job.Payload)); err != nil {
+		slog.Error("pgqueue:handleFailed",
+			slog.String("topic", c.topic),
+			slog.Int64("job_id", job.ID),
+			slog.Int("attempts", job.Attempts),
+			slog.Any("error", err))
+		availableAt := time.Now()
+		if workCtx.Err() == nil {
+			availableAt = availableAt.Add(c.backoff(job.Attempts))
+		}
+		// the job was interrupted by shutdown when workCtx is done, it is
+		// available again at once
+		return true, ReleaseQueueJob(workCtx, c.db, job.ID, availableAt)
+	}
+	return true, DeleteQueueJob(workCtx, c.db, job.ID)
+}
+
+// claim leases the next job of the topic in a transaction of its own
+func (c *Consumer) claim(ctx context.Context) (*QueueJob, error) {
+	tx := c.db.Begin()
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	job, err := ClaimNextQueueJob(ctx, tx, c.topic, time.Now(), c.leaseDuration)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// backoff returns the delay before a job is handed out again once attempts
+// attempts have failed, doubling from retryDelay and capped at maxRetryDelay
+func (c *Consumer) backoff(attempts int) time.Duration {
+	delay := c.retryDelay
+	for i := 1; i < attempts && delay < c.maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > c.maxRetryDelay {
+		return c.maxRetryDelay
+	}
+	return delay
+}
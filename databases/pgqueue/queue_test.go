@@ -0,0 +1,158 @@
+package pgqueue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testTopic = "notification_batch"
+
+// newTestDB opens an in-memory database with the queue table, shared by the
+// connections of the test only
+func newTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open("sqlite3", fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name()))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	require.NoError(t, db.AutoMigrate(&QueueJob{}).Error)
+	return db
+}
+
+func enqueue(t *testing.T, db *gorm.DB, payload string, availableAt time.Time) *QueueJob {
+	job, err := NewQueueJobEntry(context.Background(), db, testTopic, []byte(payload), "", availableAt)
+	require.NoError(t, err)
+	return job
+}
+
+func getJob(t *testing.T, db *gorm.DB, id int64) *QueueJob {
+	job := QueueJob{}
+	require.NoError(t, db.Where("id = ?", id).First(&job).Error)
+	return &job
+}
+
+func TestClaimNextQueueJob(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	now := time.Now()
+	enqueue(t, db, "later", now.Add(time.Hour))
+	first := enqueue(t, db, "first", now.Add(-time.Minute))
+	enqueue(t, db, "second", now.Add(-time.Minute))
+
+	job, err := ClaimNextQueueJob(ctx, db, testTopic, now, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, first.ID, job.ID)
+	assert.Equal(t, "first", job.Payload)
+
+	stored := getJob(t, db, first.ID)
+	assert.Equal(t, 1, stored.Attempts)
+	require.NotNil(t, stored.LockedUntil)
+	assert.WithinDuration(t, now.Add(time.Minute), *stored.LockedUntil, time.Millisecond)
+
+	_, err = ClaimNextQueueJob(ctx, db, "other_topic", now, time.Minute)
+	assert.True(t, gorm.IsRecordNotFoundError(err))
+}
+
+func TestClaimNextQueueJob_SkipsLeased(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	now := time.Now()
+	first := enqueue(t, db, "first", now)
+	second := enqueue(t, db, "second", now)
+
+	job, err := ClaimNextQueueJob(ctx, db, testTopic, now, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, first.ID, job.ID)
+
+	// another consumer gets the next job while the first one is leased
+	job, err = ClaimNextQueueJob(ctx, db, testTopic, now, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, second.ID, job.ID)
+
+	_, err = ClaimNextQueueJob(ctx, db, testTopic, now, time.Minute)
+	assert.True(t, gorm.IsRecordNotFoundError(err))
+	count, err := CountAvailableQueueJobs(ctx, db, testTopic, now)
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	// an expired lease hands the job out again
+	job, err = ClaimNextQueueJob(ctx, db, testTopic, now.Add(2*time.Minute), time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, first.ID, job.ID)
+	assert.Equal(t, 2, getJob(t, db, first.ID).Attempts)
+}
+
+func TestConsumer_DeletesOnSuccess(t *testing.T) {
+	db := newTestDB(t)
+	job := enqueue(t, db, "payload", time.Now())
+	consumer := NewPostgresConsumer(db, testTopic)
+
+	var handled string
+	claimed, err := consumer.processNext(context.Background(), context.Background(), func(ctx context.Context, data []byte) error {
+		handled = string(data)
+		// the lease is committed before the job is handled
+		assert.NotNil(t, getJob(t, db, job.ID).LockedUntil)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.True(t, claimed)
+	assert.Equal(t, "payload", handled)
+
+	count := 0
+	require.NoError(t, db.Model(&QueueJob{}).Count(&count).Error)
+	assert.Equal(t, 0, count)
+
+	claimed, err = consumer.processNext(context.Background(), context.Background(), func(ctx context.Context, data []byte) error {
+		t.Fatal("no job is left to handle")
+		return nil
+	})
+	require.NoError(t, err)
+	assert.False(t, claimed)
+}
+
+func TestConsumer_KeepsOnFailure(t *testing.T) {
+	db := newTestDB(t)
+	job := enqueue(t, db, "payload", time.Now())
+	consumer := NewPostgresConsumer(db, testTopic)
+	failing := func(ctx context.Context, data []byte) error {
+		return errors.New("smtp timeout")
+	}
+
+	before := time.Now()
+	claimed, err := consumer.processNext(context.Background(), context.Background(), failing)
+	require.NoError(t, err)
+	assert.True(t, claimed)
+
+	stored := getJob(t, db, job.ID)
+	assert.Equal(t, 1, stored.Attempts)
+	assert.Nil(t, stored.LockedUntil)
+	assert.False(t, stored.AvailableAt.Before(before.Add(consumer.retryDelay)), "failed jobs back off")
+
+	// a job interrupted by shutdown is available again at once
+	require.NoError(t, db.Model(&QueueJob{}).Where("id = ?", job.ID).Update("available_at", time.Now()).Error)
+	workCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+	claimed, err = consumer.processNext(context.Background(), workCtx, failing)
+	require.NoError(t, err)
+	assert.True(t, claimed)
+
+	stored = getJob(t, db, job.ID)
+	assert.Equal(t, 2, stored.Attempts)
+	assert.Nil(t, stored.LockedUntil)
+	assert.False(t, stored.AvailableAt.After(time.Now()))
+}
+
+func TestConsumer_Backoff(t *testing.T) {
+	consumer := &Consumer{retryDelay: time.Second, maxRetryDelay: 5 * time.Second}
+	assert.Equal(t, time.Second, consumer.backoff(1))
+	assert.Equal(t, 2*time.Second, consumer.backoff(2))
+	assert.Equal(t, 4*time.Second, consumer.backoff(3))
+	assert.Equal(t, 5*time.Second, consumer.backoff(4))
+	assert.Equal(t, 5*time.Second, consumer.backoff(100))
+}
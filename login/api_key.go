@@ -0,0 +1,231 @@
+package login
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/kp/pager/login/models"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// APIKeyHeader carries the API key of a service, in place of X-Auth-Token
+const APIKeyHeader = "X-API-Key"
+
+// apiKeyPrefix starts every API key so leaked keys are easy to recognize
+const apiKeyPrefix = "pgr_"
+
+// UserTypeService is the user type of the claims of an API key
+const UserTypeService = "service"
+
+var (
+	// ErrInvalidAPIKey is returned for an unknown, expired or revoked API key
+	ErrInvalidAPIKey = errors.New("invalid api key")
+	// ErrAPIKeyIPNotAllowed is returned for an API key used from an address
+	// outside of its allowlist
+	ErrAPIKeyIPNotAllowed = errors.New("api key not allowed from this address")
+	// ErrInvalidAPIKeyRequest is returned for an API key which cannot be created
+	ErrInvalidAPIKeyRequest = errors.New("invalid api key request")
+)
+
+// GrantablePermissions are the permissions an API key may carry, never
+// PagerAdminAccess so a leaked key cannot manage users, keys or roles
+var GrantablePermissions = []string{
+	PagerSendAccess,
+	PagerNotifcationAccess,
+	PagerTemplateAccess,
+	PagerAuthAccess,
+}
+
+// APIKeyAuthenticator returns the claims of the API key of a request
+type APIKeyAuthenticator interface {
+	Authenticate(ctx context.Context, secret, ip string) (*Claims, error)
+}
+
+type APIKeyService struct {
+	apiKeyRepo *APIKeyRepository
+}
+
+func NewAPIKeyService(apiKeyRepo *APIKeyRepository) *APIKeyService {
+	return &APIKeyService{apiKeyRepo: apiKeyRepo}
+}
+
+// Create creates an API key and returns it with its secret, which is not
+// stored and cannot be shown again
+func (s *APIKeyService) Create(ctx context.Context, req CreateAPIKeyRequest, createdBy string) (*models.APIKey, string, error) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return nil, "", fmt.Errorf("%w: name is required", ErrInvalidAPIKeyRequest)
+	}
+	permissions, err := validateAPIKeyPermissions(req.Permissions)
+	if err != nil {
+		return nil, "", err
+	}
+	allowedIPs, err := validateAllowedIPs(req.AllowedIPs)
+	if err != nil {
+		return nil, "", err
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return nil, "", fmt.Errorf("%w: expires_at must be in the future", ErrInvalidAPIKeyRequest)
+	}
+
+	secret, err := newAPIKey()
+	if err != nil {
+		return nil, "", err
+	}
+	key := &models.APIKey{
+		Name:        name,
+		Prefix:      secret[:len(apiKeyPrefix)+8],
+		KeyHash:     hashAPIKey(secret),
+		Permissions: strings.Join(permissions, ","),
+		AllowedIPs:  strings.Join(allowedIPs, ","),
+		CreatedBy:   createdBy,
+		ExpiresAt:   req.ExpiresAt,
+	}
+	if err := s.apiKeyRepo.Create(ctx, key); err != nil {
+		log.WithFields(log.Fields{
+			"name": name,
+		}).WithError(err).Error("Failed to create api key")
+		return nil, "", err
+	}
+	return key, secret, nil
+}
+
+func (s *APIKeyService) List(ctx context.Context) ([]models.APIKey, error) {
+	keys, err := s.apiKeyRepo.GetAll(ctx)
+	if err != nil {
+		log.WithError(err).Error("Failed to list api keys")
+	}
+	return keys, err
+}
+
+// Revoke revokes the API key, gorm.ErrRecordNotFound when no key is active under id
+func (s *APIKeyService) Revoke(ctx context.Context, id int64) error {
+	revoked, err := s.apiKeyRepo.Revoke(ctx, id)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"apiKeyId": id,
+		}).WithError(err).Error("Failed to revoke api key")
+		return err
+	}
+	if !revoked {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// Authenticate returns the claims of the API key used from ip and records the use
+func (s *APIKeyService) Authenticate(ctx context.Context, secret, ip string) (*Claims, error) {
+	if !strings.HasPrefix(secret, apiKeyPrefix) {
+		return nil, ErrInvalidAPIKey
+	}
+	key, err := s.apiKeyRepo.GetByHash(ctx, hashAPIKey(secret))
+	if gorm.IsRecordNotFoundError(err) {
+		return nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if key.RevokedAt != nil || (key.ExpiresAt != nil && now.After(*key.ExpiresAt)) {
+		return nil, ErrInvalidAPIKey
+	}
+	if !ipAllowed(key.AllowedIPList(), ip) {
+		log.WithFields(log.Fields{
+			"apiKeyId": key.ID,
+			"ip":       ip,
+		}).Warn("Api key used from an address outside of its allowlist")
+		return nil, ErrAPIKeyIPNotAllowed
+	}
+	if err := s.apiKeyRepo.Touch(ctx, key.ID, ip, now); err != nil {
+		log.WithFields(log.Fields{
+			"apiKeyId": key.ID,
+		}).WithError(err).Error("Failed to record api key use")
+	}
+	return &Claims{
+		Username:    "api_key:" + key.Name,
+		UserType:    UserTypeService,
+		Permissions: key.PermissionList(),
+	}, nil
+}
+
+func newAPIKey() (string, error) {
+	value := make([]byte, 32)
+	if _, err := rand.Read(value); err != nil {
+		return "", fmt.Errorf("failed to generate an api key: %w", err)
+	}
+	return apiKeyPrefix + base64.RawURLEncoding.EncodeToString(value), nil
+}
+
+func hashAPIKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func validateAPIKeyPermissions(permissions []string) ([]string, error) {
+	if len(permissions) == 0 {
+		return nil, fmt.Errorf("%w: at least one permission is required", ErrInvalidAPIKeyRequest)
+	}
+	var valid []string
+	seen := map[string]bool{}
+	for _, permission := range permissions {
+		grantable := false
+		for _, p := range GrantablePermissions {
+			grantable = grantable || p == permission
+		}
+		if !grantable {
+			return nil, fmt.Errorf("%w: unknown permission %q", ErrInvalidAPIKeyRequest, permission)
+		}
+		if !seen[permission] {
+			seen[permission] = true
+			valid = append(valid, permission)
+		}
+	}
+	return valid, nil
+}
+
+// validateAllowedIPs normalizes the allowlist to CIDR ranges
+func validateAllowedIPs(allowedIPs []string) ([]string, error) {
+	var ranges []string
+	for _, value := range allowedIPs {
+		value = strings.TrimSpace(value)
+		if ip := net.ParseIP(value); ip != nil {
+			if ip.To4() != nil {
+				value += "/32"
+			} else {
+				value += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid ip or cidr %q", ErrInvalidAPIKeyRequest, value)
+		}
+		ranges = append(ranges, network.String())
+	}
+	return ranges, nil
+}
+
+func ipAllowed(allowedIPs []string, value string) bool {
+	if len(allowedIPs) == 0 {
+		return true
+	}
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return false
+	}
+	for _, allowed := range allowedIPs {
+		if _, network, err := net.ParseCIDR(allowed); err == nil && network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,123 @@
+package login_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kp/pager/login"
+	"github.com/kp/pager/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateAllowedIPs(t *testing.T) {
+	ranges, err := login.ValidateAllowedIPs([]string{"10.0.0.7", " 192.168.1.0/24 ", "2001:db8::1", "10.1.2.3/16"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.7/32", "192.168.1.0/24", "2001:db8::1/128", "10.1.0.0/16"}, ranges)
+
+	_, err = login.ValidateAllowedIPs([]string{"example.com"})
+	assert.ErrorIs(t, err, login.ErrInvalidAPIKeyRequest)
+}
+
+func TestIPAllowed(t *testing.T) {
+	allowlist := []string{"10.0.0.7/32", "192.168.1.0/24"}
+	tests := []struct {
+		ip      string
+		allowed bool
+	}{
+		{"10.0.0.7", true},
+		{"10.0.0.8", false},
+		{"192.168.1.200", true},
+		{"not an ip", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			assert.Equal(t, tt.allowed, login.IPAllowed(allowlist, tt.ip))
+		})
+	}
+	assert.True(t, login.IPAllowed(nil, "203.0.113.9"), "an empty allowlist admits any address")
+}
+
+func TestValidateAPIKeyPermissions(t *testing.T) {
+	permissions, err := login.ValidateAPIKeyPermissions([]string{login.PagerNotifcationAccess, login.PagerTemplateAccess, login.PagerNotifcationAccess})
+	require.NoError(t, err)
+	assert.Equal(t, []string{login.PagerNotifcationAccess, login.PagerTemplateAccess}, permissions)
+
+	_, err = login.ValidateAPIKeyPermissions(nil)
+	assert.ErrorIs(t, err, login.ErrInvalidAPIKeyRequest)
+	_, err = login.ValidateAPIKeyPermissions([]string{"PAGER.EVERYTHING"})
+	assert.ErrorIs(t, err, login.ErrInvalidAPIKeyRequest)
+	_, err = login.ValidateAPIKeyPermissions([]string{login.PagerSendAccess, login.PagerAdminAccess})
+	assert.ErrorIs(t, err, login.ErrInvalidAPIKeyRequest, "api keys cannot carry admin rights")
+}
+
+func TestNewAPIKey(t *testing.T) {
+	key, err := login.NewAPIKey()
+	require.NoError(t, err)
+	other, err := login.NewAPIKey()
+	require.NoError(t, err)
+
+	assert.True(t, strings.HasPrefix(key, login.APIKeyPrefix))
+	assert.NotEqual(t, key, other)
+	assert.NotEqual(t, login.HashAPIKey(key), login.HashAPIKey(other))
+	assert.Len(t, login.HashAPIKey(key), 64)
+}
+
+// fakeAPIKeys authenticates the keys of the map with the given permissions
+type fakeAPIKeys map[string][]string
+
+func (f fakeAPIKeys) Authenticate(ctx context.Context, secret, ip string) (*login.Claims, error) {
+	permissions, ok := f[secret]
+	if !ok {
+		return nil, login.ErrInvalidAPIKey
+	}
+	return &login.Claims{Username: "api_key:test", UserType: login.UserTypeService, Permissions: permissions}, nil
+}
+
+func TestAPIKeyMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	apiKeys := fakeAPIKeys{
+		"pgr_sender": {login.PagerSendAccess, login.PagerNotifcationAccess},
+		"pgr_reader": {login.PagerNotifcationAccess},
+	}
+	auth := server.AuthPermissionMiddlewareWithAPIKeys(apiKeys)
+	router := server.InitServer(nil, server.WithTimeOut(0), server.CreateRoutes(
+		server.NotificationRouterGroupWithProducer("/pager/v1/notification", nil, auth),
+		server.APIKeyRouterGroup("/pager/v1/api-key", nil, auth),
+		server.RoleRouterGroup("/pager/v1/role", nil, auth),
+		server.AuthRouterGroup("/pager/v1/user", nil, auth),
+	))
+
+	tests := []struct {
+		name   string
+		key    string
+		method string
+		path   string
+		want   int
+	}{
+		// the handlers reject the empty request, so the key got through
+		{"send key triggers", "pgr_sender", http.MethodPost, "/pager/v1/notification/trigger/", http.StatusBadRequest},
+		{"send key uploads", "pgr_sender", http.MethodPost, "/pager/v1/notification/upload/", http.StatusBadRequest},
+		{"read key cannot trigger", "pgr_reader", http.MethodPost, "/pager/v1/notification/trigger/", http.StatusForbidden},
+		{"send key cannot create api keys", "pgr_sender", http.MethodPost, "/pager/v1/api-key/", http.StatusForbidden},
+		{"send key cannot list api keys", "pgr_sender", http.MethodGet, "/pager/v1/api-key/", http.StatusForbidden},
+		{"send key cannot create roles", "pgr_sender", http.MethodPost, "/pager/v1/role/", http.StatusForbidden},
+		{"send key cannot update roles", "pgr_sender", http.MethodPut, "/pager/v1/role/1/", http.StatusForbidden},
+		{"send key cannot assign roles", "pgr_sender", http.MethodPost, "/pager/v1/role/1/users/", http.StatusForbidden},
+		{"send key cannot register users", "pgr_sender", http.MethodPost, "/pager/v1/user/register/", http.StatusForbidden},
+		{"unknown key", "pgr_unknown", http.MethodPost, "/pager/v1/notification/trigger/", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set(login.APIKeyHeader, tt.key)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tt.want, w.Code, w.Body.String())
+		})
+	}
+}
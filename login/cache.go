@@ -14,11 +14,17 @@ var (
 )
 
 func InitCache(redisAddr string) {
+	InitRedis(redisAddr, "", 0)
+}
+
+// InitRedis connects the permission cache and the token revocation store
+func InitRedis(redisAddr, password string, db int) {
 	rdb = redis.NewClient(&redis.Options{
 		Addr:     redisAddr,
-		Password: "", // no password set
-		DB:       0,  // use default DB
+		Password: password,
+		DB:       db,
 	})
+	revocations = &redisRevocationStore{client: rdb}
 	slog.Info("Redis cache initialized", "addr", redisAddr)
 }
 
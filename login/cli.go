@@ -3,8 +3,6 @@ package login
 import (
 	"fmt"
 
-	"golang.org/x/crypto/bcrypt"
-
 	"github.com/jinzhu/gorm"
 	"github.com/kp/pager/login/models"
 )
@@ -21,15 +19,18 @@ func (c *UserCLI) CreateAdmin(username, password string) error {
 	}
 
 	// Hash password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err := ValidatePassword(username, password); err != nil {
+		return err
+	}
+	hashedPassword, err := HashPassword(password)
 	if err != nil {
-		return fmt.Errorf("failed to hash password: %v", err)
+		return err
 	}
 
 	// Create admin
 	admin := models.User{
 		Username: username,
-		Password: string(hashedPassword),
+		Password: hashedPassword,
 		UserType: "Admin",
 	}
 
@@ -43,15 +44,18 @@ func (c *UserCLI) CreateAdmin(username, password string) error {
 
 func (c *UserCLI) CreateUser(username, password, userType string) error {
 	// Hash password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err := ValidatePassword(username, password); err != nil {
+		return err
+	}
+	hashedPassword, err := HashPassword(password)
 	if err != nil {
-		return fmt.Errorf("failed to hash password: %v", err)
+		return err
 	}
 
 	// Create user
 	user := models.User{
 		Username: username,
-		Password: string(hashedPassword),
+		Password: hashedPassword,
 		UserType: userType,
 	}
 
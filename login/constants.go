@@ -11,11 +11,16 @@ const (
 	PagerNotifcationAccess = "PAGER.NOTIFICATION"
 	PagerTemplateAccess    = "PAGER.CAMPAIGN_TRIGGER"
 	PagerAuthAccess        = "PAGER.AUDIENCE"
+	// PagerSendAccess triggers notifications and uploads audiences
+	PagerSendAccess = "PAGER.SEND"
 )
 
+// Permissions of the built-in roles seeded by migrate, a user joins the role
+// named after its user type. Roles are managed through the role APIs after.
 var (
 	DefaultAdminPermissions = []string{
 		PagerAdminAccess,
+		PagerSendAccess,
 	}
 
 	DefaultMarketingPermissions = []string{
@@ -27,3 +32,10 @@ var (
 		PagerNotifcationAccess,
 	}
 )
+
+// DefaultRoles are the built-in roles by name
+var DefaultRoles = map[string][]string{
+	UserTypeAdmin:     DefaultAdminPermissions,
+	UserTypeMarketing: DefaultMarketingPermissions,
+	UserTypeNormal:    DefaultUserPermissions,
+}
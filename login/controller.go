@@ -1,10 +1,14 @@
 package login
 
 import (
+	"errors"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
 	"github.com/kp/pager/common"
 	"github.com/kp/pager/login/models"
 )
@@ -38,14 +42,16 @@ func (c *AuthController) Register(ctx *gin.Context) {
 		return
 	}
 
-	encryptedPass := common.Encryptbase64(req.Password)
-	user, permissions, err := c.authService.RegisterUser(ctx.Request.Context(), req.Username, encryptedPass, req.UserType, req.Name, req.Email)
+	user, permissions, err := c.authService.RegisterUser(ctx.Request.Context(), req.Username, req.Password, req.UserType, req.Name, req.Email)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		status := http.StatusInternalServerError
+		if errors.Is(err, ErrWeakPassword) {
+			status = http.StatusBadRequest
+		}
+		ctx.JSON(status, gin.H{"error": err.Error()})
 		return
 	}
 
-	user.Password = req.Password
 	ctx.JSON(http.StatusCreated, gin.H{
 		"status": "success",
 		"data": gin.H{
@@ -72,8 +78,7 @@ func (c *AuthController) Login(ctx *gin.Context) {
 		return
 	}
 
-	encryptedPass := common.Encryptbase64(req.Password)
-	user, permissions, err := c.authService.Login(ctx.Request.Context(), req.Username, encryptedPass)
+	user, permissions, err := c.authService.Login(ctx.Request.Context(), req.Username, req.Password)
 	if err != nil {
 		ctx.JSON(http.StatusUnauthorized, gin.H{
 			"error":   "Authentication failed",
@@ -82,12 +87,7 @@ func (c *AuthController) Login(ctx *gin.Context) {
 		return
 	}
 
-	permNames := make([]string, len(permissions))
-	for i, p := range permissions {
-		permNames[i] = p.Name
-	}
-
-	token, expiryTime, err := GenerateToken(user, permNames)
+	tokens, err := c.authService.IssueTokens(ctx.Request.Context(), user, permissions)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{
 			"error":   "Login processing error",
@@ -96,24 +96,91 @@ func (c *AuthController) Login(ctx *gin.Context) {
 		return
 	}
 
-	user.Password = ""
-	isAdmin := false
-	if user.UserType == UserTypeAdmin {
-		isAdmin = true
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"data":   tokenResponse(user, permissions, tokens),
+	})
+}
+
+// Refresh exchanges a refresh token for a new access token and refresh token
+func (c *AuthController) Refresh(ctx *gin.Context) {
+	var req RefreshTokenRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil || req.RefreshToken == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "refresh_token is required",
+		})
+		return
+	}
+
+	user, permissions, tokens, err := c.authService.Refresh(ctx.Request.Context(), req.RefreshToken)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, ErrInvalidRefreshToken) {
+			status = http.StatusUnauthorized
+		}
+		ctx.JSON(status, gin.H{
+			"error":   "Refresh failed",
+			"message": err.Error(),
+		})
+		return
 	}
+
 	ctx.JSON(http.StatusOK, gin.H{
 		"status": "success",
-		"data": gin.H{
-			"token":       token,
-			"user":        user,
-			"permissions": permNames,
-			"expiry_time": expiryTime.Format(time.RFC3339),
-			"expiry_secs": int(time.Until(expiryTime).Seconds()),
-			"is_admin":    isAdmin,
-		},
+		"data":   tokenResponse(user, permissions, tokens),
+	})
+}
+
+// Logout revokes the access token of the X-Auth-Token header and the session
+// of the refresh token in the body, either may be left out
+func (c *AuthController) Logout(ctx *gin.Context) {
+	var req RefreshTokenRequest
+	if ctx.Request.ContentLength != 0 {
+		if err := ctx.ShouldBindJSON(&req); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	var claims *Claims
+	if header := ctx.GetHeader("X-Auth-Token"); header != "" {
+		// an expired or invalid token needs no revocation
+		claims, _ = ValidateToken(strings.TrimPrefix(header, "Bearer "))
+	}
+	if claims == nil && req.RefreshToken == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "X-Auth-Token or refresh_token is required",
+		})
+		return
+	}
+
+	if err := c.authService.Logout(ctx.Request.Context(), claims, req.RefreshToken); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"status":  false,
+			"message": "Failed to logout",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status":  true,
+		"message": "Logged out successfully",
 	})
 }
 
+func tokenResponse(user *models.User, permissions []models.Permission, tokens *TokenPair) gin.H {
+	return gin.H{
+		"token":               tokens.AccessToken,
+		"refresh_token":       tokens.RefreshToken,
+		"user":                user,
+		"permissions":         permissionNames(permissions),
+		"expiry_time":         tokens.AccessExpiresAt.Format(time.RFC3339),
+		"expiry_secs":         int(time.Until(tokens.AccessExpiresAt).Seconds()),
+		"refresh_expiry_time": tokens.RefreshExpiresAt.Format(time.RFC3339),
+		"is_admin":            user.UserType == UserTypeAdmin,
+	}
+}
+
 func (c *AuthController) AddPermission(ctx *gin.Context) {
 	var req LoginRequest
 	if err := ctx.ShouldBindJSON(&req); err != nil {
@@ -200,17 +267,6 @@ func (c *AuthController) GetUserDetails(ctx *gin.Context) {
 		return
 	}
 
-	// Decrypt password before returning
-	decryptedPass, err := common.DecryptBase64(user.Password)
-	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"status":  false,
-			"message": "Failed to process user data",
-			"error":   err.Error(),
-		})
-		return
-	}
-	user.Password = decryptedPass
 	response := UserWithPermissionsResponse{
 		Response: common.Response{
 			Status: true,
@@ -310,7 +366,13 @@ func (c *AuthController) ChangePassword(ctx *gin.Context) {
 	}
 
 	if err := c.authService.ChangePassword(ctx.Request.Context(), req.UserName, req.NewPassword); err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
+		status := http.StatusInternalServerError
+		if errors.Is(err, ErrWeakPassword) {
+			status = http.StatusBadRequest
+		} else if gorm.IsRecordNotFoundError(err) {
+			status = http.StatusNotFound
+		}
+		ctx.JSON(status, gin.H{
 			"status":  false,
 			"message": "Failed to change password",
 			"error":   err.Error(),
@@ -339,3 +401,348 @@ func (c *AuthController) GetAllUsers(ctx *gin.Context) {
 	}
 	ctx.JSON(http.StatusOK, response)
 }
+
+// JWKS serves the public keys verifying auth tokens so other services can
+// verify them without calling pager
+func JWKS(ctx *gin.Context) {
+	if signingKeys == nil {
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{"error": "signing keys are not initialized"})
+		return
+	}
+	ctx.Header("Cache-Control", "public, max-age=300")
+	ctx.JSON(http.StatusOK, signingKeys.JWKS())
+}
+
+type APIKeyController struct {
+	apiKeyService *APIKeyService
+}
+
+func NewAPIKeyController(apiKeyService *APIKeyService) *APIKeyController {
+	return &APIKeyController{apiKeyService: apiKeyService}
+}
+
+// CreateAPIKey creates an API key, the response is the only time its secret is shown
+func (c *APIKeyController) CreateAPIKey(ctx *gin.Context) {
+	var req CreateAPIKeyRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"status":  false,
+			"message": "Invalid request format",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	key, secret, err := c.apiKeyService.Create(ctx.Request.Context(), req, ctx.GetString("username"))
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, ErrInvalidAPIKeyRequest) {
+			status = http.StatusBadRequest
+		}
+		ctx.JSON(status, gin.H{
+			"status":  false,
+			"message": "Failed to create api key",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{
+		"status":  true,
+		"message": "Api key created, store the key now as it cannot be shown again",
+		"data": gin.H{
+			"api_key": newAPIKeyInfo(*key),
+			"key":     secret,
+		},
+	})
+}
+
+func (c *APIKeyController) ListAPIKeys(ctx *gin.Context) {
+	keys, err := c.apiKeyService.List(ctx.Request.Context())
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"status":  false,
+			"message": "Failed to list api keys",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	infos := make([]APIKeyInfo, len(keys))
+	for i, key := range keys {
+		infos[i] = newAPIKeyInfo(key)
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"status":  true,
+		"message": "Api keys retrieved successfully",
+		"data":    infos,
+	})
+}
+
+func (c *APIKeyController) RevokeAPIKey(ctx *gin.Context) {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"status":  false,
+			"message": "Invalid api key id",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	if err := c.apiKeyService.Revoke(ctx.Request.Context(), id); err != nil {
+		status := http.StatusInternalServerError
+		if gorm.IsRecordNotFoundError(err) {
+			status = http.StatusNotFound
+		}
+		ctx.JSON(status, gin.H{
+			"status":  false,
+			"message": "Failed to revoke api key",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status":  true,
+		"message": "Api key revoked successfully",
+	})
+}
+
+type RoleController struct {
+	roleService *RoleService
+}
+
+func NewRoleController(roleService *RoleService) *RoleController {
+	return &RoleController{roleService: roleService}
+}
+
+// roleErrorStatus maps the errors of the role service to a response status
+func roleErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrInvalidRole):
+		return http.StatusBadRequest
+	case gorm.IsRecordNotFoundError(err):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func roleID(ctx *gin.Context) (int64, bool) {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"status":  false,
+			"message": "Invalid role id",
+			"error":   err.Error(),
+		})
+		return 0, false
+	}
+	return id, true
+}
+
+func (c *RoleController) CreateRole(ctx *gin.Context) {
+	var req RoleRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"status":  false,
+			"message": "Invalid request format",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	role, err := c.roleService.Create(ctx.Request.Context(), req)
+	if err != nil {
+		ctx.JSON(roleErrorStatus(err), gin.H{
+			"status":  false,
+			"message": "Failed to create role",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{
+		"status":  true,
+		"message": "Role created successfully",
+		"data":    role,
+	})
+}
+
+func (c *RoleController) ListRoles(ctx *gin.Context) {
+	roles, err := c.roleService.List(ctx.Request.Context())
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"status":  false,
+			"message": "Failed to get roles",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status":  true,
+		"message": "Roles retrieved successfully",
+		"data":    roles,
+	})
+}
+
+func (c *RoleController) GetRole(ctx *gin.Context) {
+	id, ok := roleID(ctx)
+	if !ok {
+		return
+	}
+
+	role, err := c.roleService.Get(ctx.Request.Context(), id)
+	if err != nil {
+		ctx.JSON(roleErrorStatus(err), gin.H{
+			"status":  false,
+			"message": "Failed to get role",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status":  true,
+		"message": "Role retrieved successfully",
+		"data":    role,
+	})
+}
+
+func (c *RoleController) UpdateRole(ctx *gin.Context) {
+	id, ok := roleID(ctx)
+	if !ok {
+		return
+	}
+	var req RoleRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"status":  false,
+			"message": "Invalid request format",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	role, err := c.roleService.Update(ctx.Request.Context(), id, req)
+	if err != nil {
+		ctx.JSON(roleErrorStatus(err), gin.H{
+			"status":  false,
+			"message": "Failed to update role",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status":  true,
+		"message": "Role updated successfully",
+		"data":    role,
+	})
+}
+
+func (c *RoleController) DeleteRole(ctx *gin.Context) {
+	id, ok := roleID(ctx)
+	if !ok {
+		return
+	}
+
+	if err := c.roleService.Delete(ctx.Request.Context(), id); err != nil {
+		ctx.JSON(roleErrorStatus(err), gin.H{
+			"status":  false,
+			"message": "Failed to delete role",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status":  true,
+		"message": "Role deleted successfully",
+	})
+}
+
+func (c *RoleController) GetRoleMembers(ctx *gin.Context) {
+	id, ok := roleID(ctx)
+	if !ok {
+		return
+	}
+
+	users, err := c.roleService.Members(ctx.Request.Context(), id)
+	if err != nil {
+		ctx.JSON(roleErrorStatus(err), gin.H{
+			"status":  false,
+			"message": "Failed to get role members",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status":  true,
+		"message": "Role members retrieved successfully",
+		"data":    users,
+	})
+}
+
+func (c *RoleController) AssignRole(ctx *gin.Context) {
+	id, ok := roleID(ctx)
+	if !ok {
+		return
+	}
+	var req AssignRoleRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil || req.UserID <= 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"status":  false,
+			"message": "Invalid request format",
+			"error":   "user_id is required",
+		})
+		return
+	}
+
+	if err := c.roleService.AssignUser(ctx.Request.Context(), id, req.UserID); err != nil {
+		ctx.JSON(roleErrorStatus(err), gin.H{
+			"status":  false,
+			"message": "Failed to assign role",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status":  true,
+		"message": "Role assigned successfully",
+	})
+}
+
+func (c *RoleController) UnassignRole(ctx *gin.Context) {
+	id, ok := roleID(ctx)
+	if !ok {
+		return
+	}
+	userID, err := strconv.ParseInt(ctx.Param("user_id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"status":  false,
+			"message": "Invalid user id",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	if err := c.roleService.UnassignUser(ctx.Request.Context(), id, userID); err != nil {
+		ctx.JSON(roleErrorStatus(err), gin.H{
+			"status":  false,
+			"message": "Failed to unassign role",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status":  true,
+		"message": "Role unassigned successfully",
+	})
+}
@@ -0,0 +1,12 @@
+package login
+
+// Unexported helpers used by the tests of package login_test
+var (
+	ValidateAllowedIPs        = validateAllowedIPs
+	IPAllowed                 = ipAllowed
+	ValidateAPIKeyPermissions = validateAPIKeyPermissions
+	NewAPIKey                 = newAPIKey
+	HashAPIKey                = hashAPIKey
+)
+
+const APIKeyPrefix = apiKeyPrefix
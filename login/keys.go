@@ -0,0 +1,256 @@
+package login
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/exp/slog"
+)
+
+// Algorithms of the keys signing auth tokens
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmRS256 = "RS256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+// minHMACKeyLength is the shortest HS256 secret accepted, the size of its hash
+const minHMACKeyLength = 32
+
+// ErrUnknownKey is returned for a token signed with a key which is not configured
+var ErrUnknownKey = errors.New("unknown signing key")
+
+// JWTConfig configures the keys signing auth tokens. JWT_SIGNING_KEYS is a json
+// list of {"kid","alg","key"} where key is the secret of an HS256 key or the PEM
+// of an RS256 or EdDSA key. JWT_ACTIVE_KEY_ID names the key signing new tokens,
+// the first one by default. To rotate, add a new key, make it active and drop
+// the old one once the tokens it signed expired; old asymmetric keys may be
+// reduced to their public key meanwhile.
+type JWTConfig struct {
+	SigningKeys string `json:"JWT_SIGNING_KEYS"`
+	ActiveKeyID string `json:"JWT_ACTIVE_KEY_ID"`
+}
+
+type keyConfig struct {
+	ID        string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Key       string `json:"key"`
+}
+
+// SigningKey is a key verifying auth tokens, it signs them too unless only
+// its public key is known
+type SigningKey struct {
+	ID         string
+	Algorithm  string
+	signingKey interface{}
+	verifyKey  interface{}
+}
+
+// KeySet holds the configured keys by kid
+type KeySet struct {
+	active *SigningKey
+	keys   map[string]*SigningKey
+	order  []string
+}
+
+var signingKeys *KeySet
+
+// InitSigningKeys loads the signing keys. Without keys a random EdDSA key is
+// generated, tokens then stop being valid when the process restarts.
+func InitSigningKeys(config JWTConfig) error {
+	if strings.TrimSpace(config.SigningKeys) == "" {
+		keys, err := generateKeySet()
+		if err != nil {
+			return err
+		}
+		slog.Warn("JWT_SIGNING_KEYS is not set, signing tokens with a temporary key")
+		signingKeys = keys
+		return nil
+	}
+	keys, err := ParseKeySet(config)
+	if err != nil {
+		return err
+	}
+	signingKeys = keys
+	return nil
+}
+
+// ParseKeySet parses the signing keys of config
+func ParseKeySet(config JWTConfig) (*KeySet, error) {
+	var entries []keyConfig
+	if err := json.Unmarshal([]byte(config.SigningKeys), &entries); err != nil {
+		return nil, fmt.Errorf("invalid JWT_SIGNING_KEYS: %v", err)
+	}
+	set := &KeySet{keys: map[string]*SigningKey{}}
+	for _, entry := range entries {
+		if entry.ID == "" {
+			return nil, errors.New("invalid JWT_SIGNING_KEYS: every key needs a kid")
+		}
+		if _, ok := set.keys[entry.ID]; ok {
+			return nil, fmt.Errorf("invalid JWT_SIGNING_KEYS: duplicate kid %q", entry.ID)
+		}
+		key, err := parseSigningKey(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWT_SIGNING_KEYS: key %q: %v", entry.ID, err)
+		}
+		set.keys[key.ID] = key
+		set.order = append(set.order, key.ID)
+	}
+	if len(set.order) == 0 {
+		return nil, errors.New("invalid JWT_SIGNING_KEYS: no keys")
+	}
+
+	activeID := config.ActiveKeyID
+	if activeID == "" {
+		activeID = set.order[0]
+	}
+	active, ok := set.keys[activeID]
+	if !ok {
+		return nil, fmt.Errorf("invalid JWT_ACTIVE_KEY_ID: no key %q", activeID)
+	}
+	if active.signingKey == nil {
+		return nil, fmt.Errorf("invalid JWT_ACTIVE_KEY_ID: key %q has no private key", activeID)
+	}
+	set.active = active
+	return set, nil
+}
+
+func parseSigningKey(entry keyConfig) (*SigningKey, error) {
+	key := &SigningKey{ID: entry.ID, Algorithm: entry.Algorithm}
+	switch entry.Algorithm {
+	case AlgorithmHS256:
+		if len(entry.Key) < minHMACKeyLength {
+			return nil, fmt.Errorf("an HS256 secret needs at least %d bytes", minHMACKeyLength)
+		}
+		key.signingKey, key.verifyKey = []byte(entry.Key), []byte(entry.Key)
+		return key, nil
+	case AlgorithmRS256, AlgorithmEdDSA:
+	default:
+		return nil, fmt.Errorf("unsupported alg %q", entry.Algorithm)
+	}
+
+	block, _ := pem.Decode([]byte(entry.Key))
+	if block == nil {
+		return nil, errors.New("the key is not PEM encoded")
+	}
+	var parsed interface{}
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		parsed, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.signingKey, key.verifyKey = k, &k.PublicKey
+	case *rsa.PublicKey:
+		key.verifyKey = k
+	case ed25519.PrivateKey:
+		key.signingKey, key.verifyKey = k, k.Public()
+	case ed25519.PublicKey:
+		key.verifyKey = k
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", parsed)
+	}
+	_, isRSA := key.verifyKey.(*rsa.PublicKey)
+	if isRSA != (entry.Algorithm == AlgorithmRS256) {
+		return nil, fmt.Errorf("the key does not match alg %s", entry.Algorithm)
+	}
+	return key, nil
+}
+
+func generateKeySet() (*KeySet, error) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate a signing key: %w", err)
+	}
+	id := fmt.Sprintf("temporary-%x", private.Public().(ed25519.PublicKey)[:8])
+	key := &SigningKey{ID: id, Algorithm: AlgorithmEdDSA, signingKey: private, verifyKey: private.Public()}
+	return &KeySet{active: key, keys: map[string]*SigningKey{id: key}, order: []string{id}}, nil
+}
+
+// method returns the jwt signing method of the key
+func (k *SigningKey) method() jwt.SigningMethod {
+	return jwt.GetSigningMethod(k.Algorithm)
+}
+
+// sign signs claims with the active key, naming it in the kid header
+func (s *KeySet) sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(s.active.method(), claims)
+	token.Header["kid"] = s.active.ID
+	return token.SignedString(s.active.signingKey)
+}
+
+// keyFunc returns the key verifying token, the kid must name a configured key
+// of the algorithm the token claims
+func (s *KeySet) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := s.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, kid)
+	}
+	if token.Method.Alg() != key.Algorithm {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return key.verifyKey, nil
+}
+
+// JWK is a public key in the JSON Web Key format of RFC 7517
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+}
+
+// JWKSet is the document served at /.well-known/jwks.json
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys of the set, HS256 secrets are never published
+func (s *KeySet) JWKS() JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+	for _, id := range s.order {
+		key := s.keys[id]
+		jwk := JWK{KeyID: key.ID, Algorithm: key.Algorithm, Use: "sig"}
+		switch public := key.verifyKey.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType, jwk.Curve = "OKP", "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
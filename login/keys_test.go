@@ -0,0 +1,152 @@
+package login
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/kp/pager/login/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testHMACSecret = "0123456789abcdef0123456789abcdef"
+
+func pemKey(t *testing.T, blockType string, der []byte, err error) string {
+	t.Helper()
+	require.NoError(t, err)
+	return string(pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}))
+}
+
+func keysConfig(t *testing.T, active string, keys ...keyConfig) JWTConfig {
+	t.Helper()
+	value, err := json.Marshal(keys)
+	require.NoError(t, err)
+	return JWTConfig{SigningKeys: string(value), ActiveKeyID: active}
+}
+
+func useKeys(t *testing.T, config JWTConfig) {
+	t.Helper()
+	previous := signingKeys
+	t.Cleanup(func() { signingKeys = previous })
+	require.NoError(t, InitSigningKeys(config))
+}
+
+func TestSigningKeys_Algorithms(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	edDER, err := x509.MarshalPKCS8PrivateKey(edKey)
+
+	keys := map[string]keyConfig{
+		AlgorithmHS256: {ID: "hs", Algorithm: AlgorithmHS256, Key: testHMACSecret},
+		AlgorithmRS256: {ID: "rs", Algorithm: AlgorithmRS256, Key: pemKey(t, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey), nil)},
+		AlgorithmEdDSA: {ID: "ed", Algorithm: AlgorithmEdDSA, Key: pemKey(t, "PRIVATE KEY", edDER, err)},
+	}
+	for alg, key := range keys {
+		t.Run(alg, func(t *testing.T) {
+			useKeys(t, keysConfig(t, "", key))
+
+			token, _, err := GenerateToken(&models.User{Username: "ada", UserType: UserTypeAdmin}, []string{PagerAdminAccess}, 0)
+			require.NoError(t, err)
+			parsed, _, err := jwt.NewParser().ParseUnverified(token, &Claims{})
+			require.NoError(t, err)
+			assert.Equal(t, key.ID, parsed.Header["kid"])
+			assert.Equal(t, alg, parsed.Method.Alg())
+
+			claims, err := ValidateToken(token)
+			require.NoError(t, err)
+			assert.Equal(t, "ada", claims.Username)
+			assert.Equal(t, []string{PagerAdminAccess}, claims.Permissions)
+		})
+	}
+}
+
+func TestSigningKeys_Rotation(t *testing.T) {
+	_, oldKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	oldDER, err := x509.MarshalPKCS8PrivateKey(oldKey)
+	oldPrivate := keyConfig{ID: "2026-04", Algorithm: AlgorithmEdDSA, Key: pemKey(t, "PRIVATE KEY", oldDER, err)}
+	publicDER, err := x509.MarshalPKIXPublicKey(oldKey.Public())
+	oldPublic := keyConfig{ID: "2026-04", Algorithm: AlgorithmEdDSA, Key: pemKey(t, "PUBLIC KEY", publicDER, err)}
+	_, newKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	newDER, err := x509.MarshalPKCS8PrivateKey(newKey)
+	newPrivate := keyConfig{ID: "2026-10", Algorithm: AlgorithmEdDSA, Key: pemKey(t, "PRIVATE KEY", newDER, err)}
+
+	useKeys(t, keysConfig(t, "", oldPrivate))
+	oldToken, _, err := GenerateToken(&models.User{Username: "ada"}, nil, 0)
+	require.NoError(t, err)
+
+	// the new key signs while the public half of the old one still verifies
+	useKeys(t, keysConfig(t, "2026-10", oldPublic, newPrivate))
+	newToken, _, err := GenerateToken(&models.User{Username: "ada"}, nil, 0)
+	require.NoError(t, err)
+	_, err = ValidateToken(oldToken)
+	assert.NoError(t, err)
+	_, err = ValidateToken(newToken)
+	assert.NoError(t, err)
+
+	jwks := signingKeys.JWKS()
+	require.Len(t, jwks.Keys, 2)
+	assert.Equal(t, "2026-04", jwks.Keys[0].KeyID)
+	assert.Equal(t, "OKP", jwks.Keys[0].KeyType)
+	assert.Equal(t, "Ed25519", jwks.Keys[0].Curve)
+
+	// once dropped, the old key no longer verifies
+	useKeys(t, keysConfig(t, "", newPrivate))
+	_, err = ValidateToken(oldToken)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+	_, err = ValidateToken(newToken)
+	assert.NoError(t, err)
+}
+
+func TestSigningKeys_RejectsAlgorithmConfusion(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	publicDER, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	publicPEM := pemKey(t, "PUBLIC KEY", publicDER, err)
+	useKeys(t, keysConfig(t, "hs",
+		keyConfig{ID: "hs", Algorithm: AlgorithmHS256, Key: testHMACSecret},
+		keyConfig{ID: "rs", Algorithm: AlgorithmRS256, Key: publicPEM}))
+
+	// an HS256 token keyed with the public RSA key must not verify as kid rs
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, &Claims{Username: "mallory"})
+	token.Header["kid"] = "rs"
+	forged, err := token.SignedString([]byte(publicPEM))
+	require.NoError(t, err)
+	_, err = ValidateToken(forged)
+	assert.Error(t, err)
+
+	// HS256 secrets are never published
+	jwks := signingKeys.JWKS()
+	require.Len(t, jwks.Keys, 1)
+	assert.Equal(t, "rs", jwks.Keys[0].KeyID)
+	assert.Equal(t, "RSA", jwks.Keys[0].KeyType)
+	assert.Equal(t, "AQAB", jwks.Keys[0].E)
+}
+
+func TestParseKeySet_Invalid(t *testing.T) {
+	tests := map[string]JWTConfig{
+		"not json":         {SigningKeys: "secret"},
+		"no keys":          {SigningKeys: "[]"},
+		"short secret":     {SigningKeys: `[{"kid":"a","alg":"HS256","key":"short"}]`},
+		"unsupported alg":  {SigningKeys: `[{"kid":"a","alg":"none","key":"` + testHMACSecret + `"}]`},
+		"duplicate kid":    {SigningKeys: `[{"kid":"a","alg":"HS256","key":"` + testHMACSecret + `"},{"kid":"a","alg":"HS256","key":"` + testHMACSecret + `"}]`},
+		"unknown active":   {SigningKeys: `[{"kid":"a","alg":"HS256","key":"` + testHMACSecret + `"}]`, ActiveKeyID: "b"},
+		"not pem":          {SigningKeys: `[{"kid":"a","alg":"RS256","key":"` + testHMACSecret + `"}]`},
+		"missing kid name": {SigningKeys: `[{"alg":"HS256","key":"` + testHMACSecret + `"}]`},
+	}
+	for name, config := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := ParseKeySet(config)
+			assert.Error(t, err)
+		})
+	}
+}
@@ -3,53 +3,69 @@ package login
 import (
 	"context"
 	"errors"
-	"fmt"
 	"net/http"
 	"strings"
 	"time"
 
-	"github.com/dgrijalva/jwt-go"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/jinzhu/gorm"
 
+	"github.com/kp/pager/common"
 	"github.com/kp/pager/login/models"
 )
 
-var jwtKey = []byte("your-secret-key") // TODO: Move to config
-
 // InitCache should be called during application startup
 // with the Redis server address
 
+// tokenIssuer is the iss claim of the tokens issued by pager
+const tokenIssuer = "pager"
+
 type Claims struct {
 	Username    string   `json:"username"`
 	UserType    string   `json:"user_type"`
 	Permissions []string `json:"permissions"`
-	jwt.StandardClaims
+	// Generation is the token generation of the user at issue, see RevocationStore
+	Generation int64 `json:"gen"`
+	jwt.RegisteredClaims
 }
 
-func GenerateToken(user *models.User, permissions []string) (string, time.Time, error) {
-	expirationTime := time.Now().Add(72 * time.Hour)
+// GenerateToken issues a short lived access token of user, generation is the
+// current token generation of the user
+func GenerateToken(user *models.User, permissions []string, generation int64) (string, time.Time, error) {
+	if signingKeys == nil {
+		return "", time.Time{}, errors.New("signing keys are not initialized")
+	}
+	now := time.Now()
+	expirationTime := now.Add(accessTokenTTL)
 
 	claims := &Claims{
 		Username:    user.Username,
 		UserType:    user.UserType,
 		Permissions: permissions,
-		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: expirationTime.Unix(),
+		Generation:  generation,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        common.GenerateUUID(),
+			Issuer:    tokenIssuer,
+			Subject:   user.Username,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expirationTime),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(jwtKey)
+	tokenString, err := signingKeys.sign(claims)
 	return tokenString, expirationTime, err
 }
 
+// ValidateToken verifies tokenString with the key named by its kid, any
+// configured key is accepted so tokens survive a rotation until they expire
 func ValidateToken(tokenString string) (*Claims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return jwtKey, nil
-	})
+	if signingKeys == nil {
+		return nil, errors.New("signing keys are not initialized")
+	}
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, signingKeys.keyFunc,
+		jwt.WithValidMethods([]string{AlgorithmHS256, AlgorithmRS256, AlgorithmEdDSA}),
+		jwt.WithIssuer(tokenIssuer),
+		jwt.WithExpirationRequired())
 
 	if err != nil {
 		return nil, err
@@ -82,6 +98,13 @@ func AuthMiddleware(db *gorm.DB) func(http.Handler) http.Handler {
 				return
 			}
 
+			if revoked, err := IsRevoked(r.Context(), claims); err != nil || revoked {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(`{"error": "Invalid token"}`))
+				return
+			}
+
 			// Add claims to context
 			ctx := r.Context()
 			ctx = context.WithValue(ctx, "username", claims.Username)
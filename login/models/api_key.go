@@ -0,0 +1,97 @@
+package models
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/kp/pager/databases/sql"
+)
+
+const APIKeyTableName = "pager_api_keys"
+
+func (APIKey) TableName() string {
+	return APIKeyTableName
+}
+
+// APIKey authenticates a service calling pager, only the sha256 of the key is
+// stored. Permissions and AllowedIPs are comma separated, an empty allowlist
+// admits any address.
+type APIKey struct {
+	ID          int64      `json:"id" gorm:"primary_key;autoIncrement:true;column:id"`
+	Name        string     `json:"name" gorm:"column:name;size:255;not null"`
+	Prefix      string     `json:"prefix" gorm:"column:prefix;size:16;not null"`
+	KeyHash     string     `json:"-" gorm:"column:key_hash;size:64;not null;unique_index:idx_api_keys_key_hash"`
+	Permissions string     `json:"-" gorm:"column:permissions;type:text;not null"`
+	AllowedIPs  string     `json:"-" gorm:"column:allowed_ips;type:text"`
+	CreatedBy   string     `json:"created_by" gorm:"column:created_by;size:255"`
+	ExpiresAt   *time.Time `json:"expires_at" gorm:"column:expires_at"`
+	LastUsedAt  *time.Time `json:"last_used_at" gorm:"column:last_used_at"`
+	LastUsedIP  string     `json:"last_used_ip" gorm:"column:last_used_ip;size:64"`
+	RevokedAt   *time.Time `json:"revoked_at" gorm:"column:revoked_at"`
+	CreatedAt   time.Time  `json:"created_at" gorm:"column:created_at;autoCreateTime"`
+}
+
+// PermissionList returns the permissions granted to the key
+func (key *APIKey) PermissionList() []string {
+	return splitList(key.Permissions)
+}
+
+// AllowedIPList returns the addresses and CIDR ranges the key may be used from
+func (key *APIKey) AllowedIPList() []string {
+	return splitList(key.AllowedIPs)
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func CreateAPIKey(ctx context.Context, tx interface{}, key *APIKey) error {
+	db := sql.GetOrmQuearyable(ctx, tx)
+	return db.Create(key).Error
+}
+
+func GetAPIKeyByHash(ctx context.Context, tx interface{}, keyHash string) (*APIKey, error) {
+	var key APIKey
+	db := sql.GetOrmQuearyable(ctx, tx)
+	err := db.Where("key_hash = ?", keyHash).First(&key).Error
+	return &key, err
+}
+
+func GetAPIKeyByID(ctx context.Context, tx interface{}, id int64) (*APIKey, error) {
+	var key APIKey
+	db := sql.GetOrmQuearyable(ctx, tx)
+	err := db.First(&key, id).Error
+	return &key, err
+}
+
+// ListAPIKeys returns the keys newest first, revoked keys included
+func ListAPIKeys(ctx context.Context, tx interface{}) ([]APIKey, error) {
+	var keys []APIKey
+	db := sql.GetOrmQuearyable(ctx, tx)
+	err := db.Order("id DESC").Find(&keys).Error
+	return keys, err
+}
+
+// RevokeAPIKey revokes the key, revoked reports whether it was still active
+func RevokeAPIKey(ctx context.Context, tx interface{}, id int64) (revoked bool, err error) {
+	db := sql.GetOrmQuearyable(ctx, tx)
+	result := db.Model(&APIKey{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", time.Now())
+	return result.RowsAffected == 1, result.Error
+}
+
+// TouchAPIKey records a use of the key
+func TouchAPIKey(ctx context.Context, tx interface{}, id int64, ip string, usedAt time.Time) error {
+	db := sql.GetOrmQuearyable(ctx, tx)
+	return db.Model(&APIKey{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{"last_used_at": usedAt, "last_used_ip": ip}).Error
+}
@@ -0,0 +1,72 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/kp/pager/databases/sql"
+)
+
+const RefreshTokenTableName = "pager_refresh_tokens"
+
+func (RefreshToken) TableName() string {
+	return RefreshTokenTableName
+}
+
+// RefreshToken is a refresh token issued at login, only its sha256 is stored.
+// Every refresh replaces the token by a new one of the same family, so a
+// revoked token presented again reveals a stolen family.
+type RefreshToken struct {
+	ID        int64      `json:"id" gorm:"primary_key;autoIncrement:true;column:id"`
+	UserID    int64      `json:"user_id" gorm:"column:user_id;not null;index:idx_refresh_tokens_user_id"`
+	FamilyID  string     `json:"family_id" gorm:"column:family_id;size:36;not null;index:idx_refresh_tokens_family_id"`
+	TokenHash string     `json:"-" gorm:"column:token_hash;size:64;not null;unique_index:idx_refresh_tokens_token_hash"`
+	ExpiresAt time.Time  `json:"expires_at" gorm:"column:expires_at;not null"`
+	RevokedAt *time.Time `json:"revoked_at" gorm:"column:revoked_at"`
+	CreatedAt time.Time  `json:"created_at" gorm:"column:created_at;autoCreateTime"`
+}
+
+func CreateRefreshToken(ctx context.Context, tx interface{}, userID int64, familyID, tokenHash string, expiresAt time.Time) (*RefreshToken, error) {
+	db := sql.GetOrmQuearyable(ctx, tx)
+	token := RefreshToken{
+		UserID:    userID,
+		FamilyID:  familyID,
+		TokenHash: tokenHash,
+		ExpiresAt: expiresAt,
+	}
+	err := db.Create(&token).Error
+	return &token, err
+}
+
+func GetRefreshTokenByHash(ctx context.Context, tx interface{}, tokenHash string) (*RefreshToken, error) {
+	var token RefreshToken
+	db := sql.GetOrmQuearyable(ctx, tx)
+	err := db.Where("token_hash = ?", tokenHash).First(&token).Error
+	return &token, err
+}
+
+// RevokeRefreshToken revokes the token unless it already was, revoked reports
+// whether this call revoked it so only one of concurrent refreshes succeeds
+func RevokeRefreshToken(ctx context.Context, tx interface{}, id int64) (revoked bool, err error) {
+	db := sql.GetOrmQuearyable(ctx, tx)
+	result := db.Model(&RefreshToken{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", time.Now())
+	return result.RowsAffected == 1, result.Error
+}
+
+// RevokeRefreshTokenFamily revokes every token of the family
+func RevokeRefreshTokenFamily(ctx context.Context, tx interface{}, familyID string) error {
+	db := sql.GetOrmQuearyable(ctx, tx)
+	return db.Model(&RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// RevokeUserRefreshTokens revokes every token of the user
+func RevokeUserRefreshTokens(ctx context.Context, tx interface{}, userID int64) error {
+	db := sql.GetOrmQuearyable(ctx, tx)
+	return db.Model(&RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
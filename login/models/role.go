@@ -0,0 +1,47 @@
+package models
+
+import (
+	"time"
+)
+
+const (
+	RoleTableName           = "pager_roles"
+	RolePermissionTableName = "pager_roles_permissions"
+	UserRoleTableName       = "pager_users_roles"
+)
+
+func (Role) TableName() string {
+	return RoleTableName
+}
+
+func (RolePermission) TableName() string {
+	return RolePermissionTableName
+}
+
+func (UserRole) TableName() string {
+	return UserRoleTableName
+}
+
+// Role is a named set of permissions, the effective permissions of a user
+// are the permissions of its roles and the ones granted to it directly
+type Role struct {
+	ID          int64     `json:"id" gorm:"primary_key;autoIncrement:true;column:id"`
+	Name        string    `json:"name" gorm:"column:name;size:255;not null;unique_index:idx_roles_name"`
+	Description string    `json:"description" gorm:"column:description;size:500"`
+	CreatedAt   time.Time `json:"created_at" gorm:"column:created_at"`
+	UpdatedAt   time.Time `json:"updated_at" gorm:"column:updated_at"`
+}
+
+type RolePermission struct {
+	ID           int64     `json:"id" gorm:"primary_key;autoIncrement:true;column:id"`
+	RoleID       int64     `json:"role_id" gorm:"column:role_id;not null;unique_index:idx_roles_permissions_role_permission"`
+	PermissionID uint      `json:"permission_id" gorm:"column:permission_id;not null;unique_index:idx_roles_permissions_role_permission"`
+	CreatedAt    time.Time `json:"created_at" gorm:"column:created_at"`
+}
+
+type UserRole struct {
+	ID        int64     `json:"id" gorm:"primary_key;autoIncrement:true;column:id"`
+	UserID    int64     `json:"user_id" gorm:"column:user_id;not null;unique_index:idx_users_roles_user_role"`
+	RoleID    int64     `json:"role_id" gorm:"column:role_id;not null;unique_index:idx_users_roles_user_role;index:idx_users_roles_role_id"`
+	CreatedAt time.Time `json:"created_at" gorm:"column:created_at"`
+}
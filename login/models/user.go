@@ -16,7 +16,7 @@ func (User) TableName() string {
 type User struct {
 	ID        int64     `json:"id" gorm:"primary_key;autoIncrement:true;column:id"`
 	Username  string    `json:"username" gorm:"column:username;size:255;not null;unique;index:idx_users_username"`
-	Password  string    `json:"password" gorm:"column:password;size:255;not null"`
+	Password  string    `json:"-" gorm:"column:password;size:255;not null"`
 	Name      string    `json:"name" gorm:"column:name;size:255;not null"`
 	UserType  string    `json:"user_type" gorm:"column:user_type;size:50;not null"` // Admin, User
 	CreatedAt time.Time `json:"created_at" gorm:"column:created_at;autoCreateTime"`
@@ -0,0 +1,120 @@
+package login
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/kp/pager/common"
+)
+
+const (
+	defaultPasswordMinLength = 10
+	// maxPasswordLength is the bcrypt limit, longer passwords would be truncated
+	maxPasswordLength = 72
+)
+
+// ErrWeakPassword is returned for a password rejected by the password policy
+var ErrWeakPassword = errors.New("password does not meet the password policy")
+
+// ErrInvalidPassword is returned when a password does not match the stored hash
+var ErrInvalidPassword = errors.New("invalid password")
+
+// PasswordConfig configures password hashing and the password policy,
+// the bcrypt cost defaults to bcrypt.DefaultCost and the minimum length to 10
+type PasswordConfig struct {
+	BcryptCost string `json:"PASSWORD_BCRYPT_COST"`
+	MinLength  string `json:"PASSWORD_MIN_LENGTH"`
+}
+
+var (
+	passwordCost      = bcrypt.DefaultCost
+	passwordMinLength = defaultPasswordMinLength
+)
+
+// InitPassword sets the bcrypt cost and the minimum password length
+func InitPassword(config PasswordConfig) error {
+	cost, minLength := bcrypt.DefaultCost, defaultPasswordMinLength
+	if config.BcryptCost != "" {
+		value, err := strconv.Atoi(config.BcryptCost)
+		if err != nil || value < bcrypt.MinCost || value > bcrypt.MaxCost {
+			return fmt.Errorf("invalid PASSWORD_BCRYPT_COST %q, must be between %d and %d", config.BcryptCost, bcrypt.MinCost, bcrypt.MaxCost)
+		}
+		cost = value
+	}
+	if config.MinLength != "" {
+		value, err := strconv.Atoi(config.MinLength)
+		if err != nil || value < 1 || value > maxPasswordLength {
+			return fmt.Errorf("invalid PASSWORD_MIN_LENGTH %q", config.MinLength)
+		}
+		minLength = value
+	}
+	passwordCost, passwordMinLength = cost, minLength
+	return nil
+}
+
+// HashPassword returns the bcrypt hash of password with the configured cost
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), passwordCost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+	return string(hash), nil
+}
+
+// VerifyPassword compares password with the stored credential. Besides bcrypt
+// hashes it accepts the base64 and plaintext rows written by earlier versions,
+// rehash reports that the stored credential should be replaced by a new hash.
+func VerifyPassword(stored, password string) (rehash bool, err error) {
+	if isBcryptHash(stored) {
+		if err := bcrypt.CompareHashAndPassword([]byte(stored), []byte(password)); err != nil {
+			return false, ErrInvalidPassword
+		}
+		cost, err := bcrypt.Cost([]byte(stored))
+		return err != nil || cost != passwordCost, nil
+	}
+	if stored == "" {
+		return false, ErrInvalidPassword
+	}
+	if subtle.ConstantTimeCompare([]byte(stored), []byte(common.Encryptbase64(password))) == 1 ||
+		subtle.ConstantTimeCompare([]byte(stored), []byte(password)) == 1 {
+		return true, nil
+	}
+	return false, ErrInvalidPassword
+}
+
+func isBcryptHash(stored string) bool {
+	return strings.HasPrefix(stored, "$2a$") || strings.HasPrefix(stored, "$2b$") || strings.HasPrefix(stored, "$2y$")
+}
+
+// ValidatePassword checks password against the password policy: the minimum
+// length, at most 72 bytes, a letter and a digit, and not containing the username
+func ValidatePassword(username, password string) error {
+	if len([]rune(password)) < passwordMinLength {
+		return fmt.Errorf("%w: it must be at least %d characters", ErrWeakPassword, passwordMinLength)
+	}
+	if len(password) > maxPasswordLength {
+		return fmt.Errorf("%w: it must be at most %d bytes", ErrWeakPassword, maxPasswordLength)
+	}
+	var hasLetter, hasDigit bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLetter(r):
+			hasLetter = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		}
+	}
+	if !hasLetter || !hasDigit {
+		return fmt.Errorf("%w: it must contain a letter and a digit", ErrWeakPassword)
+	}
+	if username != "" && strings.Contains(strings.ToLower(password), strings.ToLower(username)) {
+		return fmt.Errorf("%w: it must not contain the username", ErrWeakPassword)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package login
+
+import (
+	"testing"
+
+	"github.com/kp/pager/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestHashAndVerifyPassword(t *testing.T) {
+	require.NoError(t, InitPassword(PasswordConfig{BcryptCost: "4"}))
+	t.Cleanup(func() { InitPassword(PasswordConfig{}) })
+
+	hash, err := HashPassword("correct-horse-42")
+	require.NoError(t, err)
+	assert.NotEqual(t, "correct-horse-42", hash)
+
+	rehash, err := VerifyPassword(hash, "correct-horse-42")
+	require.NoError(t, err)
+	assert.False(t, rehash)
+
+	_, err = VerifyPassword(hash, "wrong-horse-42")
+	assert.ErrorIs(t, err, ErrInvalidPassword)
+
+	// a hash with another cost is upgraded on the next login
+	require.NoError(t, InitPassword(PasswordConfig{BcryptCost: "5"}))
+	rehash, err = VerifyPassword(hash, "correct-horse-42")
+	require.NoError(t, err)
+	assert.True(t, rehash)
+}
+
+func TestVerifyPassword_Legacy(t *testing.T) {
+	tests := []struct {
+		name     string
+		stored   string
+		password string
+		wantErr  bool
+	}{
+		{"base64", common.Encryptbase64("secret"), "secret", false},
+		{"plaintext", "secret", "secret", false},
+		{"wrong base64", common.Encryptbase64("secret"), "other", true},
+		{"empty", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rehash, err := VerifyPassword(tt.stored, tt.password)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidPassword)
+				return
+			}
+			require.NoError(t, err)
+			assert.True(t, rehash)
+		})
+	}
+}
+
+func TestValidatePassword(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		valid    bool
+	}{
+		{"valid", "correct-horse-42", true},
+		{"too short", "abc123", false},
+		{"no digit", "correct-horse", false},
+		{"no letter", "1234567890", false},
+		{"contains username", "Ada-Lovelace-1815", false},
+		{"too long", string(make([]byte, 70)) + "ab12", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidatePassword("ada-lovelace", tt.password)
+			if tt.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, ErrWeakPassword)
+			}
+		})
+	}
+}
+
+func TestInitPassword_Invalid(t *testing.T) {
+	assert.Error(t, InitPassword(PasswordConfig{BcryptCost: "99"}))
+	assert.Error(t, InitPassword(PasswordConfig{MinLength: "zero"}))
+	assert.Equal(t, bcrypt.DefaultCost, passwordCost)
+}
@@ -2,8 +2,10 @@ package login
 
 import (
 	"context"
+	"time"
 
 	"github.com/jinzhu/gorm"
+	"github.com/kp/pager/databases/sql"
 	"github.com/kp/pager/login/models"
 )
 
@@ -108,3 +110,198 @@ func (r *UserPermissionRepository) GetForUser(ctx context.Context, userID string
 		Find(&permissions).Error
 	return permissions, err
 }
+
+type RefreshTokenRepository struct {
+	db *gorm.DB
+}
+
+func NewRefreshTokenRepository(db *gorm.DB) *RefreshTokenRepository {
+	return &RefreshTokenRepository{db: db}
+}
+
+func (r *RefreshTokenRepository) Create(ctx context.Context, userID int64, familyID, tokenHash string, expiresAt time.Time) (*models.RefreshToken, error) {
+	return models.CreateRefreshToken(ctx, r.db, userID, familyID, tokenHash, expiresAt)
+}
+
+func (r *RefreshTokenRepository) GetByHash(ctx context.Context, tokenHash string) (*models.RefreshToken, error) {
+	return models.GetRefreshTokenByHash(ctx, r.db, tokenHash)
+}
+
+func (r *RefreshTokenRepository) Revoke(ctx context.Context, id int64) (bool, error) {
+	return models.RevokeRefreshToken(ctx, r.db, id)
+}
+
+func (r *RefreshTokenRepository) RevokeFamily(ctx context.Context, familyID string) error {
+	return models.RevokeRefreshTokenFamily(ctx, r.db, familyID)
+}
+
+func (r *RefreshTokenRepository) RevokeForUser(ctx context.Context, userID int64) error {
+	return models.RevokeUserRefreshTokens(ctx, r.db, userID)
+}
+
+type APIKeyRepository struct {
+	db *gorm.DB
+}
+
+func NewAPIKeyRepository(db *gorm.DB) *APIKeyRepository {
+	return &APIKeyRepository{db: db}
+}
+
+func (r *APIKeyRepository) Create(ctx context.Context, key *models.APIKey) error {
+	return models.CreateAPIKey(ctx, r.db, key)
+}
+
+func (r *APIKeyRepository) GetByHash(ctx context.Context, keyHash string) (*models.APIKey, error) {
+	return models.GetAPIKeyByHash(ctx, r.db, keyHash)
+}
+
+func (r *APIKeyRepository) GetAll(ctx context.Context) ([]models.APIKey, error) {
+	return models.ListAPIKeys(ctx, r.db)
+}
+
+func (r *APIKeyRepository) Revoke(ctx context.Context, id int64) (bool, error) {
+	return models.RevokeAPIKey(ctx, r.db, id)
+}
+
+func (r *APIKeyRepository) Touch(ctx context.Context, id int64, ip string, usedAt time.Time) error {
+	return models.TouchAPIKey(ctx, r.db, id, ip, usedAt)
+}
+
+type RoleRepository struct {
+	db *gorm.DB
+}
+
+func NewRoleRepository(db *gorm.DB) *RoleRepository {
+	return &RoleRepository{db: db}
+}
+
+// inTransaction runs fn in a transaction which is rolled back when fn fails
+func (r *RoleRepository) inTransaction(function string, fn func(tx *gorm.DB) error) error {
+	tx := r.db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if err := fn(tx); err != nil {
+		sql.TxRollBack(tx, function)
+		return err
+	}
+	return tx.Commit().Error
+}
+
+// Create creates a role with the permissions
+func (r *RoleRepository) Create(ctx context.Context, name, description string, permissionIDs []uint) (*models.Role, error) {
+	role := models.Role{Name: name, Description: description}
+	err := r.inTransaction("createRole", func(tx *gorm.DB) error {
+		if err := tx.Create(&role).Error; err != nil {
+			return err
+		}
+		return setRolePermissions(tx, role.ID, permissionIDs)
+	})
+	return &role, err
+}
+
+// Update saves the role and, unless nil, replaces its permissions
+func (r *RoleRepository) Update(ctx context.Context, role *models.Role, permissionIDs []uint) error {
+	return r.inTransaction("updateRole", func(tx *gorm.DB) error {
+		if err := tx.Save(role).Error; err != nil {
+			return err
+		}
+		if permissionIDs == nil {
+			return nil
+		}
+		return setRolePermissions(tx, role.ID, permissionIDs)
+	})
+}
+
+func setRolePermissions(tx *gorm.DB, roleID int64, permissionIDs []uint) error {
+	if err := tx.Where("role_id = ?", roleID).Delete(&models.RolePermission{}).Error; err != nil {
+		return err
+	}
+	for _, permissionID := range permissionIDs {
+		if err := tx.Create(&models.RolePermission{RoleID: roleID, PermissionID: permissionID}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete deletes the role with its permissions and memberships
+func (r *RoleRepository) Delete(ctx context.Context, roleID int64) error {
+	return r.inTransaction("deleteRole", func(tx *gorm.DB) error {
+		if err := tx.Where("role_id = ?", roleID).Delete(&models.RolePermission{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("role_id = ?", roleID).Delete(&models.UserRole{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("id = ?", roleID).Delete(&models.Role{}).Error
+	})
+}
+
+func (r *RoleRepository) GetByID(ctx context.Context, roleID int64) (*models.Role, error) {
+	var role models.Role
+	err := r.db.Where("id = ?", roleID).First(&role).Error
+	return &role, err
+}
+
+func (r *RoleRepository) GetByName(ctx context.Context, name string) (*models.Role, error) {
+	var role models.Role
+	err := r.db.Where("name = ?", name).First(&role).Error
+	return &role, err
+}
+
+func (r *RoleRepository) GetAll(ctx context.Context) ([]models.Role, error) {
+	var roles []models.Role
+	err := r.db.Order("name").Find(&roles).Error
+	return roles, err
+}
+
+func (r *RoleRepository) GetPermissions(ctx context.Context, roleID int64) ([]models.Permission, error) {
+	var permissions []models.Permission
+	err := r.db.Joins("JOIN pager_roles_permissions ON pager_roles_permissions.permission_id = pager_permissions.id").
+		Where("pager_roles_permissions.role_id = ?", roleID).
+		Order("pager_permissions.name").
+		Find(&permissions).Error
+	return permissions, err
+}
+
+// GetForUser returns the roles of the user
+func (r *RoleRepository) GetForUser(ctx context.Context, userID int64) ([]models.Role, error) {
+	var roles []models.Role
+	err := r.db.Joins("JOIN pager_users_roles ON pager_users_roles.role_id = pager_roles.id").
+		Where("pager_users_roles.user_id = ?", userID).
+		Order("pager_roles.name").
+		Find(&roles).Error
+	return roles, err
+}
+
+// GetPermissionsForUser returns the permissions the roles of the user grant
+func (r *RoleRepository) GetPermissionsForUser(ctx context.Context, userID int64) ([]models.Permission, error) {
+	var permissions []models.Permission
+	err := r.db.Where("id IN (?)", r.db.Table(models.RolePermissionTableName).
+		Select("pager_roles_permissions.permission_id").
+		Joins("JOIN pager_users_roles ON pager_users_roles.role_id = pager_roles_permissions.role_id").
+		Where("pager_users_roles.user_id = ?", userID).
+		QueryExpr()).
+		Find(&permissions).Error
+	return permissions, err
+}
+
+// GetMembers returns the users of the role
+func (r *RoleRepository) GetMembers(ctx context.Context, roleID int64) ([]models.User, error) {
+	var users []models.User
+	err := r.db.Joins("JOIN pager_users_roles ON pager_users_roles.user_id = pager_users.id").
+		Where("pager_users_roles.role_id = ?", roleID).
+		Find(&users).Error
+	return users, err
+}
+
+func (r *RoleRepository) AddUser(ctx context.Context, userID, roleID int64) error {
+	return r.db.Create(&models.UserRole{UserID: userID, RoleID: roleID}).Error
+}
+
+// RemoveUser removes the user from the role, removed reports whether it was a member
+func (r *RoleRepository) RemoveUser(ctx context.Context, userID, roleID int64) (bool, error) {
+	result := r.db.Where("user_id = ? AND role_id = ?", userID, roleID).Delete(&models.UserRole{})
+	return result.RowsAffected > 0, result.Error
+}
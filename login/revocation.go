@@ -0,0 +1,108 @@
+package login
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"golang.org/x/exp/slog"
+)
+
+// RevocationStore revokes access tokens before they expire. A token is revoked
+// when its jti is denied or when the token generation of its user was bumped
+// after it was issued.
+type RevocationStore interface {
+	// Generation returns the token generation of username, 0 until bumped
+	Generation(ctx context.Context, username string) (int64, error)
+	// BumpGeneration revokes every access token issued to username so far
+	BumpGeneration(ctx context.Context, username string) error
+	// Deny revokes the token tokenID until it expires
+	Deny(ctx context.Context, tokenID string, until time.Time) error
+	IsDenied(ctx context.Context, tokenID string) (bool, error)
+}
+
+// revocations is set by InitCache, tokens cannot be revoked without redis
+var revocations RevocationStore
+
+type redisRevocationStore struct {
+	client *redis.Client
+}
+
+func generationKey(username string) string {
+	return "token_gen:" + username
+}
+
+func denylistKey(tokenID string) string {
+	return "token_deny:" + tokenID
+}
+
+func (s *redisRevocationStore) Generation(ctx context.Context, username string) (int64, error) {
+	value, err := s.client.Get(ctx, generationKey(username)).Result()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
+func (s *redisRevocationStore) BumpGeneration(ctx context.Context, username string) error {
+	return s.client.Incr(ctx, generationKey(username)).Err()
+}
+
+func (s *redisRevocationStore) Deny(ctx context.Context, tokenID string, until time.Time) error {
+	ttl := time.Until(until)
+	if ttl <= 0 {
+		return nil
+	}
+	return s.client.Set(ctx, denylistKey(tokenID), 1, ttl).Err()
+}
+
+func (s *redisRevocationStore) IsDenied(ctx context.Context, tokenID string) (bool, error) {
+	count, err := s.client.Exists(ctx, denylistKey(tokenID)).Result()
+	return count > 0, err
+}
+
+// tokenGeneration returns the generation new tokens of username carry
+func tokenGeneration(ctx context.Context, username string) (int64, error) {
+	if revocations == nil {
+		return 0, nil
+	}
+	return revocations.Generation(ctx, username)
+}
+
+// IsRevoked reports whether the access token of claims was revoked by a
+// logout or by a change of the permissions or password of its user
+func IsRevoked(ctx context.Context, claims *Claims) (bool, error) {
+	if revocations == nil {
+		return false, nil
+	}
+	if claims.ID != "" {
+		denied, err := revocations.IsDenied(ctx, claims.ID)
+		if err != nil || denied {
+			return denied, err
+		}
+	}
+	generation, err := revocations.Generation(ctx, claims.Username)
+	if err != nil {
+		return false, err
+	}
+	return claims.Generation < generation, nil
+}
+
+// revokeUserAccessTokens bumps the token generation of username so the access
+// tokens issued before stop being accepted
+func revokeUserAccessTokens(ctx context.Context, username string) error {
+	if revocations == nil {
+		slog.Warn("Redis is not configured, access tokens stay valid until they expire", "username", username)
+		return nil
+	}
+	if err := revocations.BumpGeneration(ctx, username); err != nil {
+		slog.Error("Failed to revoke access tokens", "error", err, "username", username)
+		return err
+	}
+	return nil
+}
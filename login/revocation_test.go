@@ -0,0 +1,120 @@
+package login
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kp/pager/login/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type memoryRevocationStore struct {
+	generations map[string]int64
+	denied      map[string]time.Time
+}
+
+func newMemoryRevocationStore() *memoryRevocationStore {
+	return &memoryRevocationStore{generations: map[string]int64{}, denied: map[string]time.Time{}}
+}
+
+func (s *memoryRevocationStore) Generation(ctx context.Context, username string) (int64, error) {
+	return s.generations[username], nil
+}
+
+func (s *memoryRevocationStore) BumpGeneration(ctx context.Context, username string) error {
+	s.generations[username]++
+	return nil
+}
+
+func (s *memoryRevocationStore) Deny(ctx context.Context, tokenID string, until time.Time) error {
+	s.denied[tokenID] = until
+	return nil
+}
+
+func (s *memoryRevocationStore) IsDenied(ctx context.Context, tokenID string) (bool, error) {
+	until, ok := s.denied[tokenID]
+	return ok && time.Now().Before(until), nil
+}
+
+func TestIsRevoked(t *testing.T) {
+	useKeys(t, JWTConfig{})
+	store := newMemoryRevocationStore()
+	previous := revocations
+	revocations = store
+	t.Cleanup(func() { revocations = previous })
+	ctx := context.Background()
+	user := &models.User{Username: "ada"}
+
+	issue := func() *Claims {
+		generation, err := tokenGeneration(ctx, user.Username)
+		require.NoError(t, err)
+		token, _, err := GenerateToken(user, []string{PagerNotifcationAccess}, generation)
+		require.NoError(t, err)
+		claims, err := ValidateToken(token)
+		require.NoError(t, err)
+		return claims
+	}
+
+	first := issue()
+	revoked, err := IsRevoked(ctx, first)
+	require.NoError(t, err)
+	assert.False(t, revoked)
+
+	// a permission change revokes the tokens issued before it, not the ones after
+	require.NoError(t, store.BumpGeneration(ctx, user.Username))
+	second := issue()
+	revoked, _ = IsRevoked(ctx, first)
+	assert.True(t, revoked)
+	revoked, _ = IsRevoked(ctx, second)
+	assert.False(t, revoked)
+
+	// a logout revokes only its own token
+	third := issue()
+	require.NoError(t, store.Deny(ctx, second.ID, second.ExpiresAt.Time))
+	revoked, _ = IsRevoked(ctx, second)
+	assert.True(t, revoked)
+	revoked, _ = IsRevoked(ctx, third)
+	assert.False(t, revoked)
+}
+
+func TestIsRevoked_WithoutRedis(t *testing.T) {
+	previous := revocations
+	revocations = nil
+	t.Cleanup(func() { revocations = previous })
+
+	revoked, err := IsRevoked(context.Background(), &Claims{Username: "ada"})
+	require.NoError(t, err)
+	assert.False(t, revoked)
+}
+
+func TestGenerateToken_Lifetime(t *testing.T) {
+	useKeys(t, JWTConfig{})
+	require.NoError(t, InitTokenLifetimes(TokenConfig{AccessTokenTTL: "5m"}))
+	t.Cleanup(func() { InitTokenLifetimes(TokenConfig{}) })
+
+	token, expiry, err := GenerateToken(&models.User{Username: "ada"}, nil, 3)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(5*time.Minute), expiry, time.Second)
+	claims, err := ValidateToken(token)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), claims.Generation)
+	assert.NotEmpty(t, claims.ID)
+}
+
+func TestInitTokenLifetimes_Invalid(t *testing.T) {
+	assert.Error(t, InitTokenLifetimes(TokenConfig{AccessTokenTTL: "soon"}))
+	assert.Error(t, InitTokenLifetimes(TokenConfig{AccessTokenTTL: "1h", RefreshTokenTTL: "30m"}))
+	assert.Equal(t, defaultAccessTokenTTL, accessTokenTTL)
+}
+
+func TestNewRefreshToken(t *testing.T) {
+	token, hash, err := newRefreshToken()
+	require.NoError(t, err)
+	other, _, err := newRefreshToken()
+	require.NoError(t, err)
+	assert.NotEqual(t, token, other)
+	assert.Equal(t, hash, hashRefreshToken(token))
+	assert.Len(t, hash, 64)
+}
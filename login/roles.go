@@ -0,0 +1,301 @@
+package login
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	"github.com/kp/pager/login/models"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrInvalidRole is returned for a role request which cannot be applied
+var ErrInvalidRole = errors.New("invalid role")
+
+type RoleService struct {
+	roleRepo       *RoleRepository
+	permissionRepo *PermissionRepository
+	userRepo       *UserRepository
+}
+
+func NewRoleService(roleRepo *RoleRepository, permissionRepo *PermissionRepository, userRepo *UserRepository) *RoleService {
+	return &RoleService{
+		roleRepo:       roleRepo,
+		permissionRepo: permissionRepo,
+		userRepo:       userRepo,
+	}
+}
+
+func (s *RoleService) Create(ctx context.Context, req RoleRequest) (*RoleWithPermissions, error) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return nil, fmt.Errorf("%w: name is required", ErrInvalidRole)
+	}
+	permissionIDs, err := s.permissionIDs(ctx, req.Permissions)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.roleRepo.GetByName(ctx, name); err == nil {
+		return nil, fmt.Errorf("%w: role %q already exists", ErrInvalidRole, name)
+	}
+	description := ""
+	if req.Description != nil {
+		description = *req.Description
+	}
+	role, err := s.roleRepo.Create(ctx, name, description, permissionIDs)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"role": name,
+		}).WithError(err).Error("Failed to create role")
+		return nil, err
+	}
+	return s.withPermissions(ctx, *role)
+}
+
+func (s *RoleService) List(ctx context.Context) ([]RoleWithPermissions, error) {
+	roles, err := s.roleRepo.GetAll(ctx)
+	if err != nil {
+		log.WithError(err).Error("Failed to get roles")
+		return nil, err
+	}
+	result := make([]RoleWithPermissions, 0, len(roles))
+	for _, role := range roles {
+		withPermissions, err := s.withPermissions(ctx, role)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, *withPermissions)
+	}
+	return result, nil
+}
+
+func (s *RoleService) Get(ctx context.Context, roleID int64) (*RoleWithPermissions, error) {
+	role, err := s.roleRepo.GetByID(ctx, roleID)
+	if err != nil {
+		return nil, err
+	}
+	return s.withPermissions(ctx, *role)
+}
+
+// Update renames the role, changes its description or replaces its
+// permissions; members get the new permissions with their next token
+func (s *RoleService) Update(ctx context.Context, roleID int64, req RoleRequest) (*RoleWithPermissions, error) {
+	role, err := s.roleRepo.GetByID(ctx, roleID)
+	if err != nil {
+		return nil, err
+	}
+	if name := strings.TrimSpace(req.Name); name != "" && name != role.Name {
+		if _, err := s.roleRepo.GetByName(ctx, name); err == nil {
+			return nil, fmt.Errorf("%w: role %q already exists", ErrInvalidRole, name)
+		}
+		role.Name = name
+	}
+	if req.Description != nil {
+		role.Description = *req.Description
+	}
+	var permissionIDs []uint
+	if req.Permissions != nil {
+		if permissionIDs, err = s.permissionIDs(ctx, req.Permissions); err != nil {
+			return nil, err
+		}
+		// an empty list removes every permission, nil keeps them
+		if permissionIDs == nil {
+			permissionIDs = []uint{}
+		}
+	}
+	if err := s.roleRepo.Update(ctx, role, permissionIDs); err != nil {
+		log.WithFields(log.Fields{
+			"roleId": roleID,
+		}).WithError(err).Error("Failed to update role")
+		return nil, err
+	}
+	if req.Permissions != nil {
+		if err := s.revokeMemberTokens(ctx, roleID); err != nil {
+			return nil, err
+		}
+	}
+	return s.withPermissions(ctx, *role)
+}
+
+// Delete deletes the role, its members lose its permissions
+func (s *RoleService) Delete(ctx context.Context, roleID int64) error {
+	if _, err := s.roleRepo.GetByID(ctx, roleID); err != nil {
+		return err
+	}
+	members, err := s.roleRepo.GetMembers(ctx, roleID)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"roleId": roleID,
+		}).WithError(err).Error("Failed to get role members")
+		return err
+	}
+	if err := s.roleRepo.Delete(ctx, roleID); err != nil {
+		log.WithFields(log.Fields{
+			"roleId": roleID,
+		}).WithError(err).Error("Failed to delete role")
+		return err
+	}
+	for _, member := range members {
+		if err := revokeUserAccessTokens(ctx, member.Username); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *RoleService) Members(ctx context.Context, roleID int64) ([]models.User, error) {
+	if _, err := s.roleRepo.GetByID(ctx, roleID); err != nil {
+		return nil, err
+	}
+	return s.roleRepo.GetMembers(ctx, roleID)
+}
+
+func (s *RoleService) AssignUser(ctx context.Context, roleID, userID int64) error {
+	user, role, err := s.userAndRole(ctx, roleID, userID)
+	if err != nil {
+		return err
+	}
+	if err := s.roleRepo.AddUser(ctx, user.ID, role.ID); err != nil {
+		log.WithFields(log.Fields{
+			"roleId": roleID,
+			"userId": userID,
+		}).WithError(err).Error("Failed to assign role")
+		return err
+	}
+	return revokeUserAccessTokens(ctx, user.Username)
+}
+
+func (s *RoleService) UnassignUser(ctx context.Context, roleID, userID int64) error {
+	user, role, err := s.userAndRole(ctx, roleID, userID)
+	if err != nil {
+		return err
+	}
+	removed, err := s.roleRepo.RemoveUser(ctx, user.ID, role.ID)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"roleId": roleID,
+			"userId": userID,
+		}).WithError(err).Error("Failed to unassign role")
+		return err
+	}
+	if !removed {
+		return gorm.ErrRecordNotFound
+	}
+	return revokeUserAccessTokens(ctx, user.Username)
+}
+
+func (s *RoleService) userAndRole(ctx context.Context, roleID, userID int64) (*models.User, *models.Role, error) {
+	role, err := s.roleRepo.GetByID(ctx, roleID)
+	if err != nil {
+		return nil, nil, err
+	}
+	user, err := s.userRepo.GetByID(ctx, fmt.Sprint(userID))
+	if err != nil {
+		return nil, nil, err
+	}
+	return user, role, nil
+}
+
+func (s *RoleService) revokeMemberTokens(ctx context.Context, roleID int64) error {
+	members, err := s.roleRepo.GetMembers(ctx, roleID)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"roleId": roleID,
+		}).WithError(err).Error("Failed to get role members")
+		return err
+	}
+	for _, member := range members {
+		if err := revokeUserAccessTokens(ctx, member.Username); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *RoleService) permissionIDs(ctx context.Context, names []string) ([]uint, error) {
+	var ids []uint
+	seen := map[uint]bool{}
+	for _, name := range names {
+		permission, err := s.permissionRepo.GetByName(ctx, name)
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, fmt.Errorf("%w: unknown permission %q", ErrInvalidRole, name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !seen[permission.ID] {
+			seen[permission.ID] = true
+			ids = append(ids, permission.ID)
+		}
+	}
+	return ids, nil
+}
+
+func (s *RoleService) withPermissions(ctx context.Context, role models.Role) (*RoleWithPermissions, error) {
+	permissions, err := s.roleRepo.GetPermissions(ctx, role.ID)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"roleId": role.ID,
+		}).WithError(err).Error("Failed to get role permissions")
+		return nil, err
+	}
+	return &RoleWithPermissions{Role: role, Permissions: permissions}, nil
+}
+
+// mergePermissions returns the distinct permissions of the lists sorted by name
+func mergePermissions(lists ...[]models.Permission) []models.Permission {
+	var merged []models.Permission
+	seen := map[uint]bool{}
+	for _, list := range lists {
+		for _, permission := range list {
+			if !seen[permission.ID] {
+				seen[permission.ID] = true
+				merged = append(merged, permission)
+			}
+		}
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].Name < merged[j].Name })
+	return merged
+}
+
+// SeedDefaultRoles creates the built-in roles of DefaultRoles and their
+// permissions when missing, roles which exist are left as they are
+func SeedDefaultRoles(ctx context.Context, db *gorm.DB) error {
+	permissionRepo := NewPermissionRepository(db)
+	roleRepo := NewRoleRepository(db)
+	for _, name := range defaultRoleNames() {
+		if _, err := roleRepo.GetByName(ctx, name); err == nil {
+			continue
+		} else if !gorm.IsRecordNotFoundError(err) {
+			return err
+		}
+		var permissionIDs []uint
+		for _, permissionName := range DefaultRoles[name] {
+			permission, err := permissionRepo.GetByName(ctx, permissionName)
+			if gorm.IsRecordNotFoundError(err) {
+				permission, err = permissionRepo.Create(ctx, permissionName, "")
+			}
+			if err != nil {
+				return fmt.Errorf("failed to seed permission %s: %w", permissionName, err)
+			}
+			permissionIDs = append(permissionIDs, permission.ID)
+		}
+		if _, err := roleRepo.Create(ctx, name, "Default role of "+name+" users", permissionIDs); err != nil {
+			return fmt.Errorf("failed to seed role %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func defaultRoleNames() []string {
+	names := make([]string, 0, len(DefaultRoles))
+	for name := range DefaultRoles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
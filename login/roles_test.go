@@ -0,0 +1,28 @@
+package login
+
+import (
+	"testing"
+
+	"github.com/kp/pager/login/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMergePermissions(t *testing.T) {
+	admin := models.Permission{ID: 1, Name: PagerAdminAccess}
+	notification := models.Permission{ID: 2, Name: PagerNotifcationAccess}
+	template := models.Permission{ID: 3, Name: PagerTemplateAccess}
+
+	merged := mergePermissions(
+		[]models.Permission{template, notification},
+		[]models.Permission{notification, admin},
+	)
+	assert.Equal(t, []models.Permission{admin, template, notification}, merged)
+	assert.Empty(t, mergePermissions(nil, nil))
+}
+
+func TestDefaultRoles(t *testing.T) {
+	assert.Equal(t, []string{UserTypeAdmin, UserTypeMarketing, UserTypeNormal}, defaultRoleNames())
+	for name, permissions := range DefaultRoles {
+		assert.NotEmpty(t, permissions, name)
+	}
+}
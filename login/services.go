@@ -4,7 +4,9 @@ import (
 	"context"
 	"fmt"
 	"strconv"
+	"time"
 
+	"github.com/jinzhu/gorm"
 	"github.com/kp/pager/common"
 	"github.com/kp/pager/login/models"
 
@@ -12,26 +14,38 @@ import (
 )
 
 type AuthService struct {
-	userRepo       *UserRepository
-	permissionRepo *PermissionRepository
-	userPermRepo   *UserPermissionRepository
+	userRepo         *UserRepository
+	permissionRepo   *PermissionRepository
+	userPermRepo     *UserPermissionRepository
+	refreshTokenRepo *RefreshTokenRepository
+	roleRepo         *RoleRepository
 }
 
 func NewAuthService(
 	userRepo *UserRepository,
 	permissionRepo *PermissionRepository,
 	userPermRepo *UserPermissionRepository,
+	refreshTokenRepo *RefreshTokenRepository,
+	roleRepo *RoleRepository,
 ) *AuthService {
 	return &AuthService{
-		userRepo:       userRepo,
-		permissionRepo: permissionRepo,
-		userPermRepo:   userPermRepo,
+		userRepo:         userRepo,
+		permissionRepo:   permissionRepo,
+		userPermRepo:     userPermRepo,
+		refreshTokenRepo: refreshTokenRepo,
+		roleRepo:         roleRepo,
 	}
 }
 
 func (s *AuthService) RegisterUser(ctx context.Context, username, password, userType, name, email string) (*models.User, []models.Permission, error) {
-	// Business logic and validation here
-	user, err := s.userRepo.Create(ctx, username, password, userType, name, email)
+	if err := ValidatePassword(username, password); err != nil {
+		return nil, nil, err
+	}
+	hash, err := HashPassword(password)
+	if err != nil {
+		return nil, nil, err
+	}
+	user, err := s.userRepo.Create(ctx, username, hash, userType, name, email)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"username": username,
@@ -42,39 +56,31 @@ func (s *AuthService) RegisterUser(ctx context.Context, username, password, user
 		return nil, nil, err
 	}
 
-	// Assign default permissions based on user type
-	var permissions []string
-	switch userType {
-	case UserTypeAdmin:
-		permissions = DefaultAdminPermissions
-	case UserTypeMarketing:
-		permissions = DefaultMarketingPermissions
-	default:
-		permissions = DefaultUserPermissions
+	// New users join the role named after their user type, the user role
+	// for types without one
+	role, err := s.roleRepo.GetByName(ctx, userType)
+	if gorm.IsRecordNotFoundError(err) {
+		role, err = s.roleRepo.GetByName(ctx, UserTypeNormal)
 	}
-
-	// Add all permissions
-	var assignedPerms []models.Permission
-	for _, perm := range permissions {
-		if err := s.AddPermission(ctx, strconv.FormatInt(user.ID, 10), perm); err != nil {
-			log.WithFields(log.Fields{
-				"permission": perm,
-				"userId":     user.ID,
-			}).WithError(err).Error("Failed to add permission")
-			return nil, nil, err
-		}
-		// Get the permission details to return
-		p, err := s.permissionRepo.GetByName(ctx, perm)
-		if err != nil {
-			log.WithFields(log.Fields{
-				"permission": perm,
-			}).WithError(err).Error("Failed to get permission details")
-			return nil, nil, err
-		}
-		assignedPerms = append(assignedPerms, *p)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"userType": userType,
+		}).WithError(err).Error("Failed to get the role of the user type, run migrate to seed default roles")
+		return nil, nil, err
+	}
+	if err := s.roleRepo.AddUser(ctx, user.ID, role.ID); err != nil {
+		log.WithFields(log.Fields{
+			"role":   role.Name,
+			"userId": user.ID,
+		}).WithError(err).Error("Failed to assign role")
+		return nil, nil, err
 	}
 
-	return user, assignedPerms, nil
+	permissions, err := s.GetUserPermissions(ctx, strconv.FormatInt(user.ID, 10))
+	if err != nil {
+		return nil, nil, err
+	}
+	return user, permissions, nil
 }
 
 func (s *AuthService) Login(ctx context.Context, username, password string) (*models.User, []models.Permission, error) {
@@ -85,11 +91,25 @@ func (s *AuthService) Login(ctx context.Context, username, password string) (*mo
 		}).WithError(err).Error("Failed to get user")
 		return nil, nil, err
 	}
-	if user.Password != password {
+	rehash, err := VerifyPassword(user.Password, password)
+	if err != nil {
 		log.WithFields(log.Fields{
 			"username": username,
 		}).Error("Invalid password attempt")
-		return nil, nil, fmt.Errorf("invalid password")
+		return nil, nil, err
+	}
+	if rehash {
+		// upgrade legacy base64 or plaintext rows and outdated costs, the login
+		// succeeds even when the new hash cannot be stored
+		if hash, err := HashPassword(password); err != nil {
+			log.WithFields(log.Fields{
+				"username": username,
+			}).WithError(err).Error("Failed to rehash password")
+		} else if err := s.userRepo.UpdatePassword(ctx, username, hash); err != nil {
+			log.WithFields(log.Fields{
+				"username": username,
+			}).WithError(err).Error("Failed to store rehashed password")
+		}
 	}
 
 	perms, err := s.GetUserPermissions(ctx, strconv.FormatInt(user.ID, 10))
@@ -104,7 +124,16 @@ func (s *AuthService) Login(ctx context.Context, username, password string) (*mo
 	return user, perms, nil
 }
 
+// AddPermission grants a permission and revokes the access tokens of the user,
+// the next refresh issues a token with the permission
 func (s *AuthService) AddPermission(ctx context.Context, userID string, permissionName string) error {
+	if err := s.addPermission(ctx, userID, permissionName); err != nil {
+		return err
+	}
+	return s.revokeAccessTokens(ctx, userID)
+}
+
+func (s *AuthService) addPermission(ctx context.Context, userID string, permissionName string) error {
 	perm, err := s.permissionRepo.GetByName(ctx, permissionName)
 	if err != nil {
 		log.WithFields(log.Fields{
@@ -124,6 +153,8 @@ func (s *AuthService) AddPermission(ctx context.Context, userID string, permissi
 	return nil
 }
 
+// GetUserPermissions returns the effective permissions of the user, the ones
+// of its roles and the ones granted to it directly
 func (s *AuthService) GetUserPermissions(ctx context.Context, userID string) ([]models.Permission, error) {
 	perms, err := s.userPermRepo.GetForUser(ctx, userID)
 	if err != nil {
@@ -132,7 +163,18 @@ func (s *AuthService) GetUserPermissions(ctx context.Context, userID string) ([]
 		}).WithError(err).Error("Failed to get user permissions")
 		return nil, err
 	}
-	return perms, nil
+	id, err := strconv.ParseInt(userID, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid user id %q", userID)
+	}
+	rolePerms, err := s.roleRepo.GetPermissionsForUser(ctx, id)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"userId": userID,
+		}).WithError(err).Error("Failed to get role permissions")
+		return nil, err
+	}
+	return mergePermissions(rolePerms, perms), nil
 }
 
 func (s *AuthService) GetAllPermissions(ctx context.Context) ([]models.Permission, error) {
@@ -154,7 +196,7 @@ func (s *AuthService) AddUserPermission(ctx context.Context, userID int64, permi
 		}).WithError(err).Error("Failed to add user permission")
 		return err
 	}
-	return nil
+	return s.revokeAccessTokens(ctx, strconv.FormatInt(userID, 10))
 }
 
 func (s *AuthService) RemoveUserPermission(ctx context.Context, userID int64, permissionID int64, createdBy string) error {
@@ -167,19 +209,39 @@ func (s *AuthService) RemoveUserPermission(ctx context.Context, userID int64, pe
 		}).WithError(err).Error("Failed to remove user permission")
 		return err
 	}
-	return nil
+	return s.revokeAccessTokens(ctx, strconv.FormatInt(userID, 10))
 }
 
+// ChangePassword replaces the password and signs the user out everywhere
 func (s *AuthService) ChangePassword(ctx context.Context, username string, newPassword string) error {
-	encryptedPass := common.Encryptbase64(newPassword)
-	err := s.userRepo.UpdatePassword(ctx, username, encryptedPass)
+	user, err := s.userRepo.GetByUsername(ctx, username)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"username": username,
+		}).WithError(err).Error("Failed to get user")
+		return err
+	}
+	if err := ValidatePassword(username, newPassword); err != nil {
+		return err
+	}
+	hash, err := HashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+	err = s.userRepo.UpdatePassword(ctx, username, hash)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"username": username,
 		}).WithError(err).Error("Failed to update password")
 		return err
 	}
-	return nil
+	if err := s.refreshTokenRepo.RevokeForUser(ctx, user.ID); err != nil {
+		log.WithFields(log.Fields{
+			"username": username,
+		}).WithError(err).Error("Failed to revoke refresh tokens")
+		return err
+	}
+	return s.revokeAccessTokens(ctx, strconv.FormatInt(user.ID, 10))
 }
 
 func (s *AuthService) GetAllUsersWithPermissions(ctx context.Context) ([]UserWithPermissions, error) {
@@ -191,16 +253,6 @@ func (s *AuthService) GetAllUsersWithPermissions(ctx context.Context) ([]UserWit
 
 	var result []UserWithPermissions
 	for _, user := range users {
-		// Decrypt password before returning
-		decryptedPass, err := common.DecryptBase64(user.Password)
-		if err != nil {
-			log.WithFields(log.Fields{
-				"userId": user.ID,
-			}).WithError(err).Error("Failed to decrypt user password")
-			return nil, err
-		}
-		user.Password = decryptedPass
-
 		perms, err := s.GetUserPermissions(ctx, strconv.FormatInt(user.ID, 10))
 		if err != nil {
 			log.WithFields(log.Fields{
@@ -216,3 +268,144 @@ func (s *AuthService) GetAllUsersWithPermissions(ctx context.Context) ([]UserWit
 
 	return result, nil
 }
+
+// revokeAccessTokens revokes the access tokens issued to the user so far
+func (s *AuthService) revokeAccessTokens(ctx context.Context, userID string) error {
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"userId": userID,
+		}).WithError(err).Error("Failed to get user")
+		return err
+	}
+	return revokeUserAccessTokens(ctx, user.Username)
+}
+
+// IssueTokens issues an access token and the refresh token of a new session
+func (s *AuthService) IssueTokens(ctx context.Context, user *models.User, perms []models.Permission) (*TokenPair, error) {
+	return s.issueTokens(ctx, user, perms, common.GenerateUUID())
+}
+
+func (s *AuthService) issueTokens(ctx context.Context, user *models.User, perms []models.Permission, familyID string) (*TokenPair, error) {
+	generation, err := tokenGeneration(ctx, user.Username)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"username": user.Username,
+		}).WithError(err).Error("Failed to get token generation")
+		return nil, err
+	}
+	accessToken, accessExpiry, err := GenerateToken(user, permissionNames(perms), generation)
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, hash, err := newRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+	refreshExpiry := time.Now().Add(refreshTokenTTL)
+	if _, err := s.refreshTokenRepo.Create(ctx, user.ID, familyID, hash, refreshExpiry); err != nil {
+		log.WithFields(log.Fields{
+			"username": user.Username,
+		}).WithError(err).Error("Failed to store refresh token")
+		return nil, err
+	}
+	return &TokenPair{
+		AccessToken:      accessToken,
+		AccessExpiresAt:  accessExpiry,
+		RefreshToken:     refreshToken,
+		RefreshExpiresAt: refreshExpiry,
+	}, nil
+}
+
+// Refresh replaces refreshToken by a new token of its session and issues an
+// access token with the current permissions. Presenting a replaced token again
+// revokes the whole session, as one of the two holders stole it.
+func (s *AuthService) Refresh(ctx context.Context, refreshToken string) (*models.User, []models.Permission, *TokenPair, error) {
+	stored, err := s.refreshTokenRepo.GetByHash(ctx, hashRefreshToken(refreshToken))
+	if gorm.IsRecordNotFoundError(err) {
+		return nil, nil, nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		log.WithError(err).Error("Failed to get refresh token")
+		return nil, nil, nil, err
+	}
+	if time.Now().After(stored.ExpiresAt) {
+		return nil, nil, nil, ErrInvalidRefreshToken
+	}
+	revoked := false
+	if stored.RevokedAt == nil {
+		if revoked, err = s.refreshTokenRepo.Revoke(ctx, stored.ID); err != nil {
+			log.WithError(err).Error("Failed to revoke refresh token")
+			return nil, nil, nil, err
+		}
+	}
+	if !revoked {
+		log.WithFields(log.Fields{
+			"userId":   stored.UserID,
+			"familyId": stored.FamilyID,
+		}).Warn("Refresh token reused, revoking its session")
+		if err := s.refreshTokenRepo.RevokeFamily(ctx, stored.FamilyID); err != nil {
+			log.WithError(err).Error("Failed to revoke refresh token family")
+		}
+		return nil, nil, nil, ErrInvalidRefreshToken
+	}
+
+	userID := strconv.FormatInt(stored.UserID, 10)
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"userId": userID,
+		}).WithError(err).Error("Failed to get user")
+		return nil, nil, nil, err
+	}
+	perms, err := s.GetUserPermissions(ctx, userID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	pair, err := s.issueTokens(ctx, user, perms, stored.FamilyID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return user, perms, pair, nil
+}
+
+// Logout revokes the access token of claims, when given, and the session of
+// refreshToken, when given
+func (s *AuthService) Logout(ctx context.Context, claims *Claims, refreshToken string) error {
+	if claims != nil && claims.ID != "" && claims.ExpiresAt != nil {
+		if revocations == nil {
+			log.WithFields(log.Fields{
+				"username": claims.Username,
+			}).Warn("Redis is not configured, the access token stays valid until it expires")
+		} else if err := revocations.Deny(ctx, claims.ID, claims.ExpiresAt.Time); err != nil {
+			log.WithFields(log.Fields{
+				"username": claims.Username,
+			}).WithError(err).Error("Failed to revoke access token")
+			return err
+		}
+	}
+	if refreshToken == "" {
+		return nil
+	}
+	stored, err := s.refreshTokenRepo.GetByHash(ctx, hashRefreshToken(refreshToken))
+	if gorm.IsRecordNotFoundError(err) {
+		return nil
+	}
+	if err != nil {
+		log.WithError(err).Error("Failed to get refresh token")
+		return err
+	}
+	if err := s.refreshTokenRepo.RevokeFamily(ctx, stored.FamilyID); err != nil {
+		log.WithError(err).Error("Failed to revoke refresh token family")
+		return err
+	}
+	return nil
+}
+
+func permissionNames(perms []models.Permission) []string {
+	names := make([]string, len(perms))
+	for i, p := range perms {
+		names[i] = p.Name
+	}
+	return names
+}
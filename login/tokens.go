@@ -0,0 +1,72 @@
+package login
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	defaultAccessTokenTTL  = 15 * time.Minute
+	defaultRefreshTokenTTL = 30 * 24 * time.Hour
+)
+
+// ErrInvalidRefreshToken is returned for an unknown, expired or revoked refresh token
+var ErrInvalidRefreshToken = errors.New("invalid refresh token")
+
+// TokenConfig configures the lifetimes of access and refresh tokens,
+// 15m and 720h by default
+type TokenConfig struct {
+	AccessTokenTTL  string `json:"ACCESS_TOKEN_TTL"`
+	RefreshTokenTTL string `json:"REFRESH_TOKEN_TTL"`
+}
+
+var (
+	accessTokenTTL  = defaultAccessTokenTTL
+	refreshTokenTTL = defaultRefreshTokenTTL
+)
+
+// InitTokenLifetimes sets the lifetimes of the tokens issued at login
+func InitTokenLifetimes(config TokenConfig) error {
+	access, refresh := defaultAccessTokenTTL, defaultRefreshTokenTTL
+	var err error
+	if config.AccessTokenTTL != "" {
+		if access, err = time.ParseDuration(config.AccessTokenTTL); err != nil || access <= 0 {
+			return fmt.Errorf("invalid ACCESS_TOKEN_TTL %q", config.AccessTokenTTL)
+		}
+	}
+	if config.RefreshTokenTTL != "" {
+		if refresh, err = time.ParseDuration(config.RefreshTokenTTL); err != nil || refresh <= access {
+			return fmt.Errorf("invalid REFRESH_TOKEN_TTL %q, it must be longer than ACCESS_TOKEN_TTL", config.RefreshTokenTTL)
+		}
+	}
+	accessTokenTTL, refreshTokenTTL = access, refresh
+	return nil
+}
+
+// TokenPair is an access token with the refresh token renewing it
+type TokenPair struct {
+	AccessToken      string
+	AccessExpiresAt  time.Time
+	RefreshToken     string
+	RefreshExpiresAt time.Time
+}
+
+// newRefreshToken returns a random refresh token and the hash stored for it
+func newRefreshToken() (token, hash string, err error) {
+	value := make([]byte, 32)
+	if _, err := rand.Read(value); err != nil {
+		return "", "", fmt.Errorf("failed to generate a refresh token: %w", err)
+	}
+	token = base64.RawURLEncoding.EncodeToString(value)
+	return token, hashRefreshToken(token), nil
+}
+
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -1,6 +1,8 @@
 package login
 
 import (
+	"time"
+
 	"github.com/kp/pager/common"
 	"github.com/kp/pager/login/models"
 )
@@ -47,6 +49,45 @@ type ChangePasswordRequest struct {
 	NewPassword string `json:"new_password"`
 }
 
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type CreateAPIKeyRequest struct {
+	Name        string     `json:"name"`
+	Permissions []string   `json:"permissions"`
+	AllowedIPs  []string   `json:"allowed_ips"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+}
+
+// APIKeyInfo describes an API key without its secret
+type APIKeyInfo struct {
+	models.APIKey
+	Permissions []string `json:"permissions"`
+	AllowedIPs  []string `json:"allowed_ips"`
+}
+
+func newAPIKeyInfo(key models.APIKey) APIKeyInfo {
+	return APIKeyInfo{APIKey: key, Permissions: key.PermissionList(), AllowedIPs: key.AllowedIPList()}
+}
+
+// RoleRequest creates or updates a role, on update empty fields are kept and
+// a permissions list replaces the permissions of the role
+type RoleRequest struct {
+	Name        string   `json:"name"`
+	Description *string  `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+type RoleWithPermissions struct {
+	models.Role
+	Permissions []models.Permission `json:"permissions"`
+}
+
+type AssignRoleRequest struct {
+	UserID int64 `json:"user_id"`
+}
+
 type AddPermissionRequest struct {
 	UserID       int64  `json:"user_id"`
 	PermissionID int64  `json:"permission_id"`
@@ -0,0 +1,111 @@
+// Package metrics holds the Prometheus collectors shared by the api server
+// and the worker. Both expose them on /metrics.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "pager"
+
+// Outcome label values
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+var (
+	// HTTPRequests counts api requests by route template, so path
+	// parameters do not create new series
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests handled by the api server.",
+	}, []string{"method", "route", "status"})
+
+	HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of HTTP requests handled by the api server.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	NotificationTriggers = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "notification_triggers_total",
+		Help:      "Notification trigger requests by outcome.",
+	}, []string{"template_id", "scheduled", "outcome"})
+
+	NotificationTriggerDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "notification_trigger_duration_seconds",
+		Help:      "Time to create a notification session and publish its batches.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"template_id", "scheduled", "outcome"})
+
+	AudiencesAccepted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "notification_audiences_accepted_total",
+		Help:      "Recipients accepted by successful trigger requests.",
+	}, []string{"template_id", "scheduled"})
+
+	BatchesPublished = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "batches_published_total",
+		Help:      "Batches published to the queue.",
+	}, []string{"topic"})
+
+	BatchPublishFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "batch_publish_failures_total",
+		Help:      "Batches which could not be published to the queue.",
+	}, []string{"topic"})
+
+	MessagesConsumed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_consumed_total",
+		Help:      "Queue messages handled by the worker.",
+	}, []string{"topic", "outcome"})
+
+	RecipientSends = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "recipient_sends_total",
+		Help:      "Per-recipient send attempts by template, channel, provider and outcome.",
+	}, []string{"template_id", "channel", "provider", "outcome"})
+
+	RecipientSendDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "recipient_send_duration_seconds",
+		Help:      "Time spent sending to one recipient through its provider.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"template_id", "channel", "provider", "outcome"})
+
+	ConsumerLag = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "consumer_lag",
+		Help:      "Messages waiting to be consumed per topic and partition.",
+	}, []string{"topic", "partition"})
+)
+
+// Handler serves the registered metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// Outcome maps an error to its outcome label value
+func Outcome(err error) string {
+	if err != nil {
+		return OutcomeFailure
+	}
+	return OutcomeSuccess
+}
+
+// TemplateLabel formats a template id as a label value
+func TemplateLabel(templateID int64) string {
+	return strconv.FormatInt(templateID, 10)
+}
@@ -0,0 +1,28 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutcome(t *testing.T) {
+	assert.Equal(t, OutcomeSuccess, Outcome(nil))
+	assert.Equal(t, OutcomeFailure, Outcome(errors.New("smtp timeout")))
+}
+
+func TestHandler(t *testing.T) {
+	RecipientSends.WithLabelValues("7", "email", "smtp", OutcomeSuccess).Inc()
+	assert.Equal(t, float64(1), testutil.ToFloat64(RecipientSends.WithLabelValues("7", "email", "smtp", OutcomeSuccess)))
+
+	recorder := httptest.NewRecorder()
+	Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.True(t, strings.Contains(recorder.Body.String(),
+		`pager_recipient_sends_total{channel="email",outcome="success",provider="smtp",template_id="7"} 1`))
+}
@@ -1,7 +1,17 @@
 package notification
 
 const (
-	NotifcationSessionStatusCreated   = "created"
-	NotifcationSessionStatusFailed    = "failed"
-	NotifcationSessionStatusDelivered = "delivered"
+	NotifcationSessionStatusScheduled       = "scheduled"
+	NotifcationSessionStatusUploading       = "uploading"
+	NotifcationSessionStatusCancelled       = "cancelled"
+	NotifcationSessionStatusCreated         = "created"
+	NotifcationSessionStatusProcessing      = "processing"
+	NotifcationSessionStatusFailed          = "failed"
+	NotifcationSessionStatusDelivered       = "delivered"
+	NotifcationSessionStatusPartiallyFailed = "partially_failed"
+)
+
+const (
+	defaultRecipientsPageSize = 50
+	maxRecipientsPageSize     = 500
 )
@@ -1,12 +1,24 @@
 package notification
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"log/slog"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"github.com/kp/pager/common"
+	"github.com/kp/pager/communicator"
 	"github.com/kp/pager/databases/kafka"
 	"github.com/kp/pager/databases/sql"
+	"github.com/kp/pager/metrics"
+	models "github.com/kp/pager/notification/models"
+	"github.com/kp/pager/templates"
 )
 
 type NotificationController struct {
@@ -28,13 +40,236 @@ func (c *NotificationController) SendNotification(ctx *gin.Context) {
 		return
 	}
 
+	if notificationRequest.Channel != "" && !common.IsChannel(notificationRequest.Channel) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "channel must be one of email, sms, push or in_app"})
+		return
+	}
+
+	if notificationRequest.LocalTime != "" {
+		if _, err := communicator.ParseClock(notificationRequest.LocalTime); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "local_time must be a time of day like 09:00"})
+			return
+		}
+	}
+
+	if notificationRequest.SendAt != nil && !notificationRequest.SendAt.After(time.Now()) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "send_at must be in the future"})
+		return
+	}
+
 	notificationRequest.UserName = ctx.GetString("username")
+
+	// a retried request with the same Idempotency-Key gets the first response
+	var claim *models.IdempotencyKey
+	idempotencyService := NewIdempotencyService(sql.PagerOrm)
+	if key := strings.TrimSpace(ctx.GetHeader(IdempotencyKeyHeader)); key != "" {
+		if len(key) > maxIdempotencyKeyLength {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key must be at most 255 characters"})
+			return
+		}
+		fingerprint, err := requestFingerprint(notificationRequest)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var replay *IdempotentResponse
+		replay, claim, err = idempotencyService.Begin(ctx.Request.Context(), notificationRequest.UserName, key, fingerprint)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, ErrIdempotencyKeyReused) {
+				status = http.StatusUnprocessableEntity
+			} else if errors.Is(err, ErrIdempotencyKeyInProgress) {
+				status = http.StatusConflict
+			} else {
+				slog.Error("sendNotificationView:unableToClaimIdempotencyKey", slog.Any("error", err))
+			}
+			ctx.JSON(status, gin.H{
+				"error":  err.Error(),
+				"status": false,
+				"msg":    err.Error(),
+			})
+			return
+		}
+		if replay != nil {
+			slog.Info("sendNotificationView:idempotentReplay",
+				slog.String("request_id", replay.RequestID))
+			ctx.Header("Idempotent-Replayed", "true")
+			ctx.Data(replay.Status, "application/json; charset=utf-8", replay.Body)
+			return
+		}
+	}
+
 	notificationSessionService := NewNotificationSessionService(sql.PagerOrm)
-	notificationService := NewNotificationService(ctx, notificationRequest, notificationSessionService, c.kafkaProducer)
-	notificationData, err := notificationService.SendNotification(ctx)
+	templateService := templates.NewTemplateService(sql.PagerOrm)
+	notificationService := NewNotificationService(ctx, notificationRequest, notificationSessionService, templateService, c.kafkaProducer)
+	start := time.Now()
+	// keep the span of the tracing middleware, publishing must not stop when the client disconnects
+	notificationData, err := notificationService.SendNotification(context.WithoutCancel(ctx.Request.Context()))
+	recordTrigger(notificationRequest, err, time.Since(start))
+
+	status := http.StatusOK
+	response := gin.H{
+		"error":  nil,
+		"status": true,
+		"msg":    "Notification sent successfully",
+		"data":   notificationData,
+	}
 	if err != nil {
 		slog.Error("sendNotificationView:unableToSendNotification",
 			slog.Any("error", err))
+		status = http.StatusInternalServerError
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			status = http.StatusNotFound
+		} else if errors.Is(err, templates.ErrUnsupportedChannel) {
+			status = http.StatusBadRequest
+		}
+		response = gin.H{
+			"error":  err.Error(),
+			"status": false,
+			"msg":    err.Error(),
+			"data":   notificationData,
+		}
+	}
+	if claim != nil {
+		completeIdempotencyKey(context.WithoutCancel(ctx.Request.Context()), idempotencyService, claim, notificationData, status, response)
+	}
+	ctx.JSON(status, response)
+}
+
+// completeIdempotencyKey stores the response of a request which created a
+// session for replays, otherwise nothing was sent and the key is released
+// so the client can retry
+func completeIdempotencyKey(ctx context.Context, idempotencyService IdempotencyService, claim *models.IdempotencyKey, notificationData *Notification, status int, response gin.H) {
+	if notificationData == nil {
+		if err := idempotencyService.Release(ctx, claim); err != nil {
+			slog.Error("sendNotificationView:unableToReleaseIdempotencyKey", slog.Any("error", err))
+		}
+		return
+	}
+	body, err := json.Marshal(response)
+	if err == nil {
+		err = idempotencyService.Complete(ctx, claim, notificationData.RequestID, status, body)
+	}
+	if err != nil {
+		slog.Error("sendNotificationView:unableToStoreIdempotentResponse",
+			slog.String("request_id", notificationData.RequestID),
+			slog.Any("error", err))
+	}
+}
+
+// UploadAudiences streams a CSV or NDJSON audience list into a new session.
+// The template and options are query parameters since the body is the list,
+// the format follows the Content-Type or the format parameter.
+func (c *NotificationController) UploadAudiences(ctx *gin.Context) {
+	templateID, err := strconv.ParseInt(ctx.Query("template_id"), 10, 64)
+	if err != nil || templateID <= 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid template_id"})
+		return
+	}
+	request := NotificationRequestType{TemplateID: templateID}
+	request.Channel = ctx.Query("channel")
+	request.LocalTime = ctx.Query("local_time")
+	if request.Channel != "" && !common.IsChannel(request.Channel) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "channel must be one of email, sms, push or in_app"})
+		return
+	}
+	if request.LocalTime != "" {
+		if _, err := communicator.ParseClock(request.LocalTime); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "local_time must be a time of day like 09:00"})
+			return
+		}
+	}
+	format := uploadFormat(ctx)
+	if format == "" {
+		ctx.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "upload text/csv or application/x-ndjson"})
+		return
+	}
+
+	request.UserName = ctx.GetString("username")
+	notificationService := NewNotificationService(ctx, request, NewNotificationSessionService(sql.PagerOrm), templates.NewTemplateService(sql.PagerOrm), c.kafkaProducer)
+	result, err := notificationService.UploadAudiences(context.WithoutCancel(ctx.Request.Context()), format, ctx.Request.Body)
+	if err != nil {
+		slog.Error("uploadAudiencesView:unableToUploadAudiences",
+			slog.Int64("template_id", templateID),
+			slog.Any("error", err))
+		status := http.StatusInternalServerError
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			status = http.StatusNotFound
+		} else if errors.Is(err, templates.ErrUnsupportedChannel) || errors.Is(err, ErrInvalidUpload) {
+			status = http.StatusBadRequest
+		}
+		ctx.JSON(status, gin.H{
+			"error":  err.Error(),
+			"status": false,
+			"msg":    err.Error(),
+			"data":   result,
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"error":  nil,
+		"status": true,
+		"msg":    "Audiences uploaded successfully",
+		"data":   result,
+	})
+}
+
+// uploadFormat returns the format of an upload, empty when it is not supported
+func uploadFormat(ctx *gin.Context) string {
+	format := ctx.Query("format")
+	if format == "" {
+		switch ctx.ContentType() {
+		case "text/csv":
+			format = UploadFormatCSV
+		case "application/x-ndjson", "application/jsonl":
+			format = UploadFormatNDJSON
+		}
+	}
+	if format != UploadFormatCSV && format != UploadFormatNDJSON {
+		return ""
+	}
+	return format
+}
+
+// recordTrigger reports the outcome of a trigger request to the metrics
+func recordTrigger(request NotificationRequestType, err error, elapsed time.Duration) {
+	templateID := metrics.TemplateLabel(request.TemplateID)
+	scheduled := strconv.FormatBool(request.SendAt != nil)
+	outcome := metrics.Outcome(err)
+	metrics.NotificationTriggers.WithLabelValues(templateID, scheduled, outcome).Inc()
+	metrics.NotificationTriggerDuration.WithLabelValues(templateID, scheduled, outcome).Observe(elapsed.Seconds())
+	if err == nil {
+		metrics.AudiencesAccepted.WithLabelValues(templateID, scheduled).Add(float64(len(request.Audiences)))
+	}
+}
+
+func (c *NotificationController) GetNotificationStatus(ctx *gin.Context) {
+	requestID := ctx.Param("request_id")
+	page, err := strconv.Atoi(ctx.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid page"})
+		return
+	}
+	pageSize, err := strconv.Atoi(ctx.DefaultQuery("page_size", strconv.Itoa(defaultRecipientsPageSize)))
+	if err != nil || pageSize < 1 || pageSize > maxRecipientsPageSize {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid page_size"})
+		return
+	}
+
+	notificationSessionService := NewNotificationSessionService(sql.PagerOrm)
+	status, err := notificationSessionService.GetStatus(ctx.Request.Context(), requestID, page, pageSize)
+	if gorm.IsRecordNotFoundError(err) {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"error":  "notification session not found",
+			"status": false,
+			"msg":    "notification session not found",
+		})
+		return
+	}
+	if err != nil {
+		slog.Error("getNotificationStatusView:unableToGetStatus",
+			slog.String("request_id", requestID),
+			slog.Any("error", err))
 		ctx.JSON(http.StatusInternalServerError, gin.H{
 			"error":  err.Error(),
 			"status": false,
@@ -45,7 +280,74 @@ func (c *NotificationController) SendNotification(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, gin.H{
 		"error":  nil,
 		"status": true,
-		"msg":    "Notification sent successfully",
-		"data":   notificationData,
+		"msg":    "Notification status retrieved successfully",
+		"data":   status,
 	})
 }
+
+func (c *NotificationController) CancelNotification(ctx *gin.Context) {
+	requestID := ctx.Param("request_id")
+	notificationSessionService := NewNotificationSessionService(sql.PagerOrm)
+	err := notificationSessionService.Cancel(ctx.Request.Context(), requestID)
+	if err != nil {
+		slog.Error("cancelNotificationView:unableToCancel",
+			slog.String("request_id", requestID),
+			slog.Any("error", err))
+		ctx.JSON(scheduleErrorStatus(err), gin.H{
+			"error":  err.Error(),
+			"status": false,
+			"msg":    err.Error(),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"error":  nil,
+		"status": true,
+		"msg":    "Notification cancelled successfully",
+	})
+}
+
+func (c *NotificationController) RescheduleNotification(ctx *gin.Context) {
+	requestID := ctx.Param("request_id")
+	var request RescheduleRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		slog.Error("rescheduleNotificationView:unableToBindJSON", slog.Any("error", err))
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !request.SendAt.After(time.Now()) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "send_at must be in the future"})
+		return
+	}
+
+	notificationSessionService := NewNotificationSessionService(sql.PagerOrm)
+	err := notificationSessionService.Reschedule(ctx.Request.Context(), requestID, request.SendAt)
+	if err != nil {
+		slog.Error("rescheduleNotificationView:unableToReschedule",
+			slog.String("request_id", requestID),
+			slog.Any("error", err))
+		ctx.JSON(scheduleErrorStatus(err), gin.H{
+			"error":  err.Error(),
+			"status": false,
+			"msg":    err.Error(),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"error":  nil,
+		"status": true,
+		"msg":    "Notification rescheduled successfully",
+		"data":   gin.H{"request_id": requestID, "send_at": request.SendAt},
+	})
+}
+
+func scheduleErrorStatus(err error) int {
+	switch {
+	case gorm.IsRecordNotFoundError(err):
+		return http.StatusNotFound
+	case errors.Is(err, ErrSessionNotScheduled):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,125 @@
+package notification
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/kp/pager/common"
+	models "github.com/kp/pager/notification/models"
+)
+
+// IdempotencyKeyHeader lets clients retry a trigger without notifying the audiences twice
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+const (
+	maxIdempotencyKeyLength = 255
+	// idempotencyClaimTimeout is how long a request may hold a key before a
+	// retry takes it over, longer than any trigger takes to publish
+	idempotencyClaimTimeout = 5 * time.Minute
+)
+
+var (
+	// ErrIdempotencyKeyReused is returned when a key is sent again with a different request
+	ErrIdempotencyKeyReused = errors.New("idempotency key was already used with a different request")
+	// ErrIdempotencyKeyInProgress is returned while the first request with a key has not finished
+	ErrIdempotencyKeyInProgress = errors.New("a request with this idempotency key is in progress")
+)
+
+type IdempotencyService interface {
+	Begin(ctx context.Context, owner, key, fingerprint string) (*IdempotentResponse, *models.IdempotencyKey, error)
+	Complete(ctx context.Context, claim *models.IdempotencyKey, requestID string, status int, body []byte) error
+	Release(ctx context.Context, claim *models.IdempotencyKey) error
+}
+
+// IdempotentResponse is the response of the first request made with a key
+type IdempotentResponse struct {
+	RequestID string
+	Status    int
+	Body      []byte
+}
+
+type idempotencyService struct {
+	db *gorm.DB
+}
+
+func NewIdempotencyService(db *gorm.DB) IdempotencyService {
+	return &idempotencyService{db: db}
+}
+
+// Begin claims key for a request with fingerprint. When the key was used
+// before with the same request, the response to replay is returned instead of
+// a claim.
+func (s *idempotencyService) Begin(ctx context.Context, owner, key, fingerprint string) (*IdempotentResponse, *models.IdempotencyKey, error) {
+	claim, err := models.NewIdempotencyKeyEntry(ctx, s.db, owner, key, fingerprint)
+	if err == nil {
+		return nil, claim, nil
+	}
+
+	// the insert conflicts when the key exists, anything else is a failure
+	existing, getErr := models.GetIdempotencyKey(ctx, s.db, owner, key)
+	if getErr != nil {
+		return nil, nil, errors.Join(err, getErr)
+	}
+	if existing.Fingerprint != fingerprint {
+		return nil, nil, ErrIdempotencyKeyReused
+	}
+	if existing.ResponseStatus != 0 {
+		return &IdempotentResponse{
+			RequestID: existing.RequestID,
+			Status:    existing.ResponseStatus,
+			Body:      []byte(existing.ResponseBody),
+		}, nil, nil
+	}
+
+	reclaimed, err := models.ReclaimIdempotencyKey(ctx, s.db, existing.ID, time.Now().Add(-idempotencyClaimTimeout))
+	if err != nil {
+		return nil, nil, err
+	}
+	if reclaimed == 0 {
+		return nil, nil, ErrIdempotencyKeyInProgress
+	}
+	return nil, existing, nil
+}
+
+// Complete stores the response of the request holding claim
+func (s *idempotencyService) Complete(ctx context.Context, claim *models.IdempotencyKey, requestID string, status int, body []byte) error {
+	return models.CompleteIdempotencyKey(ctx, s.db, claim.ID, requestID, status, string(body))
+}
+
+// Release frees claim so the request can be retried with the same key, used
+// when the request failed before creating a session
+func (s *idempotencyService) Release(ctx context.Context, claim *models.IdempotencyKey) error {
+	return models.DeleteIdempotencyKey(ctx, s.db, claim.ID)
+}
+
+// requestFingerprint hashes the fields of a trigger request which decide who is
+// notified with what, so formatting differences of the body do not matter
+func requestFingerprint(request NotificationRequestType) (string, error) {
+	fingerprint := struct {
+		TemplateID int64                 `json:"template_id"`
+		Audiences  []common.AudienceType `json:"audiences"`
+		SendAt     *time.Time            `json:"send_at"`
+		Channel    string                `json:"channel"`
+		LocalTime  string                `json:"local_time"`
+	}{
+		TemplateID: request.TemplateID,
+		Audiences:  request.Audiences,
+		Channel:    request.Channel,
+		LocalTime:  request.LocalTime,
+	}
+	if request.SendAt != nil {
+		sendAt := request.SendAt.UTC()
+		fingerprint.SendAt = &sendAt
+	}
+	encoded, err := json.Marshal(fingerprint)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,55 @@
+package notification
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kp/pager/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestFingerprint(t *testing.T) {
+	sendAt := time.Date(2030, 1, 2, 15, 4, 5, 0, time.UTC)
+	request := func() NotificationRequestType {
+		return NotificationRequestType{
+			TemplateID: 7,
+			Notification: Notification{
+				Audiences: []common.AudienceType{{
+					Email:   "user@example.com",
+					Context: map[string]string{"first_name": "Ada", "plan": "pro"},
+				}},
+				SendAt: &sendAt,
+			},
+		}
+	}
+
+	fingerprint, err := requestFingerprint(request())
+	require.NoError(t, err)
+
+	sameInstant := request()
+	localSendAt := sendAt.In(time.FixedZone("UTC+2", 2*60*60))
+	sameInstant.SendAt = &localSendAt
+	sameInstant.UserName = "admin"
+	got, err := requestFingerprint(sameInstant)
+	require.NoError(t, err)
+	assert.Equal(t, fingerprint, got)
+
+	changes := map[string]func(*NotificationRequestType){
+		"template":   func(r *NotificationRequestType) { r.TemplateID = 8 },
+		"audiences":  func(r *NotificationRequestType) { r.Audiences[0].Email = "other@example.com" },
+		"context":    func(r *NotificationRequestType) { r.Audiences[0].Context["plan"] = "free" },
+		"send_at":    func(r *NotificationRequestType) { r.SendAt = nil },
+		"channel":    func(r *NotificationRequestType) { r.Channel = common.ChannelSMS },
+		"local_time": func(r *NotificationRequestType) { r.LocalTime = "09:00" },
+	}
+	for name, change := range changes {
+		t.Run(name, func(t *testing.T) {
+			changed := request()
+			change(&changed)
+			got, err := requestFingerprint(changed)
+			require.NoError(t, err)
+			assert.NotEqual(t, fingerprint, got)
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package notification
+
+import (
+	"context"
+	"time"
+
+	"github.com/kp/pager/databases/sql"
+)
+
+const IdempotencyKeyTableName = "notification_idempotency_key"
+
+// IdempotencyKey remembers the trigger request made with a client supplied
+// key. ResponseStatus stays 0 while the request is in progress.
+type IdempotencyKey struct {
+	ID             int64     `gorm:"column:id;primaryKey"`
+	Owner          string    `gorm:"column:owner;unique_index:idx_idempotency_owner_key"`
+	Key            string    `gorm:"column:idempotency_key;unique_index:idx_idempotency_owner_key"`
+	Fingerprint    string    `gorm:"column:fingerprint"`
+	RequestID      string    `gorm:"column:request_id"`
+	ResponseStatus int       `gorm:"column:response_status;default:0"`
+	ResponseBody   string    `gorm:"column:response_body;type:text"`
+	CreatedAt      time.Time `gorm:"column:created_at"`
+	UpdatedAt      time.Time `gorm:"column:updated_at"`
+}
+
+func (IdempotencyKey) TableName() string {
+	return IdempotencyKeyTableName
+}
+
+// NewIdempotencyKeyEntry claims key for owner, it fails when the key was already claimed
+func NewIdempotencyKeyEntry(ctx context.Context, tx interface{}, owner, key, fingerprint string) (*IdempotencyKey, error) {
+	database := sql.GetOrmQuearyable(ctx, tx)
+	entry := IdempotencyKey{
+		Owner:       owner,
+		Key:         key,
+		Fingerprint: fingerprint,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+	err := database.Create(&entry).Error
+	return &entry, err
+}
+
+func GetIdempotencyKey(ctx context.Context, tx interface{}, owner, key string) (*IdempotencyKey, error) {
+	db := sql.GetOrmQuearyable(ctx, tx)
+	entry := IdempotencyKey{}
+	err := db.Where("owner = ? AND idempotency_key = ?", owner, key).First(&entry).Error
+	return &entry, err
+}
+
+// ReclaimIdempotencyKey takes over a key whose request is still in progress
+// but was last updated before staleBefore, e.g. after the api crashed.
+// It returns the number of keys updated.
+func ReclaimIdempotencyKey(ctx context.Context, tx interface{}, id int64, staleBefore time.Time) (int64, error) {
+	db := sql.GetOrmQuearyable(ctx, tx)
+	result := db.Model(&IdempotencyKey{}).
+		Where("id = ? AND response_status = 0 AND updated_at < ?", id, staleBefore).
+		Updates(map[string]interface{}{
+			"updated_at": time.Now(),
+		})
+	return result.RowsAffected, result.Error
+}
+
+// CompleteIdempotencyKey stores the response replayed for later requests with the key
+func CompleteIdempotencyKey(ctx context.Context, tx interface{}, id int64, requestID string, status int, body string) error {
+	db := sql.GetOrmQuearyable(ctx, tx)
+	return db.Model(&IdempotencyKey{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"request_id":      requestID,
+		"response_status": status,
+		"response_body":   body,
+		"updated_at":      time.Now(),
+	}).Error
+}
+
+func DeleteIdempotencyKey(ctx context.Context, tx interface{}, id int64) error {
+	db := sql.GetOrmQuearyable(ctx, tx)
+	return db.Where("id = ?", id).Delete(&IdempotencyKey{}).Error
+}
@@ -10,28 +10,68 @@ import (
 const NotificationSessionTableName = "notification_session"
 
 type NotificationSession struct {
-	ID            int64     `gorm:"column:id;primaryKey"`
-	TemplateID    int64     `gorm:"column:template_id"`
-	RequestID     string    `gorm:"column:request_id"`
-	TotalAudience int       `gorm:"column:total_audience"`
-	Status        string    `gorm:"column:status"`
-	CreatedAt     time.Time `gorm:"column:created_at"`
-	UpdatedAt     time.Time `gorm:"column:updated_at"`
+	ID         int64 `gorm:"column:id;primaryKey"`
+	TemplateID int64 `gorm:"column:template_id"`
+	// TemplateVersion pins the template content rendered for the session
+	TemplateVersion int `gorm:"column:template_version;default:0"`
+	// Channel is the channel chosen by the request, empty lets every recipient's preferences choose
+	Channel string `gorm:"column:channel"`
+	// LocalTime delivers to every recipient at this HH:MM of their timezone
+	LocalTime     string `gorm:"column:local_time"`
+	RequestID     string `gorm:"column:request_id;unique_index"`
+	TotalAudience int    `gorm:"column:total_audience"`
+	TotalBatches  int    `gorm:"column:total_batches;default:0"`
+	// ProcessedBatches is the count of the session's ProcessedBatch rows
+	ProcessedBatches int    `gorm:"column:processed_batches;default:0"`
+	Status           string `gorm:"column:status;index"`
+	// FailureReason tells why a session failed before any batch was published
+	FailureReason string `gorm:"column:failure_reason;type:text"`
+	// SendAt and Audiences are only set for scheduled sessions
+	SendAt    *time.Time `gorm:"column:send_at;index"`
+	Audiences string     `gorm:"column:audiences;type:text"`
+	CreatedAt time.Time  `gorm:"column:created_at"`
+	UpdatedAt time.Time  `gorm:"column:updated_at"`
 }
 
 func (NotificationSession) TableName() string {
 	return NotificationSessionTableName
 }
 
-func NewNotificationSessionEntry(ctx context.Context, tx interface{}, status, requestID string, totalAudience int, templateID int64) (*NotificationSession, error) {
+func NewNotificationSessionEntry(ctx context.Context, tx interface{}, status, requestID string, totalAudience, totalBatches int, templateID int64, templateVersion int, channel, localTime string) (*NotificationSession, error) {
 	database := sql.GetOrmQuearyable(ctx, tx)
 	entry := NotificationSession{
-		TotalAudience: totalAudience,
-		TemplateID:    templateID,
-		RequestID:     requestID,
-		Status:        status,
-		CreatedAt:     time.Now(),
-		UpdatedAt:     time.Now(),
+		TotalAudience:   totalAudience,
+		TotalBatches:    totalBatches,
+		TemplateID:      templateID,
+		TemplateVersion: templateVersion,
+		Channel:         channel,
+		LocalTime:       localTime,
+		RequestID:       requestID,
+		Status:          status,
+		CreatedAt:       time.Now(),
+		UpdatedAt:       time.Now(),
+	}
+	err := database.Create(&entry).Error
+	return &entry, err
+}
+
+// NewScheduledNotificationSessionEntry persists a session together with its
+// audiences so the scheduler can dispatch it at sendAt
+func NewScheduledNotificationSessionEntry(ctx context.Context, tx interface{}, status, requestID string, totalAudience, totalBatches int, templateID int64, templateVersion int, channel, localTime string, sendAt time.Time, audiences string) (*NotificationSession, error) {
+	database := sql.GetOrmQuearyable(ctx, tx)
+	entry := NotificationSession{
+		TotalAudience:   totalAudience,
+		TotalBatches:    totalBatches,
+		TemplateID:      templateID,
+		TemplateVersion: templateVersion,
+		Channel:         channel,
+		LocalTime:       localTime,
+		RequestID:       requestID,
+		Status:          status,
+		SendAt:          &sendAt,
+		Audiences:       audiences,
+		CreatedAt:       time.Now(),
+		UpdatedAt:       time.Now(),
 	}
 	err := database.Create(&entry).Error
 	return &entry, err
@@ -44,6 +84,96 @@ func GetNotificationSessionByID(ctx context.Context, tx interface{}, id int64) (
 	return &entry, err
 }
 
+func GetNotificationSessionByRequestID(ctx context.Context, tx interface{}, requestID string) (*NotificationSession, error) {
+	db := sql.GetOrmQuearyable(ctx, tx)
+	entry := NotificationSession{}
+	err := db.Where("request_id = ?", requestID).First(&entry).Error
+	return &entry, err
+}
+
+// UpdateNotificationSessionTotalBatches sets how many batches of the session
+// were published and will be consumed
+func UpdateNotificationSessionTotalBatches(ctx context.Context, tx interface{}, requestID string, totalBatches int) error {
+	db := sql.GetOrmQuearyable(ctx, tx)
+	return db.Model(&NotificationSession{}).Where("request_id = ?", requestID).Updates(map[string]interface{}{
+		"total_batches": totalBatches,
+		"updated_at":    time.Now(),
+	}).Error
+}
+
+// UpdateNotificationSessionStatus moves the session to status only when it
+// is currently in one of fromStatuses, so concurrent updates cannot regress it.
+// It returns the number of sessions updated.
+func UpdateNotificationSessionStatus(ctx context.Context, tx interface{}, requestID, status string, fromStatuses ...string) (int64, error) {
+	db := sql.GetOrmQuearyable(ctx, tx)
+	query := db.Model(&NotificationSession{}).Where("request_id = ?", requestID)
+	if len(fromStatuses) > 0 {
+		query = query.Where("status IN (?)", fromStatuses)
+	}
+	result := query.Updates(map[string]interface{}{
+		"status":     status,
+		"updated_at": time.Now(),
+	})
+	return result.RowsAffected, result.Error
+}
+
+// FailNotificationSession moves the session to status with reason when it is
+// currently in fromStatus. It returns the number of sessions updated.
+func FailNotificationSession(ctx context.Context, tx interface{}, requestID, status, reason, fromStatus string) (int64, error) {
+	db := sql.GetOrmQuearyable(ctx, tx)
+	result := db.Model(&NotificationSession{}).
+		Where("request_id = ? AND status = ?", requestID, fromStatus).
+		Updates(map[string]interface{}{
+			"status":         status,
+			"failure_reason": reason,
+			"updated_at":     time.Now(),
+		})
+	return result.RowsAffected, result.Error
+}
+
+// UpdateNotificationSessionAudience sets the audience of a session which was
+// uploaded and moves it to status when it is still in fromStatus
+func UpdateNotificationSessionAudience(ctx context.Context, tx interface{}, requestID string, totalAudience, totalBatches int, status, fromStatus string) error {
+	db := sql.GetOrmQuearyable(ctx, tx)
+	return db.Model(&NotificationSession{}).
+		Where("request_id = ? AND status = ?", requestID, fromStatus).
+		Updates(map[string]interface{}{
+			"total_audience": totalAudience,
+			"total_batches":  totalBatches,
+			"status":         status,
+			"updated_at":     time.Now(),
+		}).Error
+}
+
+// UpdateNotificationSessionSendAt changes the send time of a session which is still in status
+func UpdateNotificationSessionSendAt(ctx context.Context, tx interface{}, requestID, status string, sendAt time.Time) (int64, error) {
+	db := sql.GetOrmQuearyable(ctx, tx)
+	result := db.Model(&NotificationSession{}).
+		Where("request_id = ? AND status = ?", requestID, status).
+		Updates(map[string]interface{}{
+			"send_at":    sendAt,
+			"updated_at": time.Now(),
+		})
+	return result.RowsAffected, result.Error
+}
+
+// ClaimDueNotificationSession locks the earliest session in status whose
+// send_at has passed. Sessions locked by another transaction are skipped so
+// several schedulers can claim sessions concurrently. tx must be a transaction.
+func ClaimDueNotificationSession(ctx context.Context, tx interface{}, status string, now time.Time) (*NotificationSession, error) {
+	db := sql.GetOrmQuearyable(ctx, tx)
+	if db.Dialect().GetName() == "postgres" {
+		// other databases lock the table for the write of the transaction
+		db = db.Set("gorm:query_option", "FOR UPDATE SKIP LOCKED")
+	}
+	entry := NotificationSession{}
+	err := db.
+		Where("status = ? AND send_at <= ?", status, now).
+		Order("send_at").
+		First(&entry).Error
+	return &entry, err
+}
+
 func GetAllNotificationSessions(ctx context.Context, tx interface{}, limit, offset int) ([]NotificationSession, error) {
 	var sessions []NotificationSession
 	db := sql.GetOrmQuearyable(ctx, tx)
@@ -0,0 +1,43 @@
+package notification
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/kp/pager/databases/sql"
+)
+
+const ProcessedBatchTableName = "notification_session_batches"
+
+// ProcessedBatch records a batch of a session consumed by a worker, a batch
+// delivered again by the queue is only recorded once
+type ProcessedBatch struct {
+	ID        int64     `gorm:"column:id;primaryKey"`
+	RequestID string    `gorm:"column:request_id;not null;unique_index:idx_session_batches_request_batch"`
+	BatchID   string    `gorm:"column:batch_id;not null;unique_index:idx_session_batches_request_batch"`
+	CreatedAt time.Time `gorm:"column:created_at"`
+}
+
+func (ProcessedBatch) TableName() string {
+	return ProcessedBatchTableName
+}
+
+// RecordProcessedBatch records batchID of the session as processed and
+// recounts the processed batches of the session. It reports false when the
+// batch was recorded before.
+func RecordProcessedBatch(ctx context.Context, tx interface{}, requestID, batchID string) (bool, error) {
+	db := sql.GetOrmQuearyable(ctx, tx)
+	result := db.Exec(fmt.Sprintf(
+		"INSERT INTO %s (request_id, batch_id, created_at) VALUES (?, ?, ?) ON CONFLICT (request_id, batch_id) DO NOTHING",
+		ProcessedBatchTableName), requestID, batchID, time.Now())
+	if result.Error != nil {
+		return false, result.Error
+	}
+	err := db.Model(&NotificationSession{}).Where("request_id = ?", requestID).Updates(map[string]interface{}{
+		"processed_batches": gorm.Expr(fmt.Sprintf("(SELECT COUNT(*) FROM %s WHERE request_id = ?)", ProcessedBatchTableName), requestID),
+		"updated_at":        time.Now(),
+	}).Error
+	return result.RowsAffected > 0, err
+}
@@ -4,11 +4,20 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	batchprocessor "github.com/kp/pager/batch_processor"
+	"github.com/kp/pager/common"
 	"github.com/kp/pager/communicator"
 	"github.com/kp/pager/databases/kafka"
+	"github.com/kp/pager/templates"
+	"github.com/kp/pager/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func generateUniqueID() string {
@@ -19,24 +28,53 @@ func generateUniqueID() string {
 	return hex.EncodeToString(b)
 }
 
-func NewNotificationService(ctx context.Context, notificationRequest NotificationRequestType, sessionService NotificationSessionService, kafkaProducer kafka.KafkaProducer) NotificationService {
+func NewNotificationService(ctx context.Context, notificationRequest NotificationRequestType, sessionService NotificationSessionService, templateService templates.TemplateService, kafkaProducer kafka.KafkaProducer) NotificationService {
 	return &Notification{
 		TemplateID:                 notificationRequest.TemplateID,
 		Audiences:                  notificationRequest.Audiences,
+		SendAt:                     notificationRequest.SendAt,
+		Channel:                    notificationRequest.Channel,
+		LocalTime:                  notificationRequest.LocalTime,
 		NotificationSessionService: sessionService,
+		TemplateService:            templateService,
 		KafkaProducer:              kafkaProducer,
 	}
 }
 
 func (c *Notification) SendNotification(ctx context.Context) (*Notification, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "notification.trigger", trace.WithAttributes(
+		attribute.Int64(tracing.AttrTemplateID, c.TemplateID),
+		attribute.Int(tracing.AttrAudiences, len(c.Audiences)),
+	))
+	defer span.End()
+
+	// a recipient is logged and notified once per session, counting duplicates
+	// would leave the session waiting for recipients which never come
+	c.Audiences = uniqueAudiences(c.Audiences)
+
+	// Pin the current template version, later edits do not change this session
+	template, err := c.TemplateService.GetTemplate(ctx, c.TemplateID)
+	if err != nil {
+		span.SetStatus(codes.Error, "failed to get template")
+		return nil, fmt.Errorf("failed to get template %d: %w", c.TemplateID, err)
+	}
+	if c.Channel != "" && !template.Supports(c.Channel) {
+		span.SetStatus(codes.Error, "unsupported channel")
+		return nil, fmt.Errorf("%w: template %d has no %s content", templates.ErrUnsupportedChannel, c.TemplateID, c.Channel)
+	}
+
 	// Create notification session with unique request_id
 	session := NotificationSession{
-		RequestID:     generateUniqueID(),
-		Status:        NotifcationSessionStatusCreated,
-		TotalAudience: len(c.Audiences),
-		TotalSent:     len(c.Audiences),
-		TemplateID:    c.TemplateID,
-		TotalSuccess:  0,
+		RequestID:       generateUniqueID(),
+		Status:          NotifcationSessionStatusCreated,
+		TotalAudience:   len(c.Audiences),
+		TotalBatches:    batchprocessor.BatchCount(len(c.Audiences)),
+		TotalSent:       len(c.Audiences),
+		TemplateID:      c.TemplateID,
+		TemplateVersion: template.Version,
+		Channel:         c.Channel,
+		LocalTime:       c.LocalTime,
+		TotalSuccess:    0,
 		BatchProcessor: &batchprocessor.BatchChannelBased{
 			TopicName: "notification_queue",
 			Model:     communicator.NotificationType{},
@@ -44,32 +82,97 @@ func (c *Notification) SendNotification(ctx context.Context) (*Notification, err
 		},
 	}
 
+	// Scheduled sessions keep their audiences until the scheduler dispatches them
+	if c.SendAt != nil {
+		session.Status = NotifcationSessionStatusScheduled
+		session.SendAt = c.SendAt
+		session.Audiences = c.Audiences
+	}
+
+	span.SetAttributes(attribute.String(tracing.AttrRequestID, session.RequestID))
+
 	// Save notification session to database for tracking and auditing purposes
 	sessionID, err := c.NotificationSessionService.Create(ctx, session)
 	if err != nil {
+		span.SetStatus(codes.Error, "failed to create session")
 		return nil, err
 	}
 
-	notificationType := communicator.NotificationType{
-		TemplateID: c.TemplateID,
-		SessionID:  sessionID,
-		RequestId:  session.RequestID,
+	c.ID = sessionID
+	c.RequestID = session.RequestID
+	c.Status = session.Status
+	c.TemplateVersion = template.Version
+	if c.SendAt == nil {
+		results, err := dispatchSession(ctx, c.KafkaProducer, communicator.NotificationType{
+			TemplateID:      c.TemplateID,
+			TemplateVersion: template.Version,
+			Channel:         c.Channel,
+			Channels:        template.Channels(),
+			Category:        template.Category,
+			LocalTime:       c.LocalTime,
+			DispatchedAt:    time.Now(),
+			SessionID:       sessionID,
+			RequestId:       session.RequestID,
+		}, c.Audiences)
+		c.Batches = results
+		if err != nil {
+			// Only published batches are consumed, the session must not wait for the others
+			published := publishedBatches(results)
+			if recordErr := c.NotificationSessionService.RecordDispatch(ctx, session.RequestID, published); recordErr != nil {
+				err = errors.Join(err, recordErr)
+			}
+			if published == 0 {
+				c.Status = NotifcationSessionStatusFailed
+			}
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "failed to publish batches")
+			return c, err
+		}
 	}
 
-	session.BatchProcessor = batchprocessor.NewBatchProcessor(
+	c.CreatedAt = time.Now()
+	c.UpdatedAt = time.Now()
+	return c, nil
+}
+
+// dispatchSession splits the audiences of a session into batches of
+// notificationType and publishes them, returning the delivery outcome of every batch
+func dispatchSession(ctx context.Context, kafkaProducer kafka.KafkaProducer, notificationType communicator.NotificationType, audiences []common.AudienceType) ([]batchprocessor.BatchResult, error) {
+	batchProcessor := batchprocessor.NewBatchProcessor(
 		ctx,
-		c.Audiences,
+		audiences,
 		notificationType,
-		"notification_batch",
-		c.KafkaProducer,
+		kafka.NotificationBatchTopic,
+		kafkaProducer,
 	)
-	// Process notification batch asynchronously through the batch processor
-	if err := session.BatchProcessor.Process(ctx); err != nil {
-		return nil, err
+	// Process notification batch through the batch processor, waiting for delivery
+	err := batchProcessor.Process(ctx)
+	return batchProcessor.Results(), err
+}
+
+func publishedBatches(results []batchprocessor.BatchResult) int {
+	published := 0
+	for _, result := range results {
+		if result.Error == "" {
+			published++
+		}
 	}
+	return published
+}
 
-	c.ID = sessionID
-	c.CreatedAt = time.Now()
-	c.UpdatedAt = time.Now()
-	return c, nil
+// uniqueAudiences drops repeated recipients of audiences, keeping the first.
+// Recipients are compared by all their addresses like uploads do.
+func uniqueAudiences(audiences []common.AudienceType) []common.AudienceType {
+	type addresses struct{ email, phone, deviceToken, userID string }
+	seen := make(map[addresses]struct{}, len(audiences))
+	unique := make([]common.AudienceType, 0, len(audiences))
+	for _, audience := range audiences {
+		key := addresses{strings.ToLower(audience.Email), audience.Phone, audience.DeviceToken, audience.UserID}
+		if _, duplicate := seen[key]; duplicate {
+			continue
+		}
+		seen[key] = struct{}{}
+		unique = append(unique, audience)
+	}
+	return unique
 }
@@ -0,0 +1,19 @@
+package notification
+
+import (
+	"testing"
+
+	"github.com/kp/pager/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUniqueAudiences(t *testing.T) {
+	audiences := []common.AudienceType{
+		{Email: "ada@example.com", Context: map[string]string{"name": "Ada"}},
+		{Email: "grace@example.com"},
+		{Email: "Ada@Example.com", Context: map[string]string{"name": "Duplicate"}},
+		{Email: "ada@example.com", Phone: "+4915100000000"},
+	}
+	assert.Equal(t, []common.AudienceType{audiences[0], audiences[1], audiences[3]}, uniqueAudiences(audiences))
+	assert.Empty(t, uniqueAudiences(nil))
+}
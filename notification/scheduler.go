@@ -0,0 +1,183 @@
+package notification
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/kp/pager/common"
+	"github.com/kp/pager/communicator"
+	"github.com/kp/pager/databases/kafka"
+	models "github.com/kp/pager/notification/models"
+	"github.com/kp/pager/templates"
+	"github.com/kp/pager/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var defaultSchedulerInterval = 10 * time.Second
+
+// Scheduler dispatches scheduled sessions once their send_at has passed.
+// Sessions are claimed with row locks, so any number of schedulers can run
+// against the same database.
+type Scheduler struct {
+	db            *gorm.DB
+	kafkaProducer kafka.KafkaProducer
+	interval      time.Duration
+}
+
+func NewScheduler(db *gorm.DB, kafkaProducer kafka.KafkaProducer, config SchedulerConfig) (*Scheduler, error) {
+	interval := defaultSchedulerInterval
+	if config.Interval != "" {
+		var err error
+		interval, err = time.ParseDuration(config.Interval)
+		if err != nil || interval <= 0 {
+			return nil, fmt.Errorf("invalid SCHEDULER_INTERVAL %q", config.Interval)
+		}
+	}
+	return &Scheduler{
+		db:            db,
+		kafkaProducer: kafkaProducer,
+		interval:      interval,
+	}, nil
+}
+
+// Run polls for due sessions until ctx is cancelled
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			dispatched, err := s.DispatchDue(ctx)
+			if err != nil {
+				slog.Error("scheduler:dispatchFailed", slog.Any("error", err))
+			}
+			if dispatched > 0 {
+				slog.Info("scheduler:dispatchedSessions", slog.Int("count", dispatched))
+			}
+		}
+	}
+}
+
+// DispatchDue dispatches every session that is due, one transaction each
+func (s *Scheduler) DispatchDue(ctx context.Context) (int, error) {
+	if s.db == nil {
+		return 0, errors.New("database is not initialized")
+	}
+	dispatched := 0
+	for ctx.Err() == nil {
+		claimed, err := s.dispatchNext(ctx)
+		if err != nil || !claimed {
+			return dispatched, err
+		}
+		dispatched++
+	}
+	return dispatched, nil
+}
+
+// dispatchNext keeps the claimed session locked while its batches are
+// published, if this instance dies midway the session is picked up again
+func (s *Scheduler) dispatchNext(ctx context.Context) (bool, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "scheduler.dispatch")
+	defer span.End()
+
+	tx := s.db.Begin()
+	if tx.Error != nil {
+		return false, tx.Error
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			tx.Rollback()
+		}
+	}()
+
+	entry, err := models.ClaimDueNotificationSession(ctx, tx, NotifcationSessionStatusScheduled, time.Now())
+	if gorm.IsRecordNotFoundError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	span.SetAttributes(attribute.String(tracing.AttrRequestID, entry.RequestID))
+	// a session which can never be dispatched fails so it does not hold back
+	// the sessions due after it
+	status := NotifcationSessionStatusCreated
+	failureReason := ""
+	var audiences []common.AudienceType
+	if err := json.Unmarshal([]byte(entry.Audiences), &audiences); err != nil {
+		slog.Error("scheduler:invalidAudiences",
+			slog.String("request_id", entry.RequestID),
+			slog.Any("error", err))
+		status = NotifcationSessionStatusFailed
+		failureReason = fmt.Sprintf("invalid audiences: %v", err)
+	} else if template, err := s.sessionTemplate(ctx, entry); err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			// the session is claimed again on the next tick
+			return false, err
+		}
+		slog.Error("scheduler:templateNotFound",
+			slog.String("request_id", entry.RequestID),
+			slog.Any("error", err))
+		status = NotifcationSessionStatusFailed
+		failureReason = err.Error()
+	} else if results, err := dispatchSession(ctx, s.kafkaProducer, communicator.NotificationType{
+		TemplateID:      entry.TemplateID,
+		TemplateVersion: entry.TemplateVersion,
+		Channel:         entry.Channel,
+		Channels:        template.Channels(),
+		Category:        template.Category,
+		LocalTime:       entry.LocalTime,
+		DispatchedAt:    time.Now(),
+		SessionID:       entry.ID,
+		RequestId:       entry.RequestID,
+	}, audiences); err != nil {
+		slog.Error("scheduler:dispatchSessionFailed",
+			slog.String("request_id", entry.RequestID),
+			slog.Any("error", err))
+		published := publishedBatches(results)
+		if published == 0 {
+			status = NotifcationSessionStatusFailed
+			failureReason = err.Error()
+		}
+		if err := models.UpdateNotificationSessionTotalBatches(ctx, tx, entry.RequestID, published); err != nil {
+			return false, err
+		}
+	}
+
+	if status == NotifcationSessionStatusFailed {
+		if _, err := models.FailNotificationSession(ctx, tx, entry.RequestID, status, failureReason, NotifcationSessionStatusScheduled); err != nil {
+			return false, err
+		}
+	} else if _, err := models.UpdateNotificationSessionStatus(ctx, tx, entry.RequestID, status, NotifcationSessionStatusScheduled); err != nil {
+		return false, err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return false, err
+	}
+	committed = true
+	return true, nil
+}
+
+// sessionTemplate returns the template version pinned by the session
+func (s *Scheduler) sessionTemplate(ctx context.Context, entry *models.NotificationSession) (*templates.Template, error) {
+	templateService := templates.NewTemplateService(s.db)
+	var template *templates.Template
+	var err error
+	if entry.TemplateVersion > 0 {
+		template, err = templateService.GetTemplateVersion(ctx, entry.TemplateID, entry.TemplateVersion)
+	} else {
+		template, err = templateService.GetTemplate(ctx, entry.TemplateID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get template %d: %w", entry.TemplateID, err)
+	}
+	return template, nil
+}
@@ -0,0 +1,75 @@
+package notification
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kp/pager/databases/kafka"
+	"github.com/kp/pager/databases/sql"
+	models "github.com/kp/pager/notification/models"
+	"github.com/kp/pager/templates"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingProducer keeps the topics of the published messages
+type recordingProducer struct {
+	mu     sync.Mutex
+	topics []string
+}
+
+func (p *recordingProducer) Publish(ctx context.Context, topic string, data []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.topics = append(p.topics, topic)
+	return nil
+}
+
+func TestSchedulerDispatchDue_MissingTemplate(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	require.NoError(t, db.AutoMigrate(
+		&templates.NotificationTemplate{},
+		&templates.NotificationTemplateVersion{},
+		&templates.NotificationTemplateVariant{},
+	).Error)
+	// templates are read through the shared connection
+	previous := sql.PagerOrm
+	sql.PagerOrm = db
+	t.Cleanup(func() { sql.PagerOrm = previous })
+
+	template := templates.NotificationTemplate{Name: "welcome", Subject: "Hi", Content: "Hello {{.name}}", CurrentVersion: 1}
+	require.NoError(t, db.Create(&template).Error)
+	audiences := `[{"email":"someone@example.com"}]`
+	_, err := models.NewScheduledNotificationSessionEntry(ctx, db, NotifcationSessionStatusScheduled, "missing-template",
+		1, 1, template.ID+1, 0, "", "", time.Now().Add(-2*time.Minute), audiences)
+	require.NoError(t, err)
+	_, err = models.NewScheduledNotificationSessionEntry(ctx, db, NotifcationSessionStatusScheduled, "valid",
+		1, 1, template.ID, 0, "", "", time.Now().Add(-time.Minute), audiences)
+	require.NoError(t, err)
+
+	producer := &recordingProducer{}
+	scheduler, err := NewScheduler(db, producer, SchedulerConfig{})
+	require.NoError(t, err)
+	dispatched, err := scheduler.DispatchDue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, dispatched)
+
+	failed, err := models.GetNotificationSessionByRequestID(ctx, db, "missing-template")
+	require.NoError(t, err)
+	assert.Equal(t, NotifcationSessionStatusFailed, failed.Status)
+	assert.Contains(t, failed.FailureReason, "failed to get template")
+
+	valid, err := models.GetNotificationSessionByRequestID(ctx, db, "valid")
+	require.NoError(t, err)
+	assert.Equal(t, NotifcationSessionStatusCreated, valid.Status)
+	assert.Empty(t, valid.FailureReason)
+	assert.Equal(t, []string{kafka.NotificationBatchTopic}, producer.topics)
+
+	// nothing is left to claim on the next tick
+	dispatched, err = scheduler.DispatchDue(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, dispatched)
+}
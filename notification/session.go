@@ -2,12 +2,20 @@ package notification
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"strconv"
+	"time"
 
 	"github.com/jinzhu/gorm"
+	comm_models "github.com/kp/pager/communicator/models"
 	models "github.com/kp/pager/notification/models"
 )
 
+// ErrSessionNotScheduled is returned when cancelling or rescheduling a
+// session which has already been dispatched
+var ErrSessionNotScheduled = errors.New("notification session is not scheduled")
+
 type notificationSessionService struct {
 	db *gorm.DB
 }
@@ -23,13 +31,208 @@ func (s *notificationSessionService) Create(ctx context.Context, session Notific
 		return 0, errors.New("request_id cannot be empty")
 	}
 
+	if session.SendAt != nil {
+		audiences, err := json.Marshal(session.Audiences)
+		if err != nil {
+			return 0, err
+		}
+		entry, err := models.NewScheduledNotificationSessionEntry(
+			ctx,
+			s.db,
+			session.Status,
+			session.RequestID,
+			session.TotalAudience,
+			session.TotalBatches,
+			session.TemplateID,
+			session.TemplateVersion,
+			session.Channel,
+			session.LocalTime,
+			*session.SendAt,
+			string(audiences),
+		)
+		return entry.ID, err
+	}
+
 	entry, err := models.NewNotificationSessionEntry(
 		ctx,
 		s.db,
 		session.Status,
 		session.RequestID,
 		session.TotalAudience,
+		session.TotalBatches,
 		session.TemplateID,
+		session.TemplateVersion,
+		session.Channel,
+		session.LocalTime,
 	)
 	return entry.ID, err
 }
+
+// GetStatus returns the session with its aggregated recipient counts and one
+// page of per-recipient outcomes
+func (s *notificationSessionService) GetStatus(ctx context.Context, requestID string, page, pageSize int) (*NotificationStatus, error) {
+	entry, err := models.GetNotificationSessionByRequestID(ctx, s.db, requestID)
+	if err != nil {
+		return nil, err
+	}
+
+	counts, err := comm_models.CountCommunicationLogsByStatus(ctx, s.db, requestID)
+	if err != nil {
+		return nil, err
+	}
+	stats := sessionStats(entry.TotalAudience, counts)
+
+	logs, total, err := comm_models.GetCommunicationLogsPageByRequestID(ctx, s.db, requestID, pageSize, (page-1)*pageSize)
+	if err != nil {
+		return nil, err
+	}
+	recipients := make([]RecipientStatus, 0, len(logs))
+	for _, log := range logs {
+		recipients = append(recipients, RecipientStatus{
+			ID:        log.ID,
+			Email:     log.Email,
+			Channel:   log.Channel,
+			Status:    log.Status,
+			Error:     log.Error,
+			Attempts:  log.Attempts,
+			UpdatedAt: log.UpdatedAt,
+		})
+	}
+
+	return &NotificationStatus{
+		Session: NotificationSession{
+			ID:               strconv.FormatInt(entry.ID, 10),
+			RequestID:        entry.RequestID,
+			Status:           entry.Status,
+			FailureReason:    entry.FailureReason,
+			TemplateID:       entry.TemplateID,
+			TemplateVersion:  entry.TemplateVersion,
+			Channel:          entry.Channel,
+			LocalTime:        entry.LocalTime,
+			TotalAudience:    entry.TotalAudience,
+			TotalBatches:     entry.TotalBatches,
+			ProcessedBatches: entry.ProcessedBatches,
+			TotalSent:        stats.Sent + stats.Failed,
+			TotalSuccess:     stats.Sent,
+			CreatedAt:        entry.CreatedAt,
+			UpdatedAt:        entry.UpdatedAt,
+		},
+		Stats:           stats,
+		Recipients:      recipients,
+		Page:            page,
+		PageSize:        pageSize,
+		TotalRecipients: total,
+	}, nil
+}
+
+// MarkBatchProcessed is called by the consumer once every recipient of a
+// batch has been attempted, a redelivered batch is counted once
+func (s *notificationSessionService) MarkBatchProcessed(ctx context.Context, requestID, batchID string) error {
+	if _, err := models.RecordProcessedBatch(ctx, s.db, requestID, batchID); err != nil {
+		return err
+	}
+	return s.Refresh(ctx, requestID)
+}
+
+// Refresh moves the session to its final status once all batches have been
+// processed and no recipient is waiting to be sent or retried
+func (s *notificationSessionService) Refresh(ctx context.Context, requestID string) error {
+	entry, err := models.GetNotificationSessionByRequestID(ctx, s.db, requestID)
+	if err != nil {
+		return err
+	}
+
+	counts, err := comm_models.CountCommunicationLogsByStatus(ctx, s.db, requestID)
+	if err != nil {
+		return err
+	}
+
+	inFlight := counts[comm_models.CommunicationStatusCreated] + counts[comm_models.CommunicationStatusRetrying] +
+		counts[comm_models.CommunicationStatusDeferred]
+	if entry.ProcessedBatches < entry.TotalBatches || inFlight > 0 {
+		_, err = models.UpdateNotificationSessionStatus(ctx, s.db, requestID,
+			NotifcationSessionStatusProcessing, NotifcationSessionStatusCreated)
+		return err
+	}
+
+	_, err = models.UpdateNotificationSessionStatus(ctx, s.db, requestID,
+		finalSessionStatus(sessionStats(entry.TotalAudience, counts)),
+		NotifcationSessionStatusCreated, NotifcationSessionStatusProcessing)
+	return err
+}
+
+// RecordDispatch shrinks the session to the batches which were published, a
+// session without any published batch has failed
+func (s *notificationSessionService) RecordDispatch(ctx context.Context, requestID string, publishedBatches int) error {
+	if err := models.UpdateNotificationSessionTotalBatches(ctx, s.db, requestID, publishedBatches); err != nil {
+		return err
+	}
+	if publishedBatches == 0 {
+		_, err := models.UpdateNotificationSessionStatus(ctx, s.db, requestID,
+			NotifcationSessionStatusFailed, NotifcationSessionStatusCreated)
+		return err
+	}
+	return s.Refresh(ctx, requestID)
+}
+
+// Cancel stops a scheduled session from being dispatched
+func (s *notificationSessionService) Cancel(ctx context.Context, requestID string) error {
+	updated, err := models.UpdateNotificationSessionStatus(ctx, s.db, requestID,
+		NotifcationSessionStatusCancelled, NotifcationSessionStatusScheduled)
+	if err != nil {
+		return err
+	}
+	return s.checkScheduled(ctx, requestID, updated)
+}
+
+// Reschedule moves the send time of a session which has not been dispatched yet
+func (s *notificationSessionService) Reschedule(ctx context.Context, requestID string, sendAt time.Time) error {
+	updated, err := models.UpdateNotificationSessionSendAt(ctx, s.db, requestID,
+		NotifcationSessionStatusScheduled, sendAt)
+	if err != nil {
+		return err
+	}
+	return s.checkScheduled(ctx, requestID, updated)
+}
+
+// checkScheduled tells a missing session apart from one which is no longer scheduled
+func (s *notificationSessionService) checkScheduled(ctx context.Context, requestID string, updated int64) error {
+	if updated > 0 {
+		return nil
+	}
+	if _, err := models.GetNotificationSessionByRequestID(ctx, s.db, requestID); err != nil {
+		return err
+	}
+	return ErrSessionNotScheduled
+}
+
+func sessionStats(totalAudience int, counts map[string]int) NotificationSessionStats {
+	stats := NotificationSessionStats{
+		Sent:       counts[comm_models.CommunicationStatusSent],
+		Failed:     counts[comm_models.CommunicationStatusFailed],
+		Suppressed: counts[comm_models.CommunicationStatusSuppressed],
+		Capped:     counts[comm_models.CommunicationStatusCapped],
+		Deferred:   counts[comm_models.CommunicationStatusDeferred],
+	}
+	stats.Pending = totalAudience - stats.Sent - stats.Failed - stats.Suppressed - stats.Capped - stats.Deferred
+	if stats.Pending < 0 {
+		stats.Pending = 0
+	}
+	return stats
+}
+
+// finalSessionStatus reports a session as failed only when every recipient
+// hit a delivery error, recipients held back by suppression or capping are
+// handled as intended. Recipients still pending once final were never published.
+func finalSessionStatus(stats NotificationSessionStats) string {
+	undelivered := stats.Failed + stats.Pending
+	handled := stats.Sent + stats.Suppressed + stats.Capped + stats.Deferred
+	switch {
+	case undelivered == 0:
+		return NotifcationSessionStatusDelivered
+	case handled == 0:
+		return NotifcationSessionStatusFailed
+	default:
+		return NotifcationSessionStatusPartiallyFailed
+	}
+}
@@ -0,0 +1,88 @@
+package notification
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	comm_models "github.com/kp/pager/communicator/models"
+	models "github.com/kp/pager/notification/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestDB opens an in-memory database with the session tables, shared by
+// the connections of the test only
+func newTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open("sqlite3", fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name()))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	require.NoError(t, db.AutoMigrate(
+		&models.NotificationSession{},
+		&models.ProcessedBatch{},
+		&comm_models.CommunicationLogs{},
+	).Error)
+	return db
+}
+
+func TestSessionStats(t *testing.T) {
+	stats := sessionStats(12, map[string]int{
+		comm_models.CommunicationStatusSent:       6,
+		comm_models.CommunicationStatusFailed:     2,
+		comm_models.CommunicationStatusRetrying:   1,
+		comm_models.CommunicationStatusSuppressed: 1,
+		comm_models.CommunicationStatusCapped:     1,
+		comm_models.CommunicationStatusDeferred:   1,
+	})
+	assert.Equal(t, NotificationSessionStats{Sent: 6, Failed: 2, Suppressed: 1, Capped: 1, Deferred: 1, Pending: 1}, stats)
+}
+
+func TestFinalSessionStatus(t *testing.T) {
+	tests := []struct {
+		name  string
+		stats NotificationSessionStats
+		want  string
+	}{
+		{"all sent", NotificationSessionStats{Sent: 5}, NotifcationSessionStatusDelivered},
+		{"some suppressed", NotificationSessionStats{Sent: 3, Suppressed: 2}, NotifcationSessionStatusDelivered},
+		{"some capped", NotificationSessionStats{Sent: 3, Capped: 2}, NotifcationSessionStatusDelivered},
+		{"all failed", NotificationSessionStats{Failed: 5}, NotifcationSessionStatusFailed},
+		{"some failed", NotificationSessionStats{Sent: 3, Failed: 2}, NotifcationSessionStatusPartiallyFailed},
+		{"some never published", NotificationSessionStats{Sent: 3, Pending: 2}, NotifcationSessionStatusPartiallyFailed},
+		{"all suppressed", NotificationSessionStats{Suppressed: 5}, NotifcationSessionStatusDelivered},
+		{"all capped", NotificationSessionStats{Capped: 5}, NotifcationSessionStatusDelivered},
+		{"suppressed and capped", NotificationSessionStats{Suppressed: 2, Capped: 3}, NotifcationSessionStatusDelivered},
+		{"suppressed and failed", NotificationSessionStats{Suppressed: 2, Failed: 3}, NotifcationSessionStatusPartiallyFailed},
+		{"none published", NotificationSessionStats{Pending: 5}, NotifcationSessionStatusFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, finalSessionStatus(tt.stats))
+		})
+	}
+}
+
+func TestMarkBatchProcessed_Redelivered(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	_, err := models.NewNotificationSessionEntry(ctx, db, NotifcationSessionStatusCreated, "req1", 0, 2, 1, 1, "", "")
+	require.NoError(t, err)
+	service := NewNotificationSessionService(db)
+
+	// the queue delivers the first batch twice before the second one
+	require.NoError(t, service.MarkBatchProcessed(ctx, "req1", "batch1"))
+	require.NoError(t, service.MarkBatchProcessed(ctx, "req1", "batch1"))
+	session, err := models.GetNotificationSessionByRequestID(ctx, db, "req1")
+	require.NoError(t, err)
+	assert.Equal(t, 1, session.ProcessedBatches)
+	assert.Equal(t, NotifcationSessionStatusProcessing, session.Status, "a redelivered batch must not finalize the session")
+
+	require.NoError(t, service.MarkBatchProcessed(ctx, "req1", "batch2"))
+	session, err = models.GetNotificationSessionByRequestID(ctx, db, "req1")
+	require.NoError(t, err)
+	assert.Equal(t, 2, session.ProcessedBatches)
+	assert.Equal(t, NotifcationSessionStatusDelivered, session.Status)
+}
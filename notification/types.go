@@ -2,11 +2,13 @@ package notification
 
 import (
 	"context"
+	"io"
 	"time"
 
 	batchprocessor "github.com/kp/pager/batch_processor"
 	"github.com/kp/pager/common"
 	"github.com/kp/pager/databases/kafka"
+	"github.com/kp/pager/templates"
 )
 
 type NotificationRequestType struct {
@@ -16,32 +18,114 @@ type NotificationRequestType struct {
 }
 
 type Notification struct {
-	ID                         int64                 `json:"id"`
-	TemplateID                 int64                 `json:"template_id"`
-	Audiences                  []common.AudienceType `json:"audiences"`
-	CreatedAt                  time.Time             `json:"created_at"`
-	UpdatedAt                  time.Time             `json:"updated_at"`
+	ID                         int64                        `json:"id"`
+	TemplateID                 int64                        `json:"template_id"`
+	Audiences                  []common.AudienceType        `json:"audiences"`
+	SendAt                     *time.Time                   `json:"send_at,omitempty"`
+	RequestID                  string                       `json:"request_id"`
+	Status                     string                       `json:"status"`
+	TemplateVersion            int                          `json:"template_version"`
+	Channel                    string                       `json:"channel,omitempty"`
+	LocalTime                  string                       `json:"local_time,omitempty"`
+	Batches                    []batchprocessor.BatchResult `json:"batches,omitempty"`
+	CreatedAt                  time.Time                    `json:"created_at"`
+	UpdatedAt                  time.Time                    `json:"updated_at"`
 	NotificationSessionService NotificationSessionService
+	TemplateService            templates.TemplateService
 	KafkaProducer              kafka.KafkaProducer
 }
 
 type NotificationService interface {
 	SendNotification(ctx context.Context) (*Notification, error)
+	UploadAudiences(ctx context.Context, format string, body io.Reader) (*UploadResult, error)
+}
+
+// UploadResult is the session created for an audience upload. Rejections
+// lists the first rejected rows, Rejected counts all of them.
+type UploadResult struct {
+	Session          NotificationSession          `json:"session"`
+	Accepted         int                          `json:"accepted"`
+	Rejected         int                          `json:"rejected"`
+	Rejections       []RowRejection               `json:"rejections"`
+	Batches          int                          `json:"batches"`
+	PublishedBatches int                          `json:"published_batches"`
+	FailedBatches    []batchprocessor.BatchResult `json:"failed_batches,omitempty"`
+}
+
+type RowRejection struct {
+	Row    int    `json:"row"`
+	Reason string `json:"reason"`
 }
 
 type NotificationSessionService interface {
 	Create(ctx context.Context, session NotificationSession) (int64, error)
+	GetStatus(ctx context.Context, requestID string, page, pageSize int) (*NotificationStatus, error)
+	MarkBatchProcessed(ctx context.Context, requestID, batchID string) error
+	Refresh(ctx context.Context, requestID string) error
+	Cancel(ctx context.Context, requestID string) error
+	Reschedule(ctx context.Context, requestID string, sendAt time.Time) error
+	RecordDispatch(ctx context.Context, requestID string, publishedBatches int) error
 }
 
 type NotificationSession struct {
-	ID            string    `json:"id"`
-	RequestID     string    `json:"request_id"`
-	Status        string    `json:"status"`
-	TemplateID    int64     `json:"template_id"`
-	TotalAudience int       `json:"total_audience"`
-	TotalSent     int       `json:"total_sent"`
-	TotalSuccess  int       `json:"total_success"`
-	CreatedAt     time.Time `json:"created_at"`
-	UpdatedAt     time.Time `json:"updated_at"`
-	batchprocessor.BatchProcessor
+	ID               string                `json:"id"`
+	RequestID        string                `json:"request_id"`
+	Status           string                `json:"status"`
+	FailureReason    string                `json:"failure_reason,omitempty"`
+	TemplateID       int64                 `json:"template_id"`
+	TemplateVersion  int                   `json:"template_version"`
+	Channel          string                `json:"channel,omitempty"`
+	LocalTime        string                `json:"local_time,omitempty"`
+	TotalAudience    int                   `json:"total_audience"`
+	TotalBatches     int                   `json:"total_batches"`
+	ProcessedBatches int                   `json:"processed_batches"`
+	TotalSent        int                   `json:"total_sent"`
+	TotalSuccess     int                   `json:"total_success"`
+	SendAt           *time.Time            `json:"send_at,omitempty"`
+	Audiences        []common.AudienceType `json:"-"`
+	CreatedAt        time.Time             `json:"created_at"`
+	UpdatedAt        time.Time             `json:"updated_at"`
+
+	batchprocessor.BatchProcessor `json:"-"`
+}
+
+// NotificationSessionStats counts the recipients of a session by outcome.
+// Suppressed, capped and deferred recipients were held back on purpose, only
+// Failed and, once the session is final, Pending recipients are errors.
+type NotificationSessionStats struct {
+	Sent       int `json:"sent"`
+	Failed     int `json:"failed"`
+	Suppressed int `json:"suppressed"`
+	Capped     int `json:"capped"`
+	Deferred   int `json:"deferred"`
+	Pending    int `json:"pending"`
+}
+
+type RecipientStatus struct {
+	ID        int64     `json:"id"`
+	Email     string    `json:"email"`
+	Channel   string    `json:"channel"`
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	Attempts  int       `json:"attempts"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// SchedulerConfig configures how often scheduled sessions are polled,
+// e.g. SCHEDULER_INTERVAL=10s
+type SchedulerConfig struct {
+	Interval string `json:"SCHEDULER_INTERVAL"`
+}
+
+type RescheduleRequest struct {
+	SendAt time.Time `json:"send_at" binding:"required"`
+}
+
+type NotificationStatus struct {
+	Session         NotificationSession      `json:"session"`
+	Stats           NotificationSessionStats `json:"stats"`
+	Recipients      []RecipientStatus        `json:"recipients"`
+	Page            int                      `json:"page"`
+	PageSize        int                      `json:"page_size"`
+	TotalRecipients int                      `json:"total_recipients"`
 }
@@ -0,0 +1,325 @@
+package notification
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/mail"
+	"strings"
+	"time"
+
+	batchprocessor "github.com/kp/pager/batch_processor"
+	"github.com/kp/pager/common"
+	"github.com/kp/pager/communicator"
+	"github.com/kp/pager/databases/kafka"
+	models "github.com/kp/pager/notification/models"
+	"github.com/kp/pager/templates"
+)
+
+// Formats of an audience upload
+const (
+	UploadFormatCSV    = "csv"
+	UploadFormatNDJSON = "ndjson"
+)
+
+const (
+	// maxUploadRejections is how many rejected rows an upload reports individually
+	maxUploadRejections = 1000
+	// maxNDJSONLine is the longest audience an NDJSON upload may contain
+	maxNDJSONLine = 1 << 20
+	// uploadFilterBits sizes the duplicate filter of an upload, its 16MiB keep
+	// false positives below 0.2% up to 10M recipients
+	uploadFilterBits = 1 << 27
+	// uploadFilterHashes is the number of bits set per recipient
+	uploadFilterHashes = 7
+)
+
+// ErrInvalidUpload is returned for an upload which cannot be read at all,
+// such as a CSV upload without an address column
+var ErrInvalidUpload = errors.New("invalid audience upload")
+
+// audienceReader reads the audiences of an upload one row at a time
+type audienceReader interface {
+	// Next returns the next audience, io.EOF after the last one. A *rowError
+	// rejects only its row, any other error ends the upload.
+	Next() (common.AudienceType, error)
+}
+
+type rowError struct {
+	reason string
+}
+
+func (e *rowError) Error() string {
+	return e.reason
+}
+
+// newAudienceReader returns the reader of body for format
+func newAudienceReader(format string, body io.Reader) (audienceReader, error) {
+	switch format {
+	case UploadFormatCSV:
+		return newCSVAudienceReader(body)
+	case UploadFormatNDJSON:
+		scanner := bufio.NewScanner(body)
+		scanner.Buffer(make([]byte, 0, 64*1024), maxNDJSONLine)
+		return &ndjsonAudienceReader{scanner: scanner}, nil
+	default:
+		return nil, fmt.Errorf("%w: unsupported format %q", ErrInvalidUpload, format)
+	}
+}
+
+// csvAudienceReader reads a CSV upload whose header names the columns. The
+// email, phone, device_token and user_id columns address the audience, the
+// other columns are context keys.
+type csvAudienceReader struct {
+	reader *csv.Reader
+	header []string
+}
+
+func newCSVAudienceReader(body io.Reader) (*csvAudienceReader, error) {
+	reader := csv.NewReader(body)
+	reader.ReuseRecord = true
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("%w: the csv has no header", ErrInvalidUpload)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: unreadable csv header: %v", ErrInvalidUpload, err)
+	}
+	columns := make([]string, len(header))
+	seen := map[string]bool{}
+	for i, column := range header {
+		column = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(column, "\ufeff")))
+		if column == "" || seen[column] {
+			return nil, fmt.Errorf("%w: empty or duplicate csv column %q", ErrInvalidUpload, header[i])
+		}
+		seen[column] = true
+		columns[i] = column
+	}
+	if !seen["email"] && !seen["phone"] && !seen["device_token"] && !seen["user_id"] {
+		return nil, fmt.Errorf("%w: the csv needs an email, phone, device_token or user_id column", ErrInvalidUpload)
+	}
+	return &csvAudienceReader{reader: reader, header: columns}, nil
+}
+
+func (r *csvAudienceReader) Next() (common.AudienceType, error) {
+	record, err := r.reader.Read()
+	if err != nil {
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return common.AudienceType{}, &rowError{reason: parseErr.Err.Error()}
+		}
+		return common.AudienceType{}, err
+	}
+
+	audience := common.AudienceType{Context: map[string]string{}}
+	for i, value := range record {
+		value = strings.TrimSpace(value)
+		switch r.header[i] {
+		case "email":
+			audience.Email = value
+		case "phone":
+			audience.Phone = value
+		case "device_token":
+			audience.DeviceToken = value
+		case "user_id":
+			audience.UserID = value
+		default:
+			if value != "" {
+				audience.Context[r.header[i]] = value
+			}
+		}
+	}
+	return audience, nil
+}
+
+// ndjsonAudienceReader reads one audience object per line, blank lines are skipped
+type ndjsonAudienceReader struct {
+	scanner *bufio.Scanner
+}
+
+func (r *ndjsonAudienceReader) Next() (common.AudienceType, error) {
+	for r.scanner.Scan() {
+		line := strings.TrimSpace(r.scanner.Text())
+		if line == "" {
+			continue
+		}
+		var audience common.AudienceType
+		if err := json.Unmarshal([]byte(line), &audience); err != nil {
+			return common.AudienceType{}, &rowError{reason: fmt.Sprintf("invalid json: %v", err)}
+		}
+		return audience, nil
+	}
+	if err := r.scanner.Err(); err != nil {
+		return common.AudienceType{}, err
+	}
+	return common.AudienceType{}, io.EOF
+}
+
+// validateAudience returns why audience cannot be notified on channel, any
+// channel when empty, or an empty string when it can
+func validateAudience(audience common.AudienceType, channel string) string {
+	audience.Email = strings.TrimSpace(audience.Email)
+	if audience.Email != "" {
+		address, err := mail.ParseAddress(audience.Email)
+		if err != nil || address.Address != audience.Email {
+			return fmt.Sprintf("invalid email %q", audience.Email)
+		}
+	}
+	if channel != "" {
+		if audience.Address(channel) == "" {
+			return fmt.Sprintf("no %s address", channel)
+		}
+		return ""
+	}
+	if audience.Email == "" && audience.Phone == "" && audience.DeviceToken == "" && audience.UserID == "" {
+		return "no address"
+	}
+	return ""
+}
+
+// audienceKey hashes the addresses of audience, uploads remember recipients by
+// this hash to deduplicate them. The unique communication log of a recipient
+// still prevents a double send should two hashes collide.
+func audienceKey(audience common.AudienceType) uint64 {
+	hash := fnv.New64a()
+	for _, address := range []string{strings.ToLower(audience.Email), audience.Phone, audience.DeviceToken, audience.UserID} {
+		hash.Write([]byte(address))
+		hash.Write([]byte{0})
+	}
+	return hash.Sum64()
+}
+
+// audienceFilter is a Bloom filter remembering the recipients of an upload in
+// fixed memory however many rows it has. It may take a recipient it never saw
+// for a duplicate, the rejected row can then be uploaded again on its own.
+type audienceFilter struct {
+	bits []uint64
+}
+
+func newAudienceFilter(bits int) *audienceFilter {
+	return &audienceFilter{bits: make([]uint64, (bits+63)/64)}
+}
+
+// add records key and reports whether it was probably added before
+func (f *audienceFilter) add(key uint64) bool {
+	size := uint64(len(f.bits)) * 64
+	// double hashing derives every bit from the two halves of key
+	h1, h2 := key&0xffffffff, key>>32
+	seen := true
+	for i := uint64(0); i < uploadFilterHashes; i++ {
+		bit := (h1 + i*h2) % size
+		word, mask := bit/64, uint64(1)<<(bit%64)
+		if f.bits[word]&mask == 0 {
+			seen = false
+			f.bits[word] |= mask
+		}
+	}
+	return seen
+}
+
+// UploadAudiences creates a session for the template and publishes the
+// audiences read from body in batches while they are read. Invalid and
+// duplicate rows are rejected, rows are numbered from 1 without the CSV header.
+func (c *Notification) UploadAudiences(ctx context.Context, format string, body io.Reader) (*UploadResult, error) {
+	template, err := c.TemplateService.GetTemplate(ctx, c.TemplateID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get template %d: %w", c.TemplateID, err)
+	}
+	if c.Channel != "" && !template.Supports(c.Channel) {
+		return nil, fmt.Errorf("%w: template %d has no %s content", templates.ErrUnsupportedChannel, c.TemplateID, c.Channel)
+	}
+	reader, err := newAudienceReader(format, body)
+	if err != nil {
+		return nil, err
+	}
+
+	// the session waits in uploading until all batches are published, so
+	// consumers cannot complete it early
+	session := NotificationSession{
+		RequestID:       generateUniqueID(),
+		Status:          NotifcationSessionStatusUploading,
+		TemplateID:      c.TemplateID,
+		TemplateVersion: template.Version,
+		Channel:         c.Channel,
+		LocalTime:       c.LocalTime,
+	}
+	sessionID, err := c.NotificationSessionService.Create(ctx, session)
+	if err != nil {
+		return nil, err
+	}
+
+	stream := batchprocessor.NewStreamBatchProcessor(communicator.NotificationType{
+		TemplateID:      c.TemplateID,
+		TemplateVersion: template.Version,
+		Channel:         c.Channel,
+		Channels:        template.Channels(),
+		Category:        template.Category,
+		LocalTime:       c.LocalTime,
+		DispatchedAt:    time.Now(),
+		SessionID:       sessionID,
+		RequestId:       session.RequestID,
+	}, kafka.NotificationBatchTopic, c.KafkaProducer)
+
+	result := &UploadResult{Rejections: []RowRejection{}}
+	reject := func(row int, reason string) {
+		result.Rejected++
+		if len(result.Rejections) < maxUploadRejections {
+			result.Rejections = append(result.Rejections, RowRejection{Row: row, Reason: reason})
+		}
+	}
+	seen := newAudienceFilter(uploadFilterBits)
+	var readErr error
+	for row := 1; ; row++ {
+		audience, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		var invalidRow *rowError
+		if errors.As(err, &invalidRow) {
+			reject(row, invalidRow.reason)
+			continue
+		}
+		if err != nil {
+			readErr = err
+			break
+		}
+		if reason := validateAudience(audience, c.Channel); reason != "" {
+			reject(row, reason)
+			continue
+		}
+		if seen.add(audienceKey(audience)) {
+			reject(row, "duplicate recipient")
+			continue
+		}
+		stream.Add(ctx, audience)
+		result.Accepted++
+	}
+
+	publishErr := stream.Close(ctx)
+	totalBatches, published := stream.Batches()
+	result.Batches = totalBatches
+	result.PublishedBatches = published
+	result.FailedBatches = stream.Failures()
+	if err := models.UpdateNotificationSessionAudience(ctx, nil, session.RequestID, result.Accepted, totalBatches,
+		NotifcationSessionStatusCreated, NotifcationSessionStatusUploading); err != nil {
+		return nil, errors.Join(readErr, publishErr, err)
+	}
+	if err := c.NotificationSessionService.RecordDispatch(ctx, session.RequestID, published); err != nil {
+		return nil, errors.Join(readErr, publishErr, err)
+	}
+
+	status, err := c.NotificationSessionService.GetStatus(ctx, session.RequestID, 1, 1)
+	if err != nil {
+		return nil, err
+	}
+	result.Session = status.Session
+	if readErr != nil {
+		return result, fmt.Errorf("upload stopped at row %d: %w", result.Accepted+result.Rejected+1, readErr)
+	}
+	return result, publishErr
+}
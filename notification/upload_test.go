@@ -0,0 +1,125 @@
+package notification
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/kp/pager/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readAll(t *testing.T, reader audienceReader) ([]common.AudienceType, []string) {
+	t.Helper()
+	var audiences []common.AudienceType
+	var rejections []string
+	for {
+		audience, err := reader.Next()
+		if err == io.EOF {
+			return audiences, rejections
+		}
+		if err != nil {
+			var rowErr *rowError
+			require.ErrorAs(t, err, &rowErr)
+			rejections = append(rejections, rowErr.reason)
+			continue
+		}
+		audiences = append(audiences, audience)
+	}
+}
+
+func TestCSVAudienceReader(t *testing.T) {
+	body := "\ufeffEmail, first_name ,plan\nada@example.com,Ada,pro\ngrace@example.com,,\n\"broken,row\n"
+	reader, err := newAudienceReader(UploadFormatCSV, strings.NewReader(body))
+	require.NoError(t, err)
+
+	audiences, rejections := readAll(t, reader)
+	require.Len(t, audiences, 2)
+	assert.Equal(t, "ada@example.com", audiences[0].Email)
+	assert.Equal(t, map[string]string{"first_name": "Ada", "plan": "pro"}, audiences[0].Context)
+	assert.Equal(t, "grace@example.com", audiences[1].Email)
+	assert.Empty(t, audiences[1].Context)
+	assert.Len(t, rejections, 1)
+}
+
+func TestCSVAudienceReader_InvalidHeader(t *testing.T) {
+	for name, body := range map[string]string{
+		"empty":      "",
+		"duplicate":  "email,email\n",
+		"no address": "first_name,plan\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := newAudienceReader(UploadFormatCSV, strings.NewReader(body))
+			assert.ErrorIs(t, err, ErrInvalidUpload)
+		})
+	}
+}
+
+func TestNDJSONAudienceReader(t *testing.T) {
+	body := `{"email":"ada@example.com","context":{"plan":"pro"}}
+
+not json
+{"phone":"+15550100"}
+`
+	reader, err := newAudienceReader(UploadFormatNDJSON, strings.NewReader(body))
+	require.NoError(t, err)
+
+	audiences, rejections := readAll(t, reader)
+	require.Len(t, audiences, 2)
+	assert.Equal(t, "ada@example.com", audiences[0].Email)
+	assert.Equal(t, "pro", audiences[0].Context["plan"])
+	assert.Equal(t, "+15550100", audiences[1].Phone)
+	assert.Len(t, rejections, 1)
+}
+
+func TestNewAudienceReader_UnsupportedFormat(t *testing.T) {
+	_, err := newAudienceReader("xml", strings.NewReader(""))
+	assert.ErrorIs(t, err, ErrInvalidUpload)
+}
+
+func TestValidateAudience(t *testing.T) {
+	tests := []struct {
+		name     string
+		audience common.AudienceType
+		channel  string
+		valid    bool
+	}{
+		{"email", common.AudienceType{Email: "ada@example.com"}, "", true},
+		{"invalid email", common.AudienceType{Email: "Ada <ada@example.com>"}, "", false},
+		{"no address", common.AudienceType{}, "", false},
+		{"phone for sms", common.AudienceType{Phone: "+15550100"}, common.ChannelSMS, true},
+		{"email for sms", common.AudienceType{Email: "ada@example.com"}, common.ChannelSMS, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.valid, validateAudience(tt.audience, tt.channel) == "")
+		})
+	}
+}
+
+func TestAudienceFilter(t *testing.T) {
+	filter := newAudienceFilter(1 << 20)
+	assert.False(t, filter.add(audienceKey(common.AudienceType{Email: "ada@example.com"})))
+	assert.True(t, filter.add(audienceKey(common.AudienceType{Email: "Ada@Example.com"})))
+	assert.False(t, filter.add(audienceKey(common.AudienceType{Email: "grace@example.com"})))
+
+	falsePositives := 0
+	for i := 0; i < 50000; i++ {
+		if filter.add(audienceKey(common.AudienceType{Email: fmt.Sprintf("user%d@example.com", i)})) {
+			falsePositives++
+		}
+	}
+	assert.Less(t, falsePositives, 50, "false positives stay rare while the filter is sized for the upload")
+	assert.Len(t, filter.bits, 1<<14, "the filter does not grow with the recipients")
+}
+
+func TestAudienceKey(t *testing.T) {
+	assert.Equal(t,
+		audienceKey(common.AudienceType{Email: "Ada@Example.com"}),
+		audienceKey(common.AudienceType{Email: "ada@example.com", Context: map[string]string{"plan": "pro"}}))
+	assert.NotEqual(t,
+		audienceKey(common.AudienceType{Email: "ada@example.com"}),
+		audienceKey(common.AudienceType{Phone: "ada@example.com"}))
+}
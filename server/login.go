@@ -15,20 +15,66 @@ func AuthRouterGroup(servicePrefix string, db *gorm.DB, middlewares ...gin.Handl
 		Middlewares: middlewares}
 }
 
+// WellKnownRouterGroup serves the public documents under /.well-known
+func WellKnownRouterGroup(servicePrefix string, middlewares ...gin.HandlerFunc) RouterGroup {
+	return RouterGroup{
+		Prefix: servicePrefix,
+		Routes: []Route{
+			newRoute(http.MethodGet, "/jwks.json", login.JWKS, servicePrefix),
+		},
+		Middlewares: middlewares}
+}
+
+// APIKeyRouterGroup serves the admin APIs managing the API keys of services
+func APIKeyRouterGroup(servicePrefix string, db *gorm.DB, middlewares ...gin.HandlerFunc) RouterGroup {
+	apiKeyCtrl := login.NewAPIKeyController(login.NewAPIKeyService(login.NewAPIKeyRepository(db)))
+	return RouterGroup{
+		Prefix: servicePrefix,
+		Routes: []Route{
+			newRoute(http.MethodPost, "/", apiKeyCtrl.CreateAPIKey, servicePrefix, login.PagerAdminAccess),
+			newRoute(http.MethodGet, "/", apiKeyCtrl.ListAPIKeys, servicePrefix, login.PagerAdminAccess),
+			newRoute(http.MethodDelete, "/:id/", apiKeyCtrl.RevokeAPIKey, servicePrefix, login.PagerAdminAccess),
+		},
+		Middlewares: middlewares}
+}
+
+// RoleRouterGroup serves the admin APIs managing roles and their members
+func RoleRouterGroup(servicePrefix string, db *gorm.DB, middlewares ...gin.HandlerFunc) RouterGroup {
+	roleService := login.NewRoleService(login.NewRoleRepository(db), login.NewPermissionRepository(db), login.NewUserRepository(db))
+	roleCtrl := login.NewRoleController(roleService)
+	return RouterGroup{
+		Prefix: servicePrefix,
+		Routes: []Route{
+			newRoute(http.MethodPost, "/", roleCtrl.CreateRole, servicePrefix, login.PagerAdminAccess),
+			newRoute(http.MethodGet, "/", roleCtrl.ListRoles, servicePrefix, login.PagerAdminAccess),
+			newRoute(http.MethodGet, "/:id/", roleCtrl.GetRole, servicePrefix, login.PagerAdminAccess),
+			newRoute(http.MethodPut, "/:id/", roleCtrl.UpdateRole, servicePrefix, login.PagerAdminAccess),
+			newRoute(http.MethodDelete, "/:id/", roleCtrl.DeleteRole, servicePrefix, login.PagerAdminAccess),
+			newRoute(http.MethodGet, "/:id/users/", roleCtrl.GetRoleMembers, servicePrefix, login.PagerAdminAccess),
+			newRoute(http.MethodPost, "/:id/users/", roleCtrl.AssignRole, servicePrefix, login.PagerAdminAccess),
+			newRoute(http.MethodDelete, "/:id/users/:user_id/", roleCtrl.UnassignRole, servicePrefix, login.PagerAdminAccess),
+		},
+		Middlewares: middlewares}
+}
+
 func authRoutes(db *gorm.DB, prefix string) []Route {
 	// Initialize repositories
 	userRepo := login.NewUserRepository(db)
 	permRepo := login.NewPermissionRepository(db)
 	userPermRepo := login.NewUserPermissionRepository(db)
+	refreshTokenRepo := login.NewRefreshTokenRepository(db)
+	roleRepo := login.NewRoleRepository(db)
 
 	// Initialize services
-	authService := login.NewAuthService(userRepo, permRepo, userPermRepo)
+	authService := login.NewAuthService(userRepo, permRepo, userPermRepo, refreshTokenRepo, roleRepo)
 
 	// Initialize controllers
 	authCtrl := login.NewAuthController(authService)
 
 	return []Route{
 		newRoute(http.MethodPost, "/login/", authCtrl.Login, prefix),
+		newRoute(http.MethodPost, "/refresh/", authCtrl.Refresh, prefix),
+		newRoute(http.MethodPost, "/logout/", authCtrl.Logout, prefix),
 		newRoute(http.MethodPost, "/register/", authCtrl.Register, prefix, login.PagerAdminAccess),
 		newRoute(http.MethodPost, "/permissions/", authCtrl.AddPermission, prefix, login.PagerAdminAccess),
 		newRoute(http.MethodGet, "/permissions/user/:user_id/", authCtrl.GetPermissions, prefix, login.PagerAdminAccess),
@@ -1,21 +1,35 @@
 package server
 
 import (
+	"errors"
 	"log/slog"
 	"net/http"
 	"runtime/debug"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jinzhu/gorm"
 	"github.com/kp/pager/login"
+	"github.com/kp/pager/metrics"
+	"github.com/kp/pager/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // AuthPermissionMiddleware checks for valid auth token and required permissions
 // This middleware ensures that if any auth or permission check fails,
 // the request is aborted and the API handler is not called
 func AuthPermissionMiddleware(db *gorm.DB) gin.HandlerFunc {
+	return AuthPermissionMiddlewareWithAPIKeys(login.NewAPIKeyService(login.NewAPIKeyRepository(db)))
+}
+
+// AuthPermissionMiddlewareWithAPIKeys is AuthPermissionMiddleware checking the
+// X-API-Key header with apiKeys
+func AuthPermissionMiddlewareWithAPIKeys(apiKeys login.APIKeyAuthenticator) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// First check if this endpoint has any required permissions
 		requiredPerms, exists := GetCachedPermissions(c.Request.Method, c.FullPath())
@@ -26,17 +40,48 @@ func AuthPermissionMiddleware(db *gorm.DB) gin.HandlerFunc {
 		}
 
 		// Step 1: Check authentication (only if permissions are required)
-		authHeader := c.GetHeader("X-Auth-Token")
-		if authHeader == "" {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
-			return
-		}
+		var claims *login.Claims
+		if apiKey := c.GetHeader(login.APIKeyHeader); apiKey != "" {
+			// services authenticate with an API key instead of a user token
+			var err error
+			claims, err = apiKeys.Authenticate(c.Request.Context(), apiKey, c.ClientIP())
+			if errors.Is(err, login.ErrAPIKeyIPNotAllowed) {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Api key not allowed from this address"})
+				return
+			}
+			if errors.Is(err, login.ErrInvalidAPIKey) {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid api key"})
+				return
+			}
+			if err != nil {
+				slog.Error("authPermissionMiddleware:unableToCheckApiKey", slog.Any("error", err))
+				c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "Unable to verify api key"})
+				return
+			}
+		} else {
+			authHeader := c.GetHeader("X-Auth-Token")
+			if authHeader == "" {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
+				return
+			}
 
-		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-		claims, err := login.ValidateToken(tokenString)
-		if err != nil {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
-			return
+			tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+			var err error
+			claims, err = login.ValidateToken(tokenString)
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+				return
+			}
+			revoked, err := login.IsRevoked(c.Request.Context(), claims)
+			if err != nil {
+				slog.Error("authPermissionMiddleware:unableToCheckRevocation", slog.Any("error", err))
+				c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "Unable to verify token"})
+				return
+			}
+			if revoked {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Token revoked"})
+				return
+			}
 		}
 
 		// Add claims to context
@@ -82,6 +127,51 @@ func AuthPermissionMiddleware(db *gorm.DB) gin.HandlerFunc {
 	}
 }
 
+// MetricsMiddleware records the count and latency of every request by route
+func MetricsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		status := strconv.Itoa(c.Writer.Status())
+		metrics.HTTPRequests.WithLabelValues(c.Request.Method, route, status).Inc()
+		metrics.HTTPRequestDuration.WithLabelValues(c.Request.Method, route, status).Observe(time.Since(start).Seconds())
+	}
+}
+
+// TracingMiddleware starts a server span per request, continuing the trace of
+// the caller when it sends a traceparent header
+func TracingMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		ctx := tracing.Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+		ctx, span := tracing.Tracer().Start(ctx, c.Request.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", c.Request.Method),
+				attribute.String("http.route", route),
+				attribute.String("http.request_id", c.Request.Header.Get("X-Request-Id")),
+			))
+		defer span.End()
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	}
+}
+
 // RecoveryMiddleware handles panics and recovers gracefully
 func RecoveryMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -10,21 +10,30 @@ import (
 )
 
 func NotificationRouterGroup(servicePrefix string, brokers []string, middlewares ...gin.HandlerFunc) RouterGroup {
+	kafkaProducer, err := kafka.NewKafkaProducer(brokers)
+	if err != nil {
+		panic(err)
+	}
+	return NotificationRouterGroupWithProducer(servicePrefix, kafkaProducer, middlewares...)
+}
+
+// NotificationRouterGroupWithProducer is NotificationRouterGroup with a producer
+// shared with the rest of the process
+func NotificationRouterGroupWithProducer(servicePrefix string, kafkaProducer kafka.KafkaProducer, middlewares ...gin.HandlerFunc) RouterGroup {
 	return RouterGroup{
 		Prefix:      servicePrefix,
-		Routes:      notificationRoutes(servicePrefix, brokers),
+		Routes:      notificationRoutes(servicePrefix, kafkaProducer),
 		Middlewares: middlewares}
 }
 
-func notificationRoutes(prefix string, brokers []string) []Route {
+func notificationRoutes(prefix string, kafkaProducer kafka.KafkaProducer) []Route {
 	// Initialize controllers
-
-	kafkaProducer, err := kafka.NewKafkaProducer(brokers)
-	if err != nil {
-		panic(err)
-	}
 	notificationCtrl := notification.NewNotificationController(kafkaProducer)
 	return []Route{
-		newRoute(http.MethodPost, "/trigger/", notificationCtrl.SendNotification, prefix, login.PagerAdminAccess),
+		newRoute(http.MethodPost, "/trigger/", notificationCtrl.SendNotification, prefix, login.PagerSendAccess),
+		newRoute(http.MethodPost, "/upload/", notificationCtrl.UploadAudiences, prefix, login.PagerSendAccess),
+		newRoute(http.MethodGet, "/:request_id/", notificationCtrl.GetNotificationStatus, prefix, login.PagerNotifcationAccess),
+		newRoute(http.MethodPost, "/:request_id/cancel/", notificationCtrl.CancelNotification, prefix, login.PagerAdminAccess),
+		newRoute(http.MethodPost, "/:request_id/reschedule/", notificationCtrl.RescheduleNotification, prefix, login.PagerAdminAccess),
 	}
 }
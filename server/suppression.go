@@ -0,0 +1,35 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	login "github.com/kp/pager/login"
+	"github.com/kp/pager/suppression"
+)
+
+// SuppressionRouterGroup serves the admin APIs managing suppressed addresses
+func SuppressionRouterGroup(servicePrefix string, db *gorm.DB, middlewares ...gin.HandlerFunc) RouterGroup {
+	suppressionCtrl := suppression.NewSuppressionController(suppression.NewSuppressionService(db))
+	return RouterGroup{
+		Prefix: servicePrefix,
+		Routes: []Route{
+			newRoute(http.MethodPost, "/", suppressionCtrl.AddSuppression, servicePrefix, login.PagerAdminAccess),
+			newRoute(http.MethodGet, "/", suppressionCtrl.ListSuppressions, servicePrefix, login.PagerAdminAccess),
+			newRoute(http.MethodDelete, "/:id/", suppressionCtrl.RemoveSuppression, servicePrefix, login.PagerAdminAccess),
+		},
+		Middlewares: middlewares}
+}
+
+// UnsubscribeRouterGroup serves the public unsubscribe links sent to recipients
+func UnsubscribeRouterGroup(servicePrefix string, db *gorm.DB, middlewares ...gin.HandlerFunc) RouterGroup {
+	suppressionCtrl := suppression.NewSuppressionController(suppression.NewSuppressionService(db))
+	return RouterGroup{
+		Prefix: servicePrefix,
+		Routes: []Route{
+			newRoute(http.MethodGet, "/", suppressionCtrl.UnsubscribePage, servicePrefix),
+			newRoute(http.MethodPost, "/", suppressionCtrl.Unsubscribe, servicePrefix),
+		},
+		Middlewares: middlewares}
+}
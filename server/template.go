@@ -26,6 +26,14 @@ func templateRoutes(db *gorm.DB, prefix string) []Route {
 		newRoute(http.MethodPost, "/", templateCtrl.CreateTemplate, prefix),
 		newRoute(http.MethodPut, "/:id/", templateCtrl.UpdateTemplate, prefix),
 		newRoute(http.MethodGet, "/:id/", templateCtrl.GetTemplate, prefix),
+		newRoute(http.MethodGet, "/:id/versions/", templateCtrl.ListTemplateVersions, prefix),
+		newRoute(http.MethodGet, "/:id/versions/:version/", templateCtrl.GetTemplateVersion, prefix),
+		newRoute(http.MethodGet, "/:id/diff/", templateCtrl.DiffTemplateVersions, prefix),
+		newRoute(http.MethodPost, "/:id/rollback/", templateCtrl.RollbackTemplate, prefix),
+		newRoute(http.MethodPost, "/:id/preview/", templateCtrl.PreviewTemplate, prefix),
+		newRoute(http.MethodGet, "/:id/variants/", templateCtrl.ListTemplateVariants, prefix),
+		newRoute(http.MethodPut, "/:id/variants/:locale/", templateCtrl.PutTemplateVariant, prefix),
+		newRoute(http.MethodDelete, "/:id/variants/:locale/", templateCtrl.DeleteTemplateVariant, prefix),
 		newRoute(http.MethodGet, "/", templateCtrl.GetAllTemplates, prefix),
 	}
 }
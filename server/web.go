@@ -10,6 +10,7 @@ import (
 
 	"github.com/gin-contrib/timeout"
 	"github.com/gin-gonic/gin"
+	"github.com/kp/pager/metrics"
 )
 
 // intializes common settings for each service
@@ -71,6 +72,16 @@ func WithRoutes(routes []Route) RouterOpts {
 	}
 }
 
+// WithTrustedProxies sets the proxies whose X-Forwarded-For header gives the
+// client address, none when empty so clients cannot spoof their address
+func WithTrustedProxies(proxies []string) ServerOpts {
+	return func(e *gin.Engine) {
+		if err := e.SetTrustedProxies(proxies); err != nil {
+			panic(err)
+		}
+	}
+}
+
 func WithTimeOut(duration time.Duration) ServerOpts {
 	return func(e *gin.Engine) {
 		defaultServerTimeout = duration
@@ -89,6 +100,7 @@ func InitServer(commonMiddlewares []gin.HandlerFunc, opts ...ServerOpts) *gin.En
 	r.GET("/health/", func(c *gin.Context) {
 		c.JSON(http.StatusOK, nil)
 	})
+	r.GET("/metrics", gin.WrapH(metrics.Handler()))
 
 	defaultMiddlewares = append(commonMiddlewares, defaultMiddlewares...)
 	for _, opt := range opts {
@@ -0,0 +1,186 @@
+package suppression
+
+import (
+	"errors"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	defaultListLimit = 50
+	maxListLimit     = 500
+)
+
+// unsubscribePage confirms the unsubscribe with a POST so link scanners
+// following the GET do not unsubscribe recipients
+var unsubscribePage = template.Must(template.New("unsubscribe").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Unsubscribe</title></head>
+<body>
+{{if .Done}}<p>{{.Address}} has been unsubscribed.</p>
+{{else}}<form method="post">
+<input type="hidden" name="token" value="{{.Token}}">
+<p>Stop sending {{if ne .Category "all"}}{{.Category}} {{end}}notifications to {{.Address}}?</p>
+<button type="submit">Unsubscribe</button>
+</form>
+{{end}}</body>
+</html>
+`))
+
+type unsubscribePageData struct {
+	Token    string
+	Address  string
+	Category string
+	Done     bool
+}
+
+type SuppressionController struct {
+	suppressionService SuppressionService
+}
+
+func NewSuppressionController(suppressionService SuppressionService) *SuppressionController {
+	return &SuppressionController{
+		suppressionService: suppressionService,
+	}
+}
+
+func (c *SuppressionController) AddSuppression(ctx *gin.Context) {
+	var request SuppressionRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		slog.Error("addSuppressionView:unableToBindJSON", slog.Any("error", err))
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	reason := request.Reason
+	if reason == "" {
+		reason = ReasonAdmin
+	}
+
+	entry, err := c.suppressionService.Suppress(ctx.Request.Context(), request.Address, request.Category, reason)
+	if err != nil {
+		slog.Error("addSuppressionView:unableToSuppress", slog.Any("error", err))
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error":  err.Error(),
+			"status": false,
+			"msg":    err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{
+		"error":  nil,
+		"status": true,
+		"msg":    "Suppression added successfully",
+		"data":   entry,
+	})
+}
+
+func (c *SuppressionController) RemoveSuppression(ctx *gin.Context) {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid suppression ID"})
+		return
+	}
+
+	if err := c.suppressionService.Remove(ctx.Request.Context(), id); err != nil {
+		status := http.StatusInternalServerError
+		if gorm.IsRecordNotFoundError(err) {
+			status = http.StatusNotFound
+		} else {
+			slog.Error("removeSuppressionView:unableToRemove",
+				slog.Int64("suppression_id", id),
+				slog.Any("error", err))
+		}
+		ctx.JSON(status, gin.H{
+			"error":  err.Error(),
+			"status": false,
+			"msg":    err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"error":  nil,
+		"status": true,
+		"msg":    "Suppression removed successfully",
+	})
+}
+
+func (c *SuppressionController) ListSuppressions(ctx *gin.Context) {
+	page, err := strconv.Atoi(ctx.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid page"})
+		return
+	}
+	pageSize, err := strconv.Atoi(ctx.DefaultQuery("page_size", strconv.Itoa(defaultListLimit)))
+	if err != nil || pageSize < 1 || pageSize > maxListLimit {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid page_size"})
+		return
+	}
+
+	entries, err := c.suppressionService.List(ctx.Request.Context(), ctx.Query("address"), pageSize, (page-1)*pageSize)
+	if err != nil {
+		slog.Error("listSuppressionsView:unableToList", slog.Any("error", err))
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error":  err.Error(),
+			"status": false,
+			"msg":    err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"error":  nil,
+		"status": true,
+		"msg":    "Suppressions retrieved successfully",
+		"data":   entries,
+	})
+}
+
+// UnsubscribePage shows the recipient of an unsubscribe link what will be unsubscribed
+func (c *SuppressionController) UnsubscribePage(ctx *gin.Context) {
+	token := ctx.Query("token")
+	address, category, err := TokenSubject(token)
+	if err != nil {
+		ctx.String(http.StatusBadRequest, "Invalid unsubscribe link")
+		return
+	}
+	c.renderPage(ctx, http.StatusOK, unsubscribePageData{Token: token, Address: address, Category: category})
+}
+
+// Unsubscribe suppresses the recipient of an unsubscribe link. It serves both
+// the confirmation form and RFC 8058 one-click requests, which post the token
+// from the query string.
+func (c *SuppressionController) Unsubscribe(ctx *gin.Context) {
+	token := ctx.PostForm("token")
+	if token == "" {
+		token = ctx.Query("token")
+	}
+	entry, err := c.suppressionService.Unsubscribe(ctx.Request.Context(), token)
+	if errors.Is(err, ErrInvalidToken) {
+		ctx.String(http.StatusBadRequest, "Invalid unsubscribe link")
+		return
+	}
+	if err != nil {
+		slog.Error("unsubscribeView:unableToUnsubscribe", slog.Any("error", err))
+		ctx.String(http.StatusInternalServerError, "Unable to unsubscribe, please try again later")
+		return
+	}
+	slog.Info("unsubscribeView:unsubscribed",
+		slog.String("address", entry.Address),
+		slog.String("category", entry.Category))
+	c.renderPage(ctx, http.StatusOK, unsubscribePageData{Address: entry.Address, Category: entry.Category, Done: true})
+}
+
+func (c *SuppressionController) renderPage(ctx *gin.Context, status int, data unsubscribePageData) {
+	ctx.Status(status)
+	ctx.Header("Content-Type", "text/html; charset=utf-8")
+	if err := unsubscribePage.Execute(ctx.Writer, data); err != nil {
+		slog.Error("unsubscribeView:unableToRenderPage", slog.Any("error", err))
+	}
+}
@@ -0,0 +1,90 @@
+package suppression
+
+import (
+	"context"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/kp/pager/databases/sql"
+)
+
+const SuppressionTableName = "suppression"
+
+// Suppression stops notifications of Category from reaching Address, the
+// category CategoryAll stops every notification
+type Suppression struct {
+	ID        int64     `gorm:"column:id;primaryKey"`
+	Address   string    `gorm:"column:address;unique_index:idx_suppression_address_category"`
+	Category  string    `gorm:"column:category;unique_index:idx_suppression_address_category"`
+	Reason    string    `gorm:"column:reason"`
+	CreatedAt time.Time `gorm:"column:created_at"`
+}
+
+func (Suppression) TableName() string {
+	return SuppressionTableName
+}
+
+// NewSuppressionEntry stores the suppression, an existing suppression of the
+// address and category is returned as it is
+func NewSuppressionEntry(ctx context.Context, tx interface{}, address, category, reason string) (*Suppression, error) {
+	existing, err := GetSuppression(ctx, tx, address, category)
+	if err == nil {
+		return existing, nil
+	}
+	if !gorm.IsRecordNotFoundError(err) {
+		return nil, err
+	}
+
+	database := sql.GetOrmQuearyable(ctx, tx)
+	entry := Suppression{
+		Address:   address,
+		Category:  category,
+		Reason:    reason,
+		CreatedAt: time.Now(),
+	}
+	if err := database.Create(&entry).Error; err != nil {
+		// a concurrent unsubscribe may have stored it first
+		if existing, getErr := GetSuppression(ctx, tx, address, category); getErr == nil {
+			return existing, nil
+		}
+		return nil, err
+	}
+	return &entry, nil
+}
+
+func GetSuppression(ctx context.Context, tx interface{}, address, category string) (*Suppression, error) {
+	db := sql.GetOrmQuearyable(ctx, tx)
+	entry := Suppression{}
+	err := db.Where("address = ? AND category = ?", address, category).First(&entry).Error
+	return &entry, err
+}
+
+// CountSuppressions returns how many suppressions stop category from reaching address
+func CountSuppressions(ctx context.Context, tx interface{}, address, category string) (int, error) {
+	var count int
+	db := sql.GetOrmQuearyable(ctx, tx)
+	err := db.Model(&Suppression{}).
+		Where("address = ? AND category IN (?)", address, []string{category, CategoryAll}).
+		Count(&count).Error
+	return count, err
+}
+
+// GetSuppressions returns one page of suppressions, newest first, only those
+// of address when it is set
+func GetSuppressions(ctx context.Context, tx interface{}, address string, limit, offset int) ([]Suppression, error) {
+	var suppressions []Suppression
+	db := sql.GetOrmQuearyable(ctx, tx)
+	query := db.Model(&Suppression{})
+	if address != "" {
+		query = query.Where("address = ?", address)
+	}
+	err := query.Order("id DESC").Limit(limit).Offset(offset).Find(&suppressions).Error
+	return suppressions, err
+}
+
+// DeleteSuppression removes a suppression, returning the number of rows deleted
+func DeleteSuppression(ctx context.Context, tx interface{}, id int64) (int64, error) {
+	db := sql.GetOrmQuearyable(ctx, tx)
+	result := db.Where("id = ?", id).Delete(&Suppression{})
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,89 @@
+package suppression
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+type suppressionManager struct {
+	db *gorm.DB
+}
+
+func (s *suppressionManager) Suppress(ctx context.Context, address, category, reason string) (*Entry, error) {
+	address = normalizeAddress(address)
+	if address == "" {
+		return nil, fmt.Errorf("address cannot be empty")
+	}
+	if category == "" {
+		category = CategoryAll
+	}
+	entry, err := NewSuppressionEntry(ctx, s.db, address, normalizeCategory(category), reason)
+	if err != nil {
+		return nil, err
+	}
+	return toEntry(*entry), nil
+}
+
+func (s *suppressionManager) Remove(ctx context.Context, id int64) error {
+	deleted, err := DeleteSuppression(ctx, s.db, id)
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (s *suppressionManager) List(ctx context.Context, address string, limit, offset int) ([]Entry, error) {
+	suppressions, err := GetSuppressions(ctx, s.db, normalizeAddress(address), limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]Entry, 0, len(suppressions))
+	for _, suppression := range suppressions {
+		entries = append(entries, *toEntry(suppression))
+	}
+	return entries, nil
+}
+
+// IsSuppressed reports whether notifications of category must not reach address
+func (s *suppressionManager) IsSuppressed(ctx context.Context, address, category string) (bool, error) {
+	count, err := CountSuppressions(ctx, s.db, normalizeAddress(address), normalizeCategory(category))
+	return count > 0, err
+}
+
+// Unsubscribe suppresses the address and category of a signed unsubscribe token
+func (s *suppressionManager) Unsubscribe(ctx context.Context, token string) (*Entry, error) {
+	address, category, err := parseToken(config.Secret, token)
+	if err != nil {
+		return nil, err
+	}
+	return s.Suppress(ctx, address, category, ReasonUnsubscribe)
+}
+
+// TokenSubject returns the address and category a signed unsubscribe token is for
+func TokenSubject(token string) (string, string, error) {
+	return parseToken(config.Secret, token)
+}
+
+func normalizeAddress(address string) string {
+	return strings.ToLower(strings.TrimSpace(address))
+}
+
+func normalizeCategory(category string) string {
+	return strings.ToLower(strings.TrimSpace(category))
+}
+
+func toEntry(suppression Suppression) *Entry {
+	return &Entry{
+		ID:        suppression.ID,
+		Address:   suppression.Address,
+		Category:  suppression.Category,
+		Reason:    suppression.Reason,
+		CreatedAt: suppression.CreatedAt,
+	}
+}
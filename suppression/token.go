@@ -0,0 +1,69 @@
+package suppression
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// UnsubscribePath is where the api serves unsubscribe links
+const UnsubscribePath = "/pager/v1/unsubscribe/"
+
+var config SuppressionConfig
+
+// Init configures the secret signing unsubscribe links and the URL they point
+// at. It should be called once during startup.
+func Init(suppressionConfig SuppressionConfig) {
+	suppressionConfig.BaseURL = strings.TrimRight(suppressionConfig.BaseURL, "/")
+	config = suppressionConfig
+}
+
+// UnsubscribeURL returns the one-click link unsubscribing address from
+// category, empty when links are not configured
+func UnsubscribeURL(address, category string) string {
+	if config.Secret == "" || config.BaseURL == "" {
+		return ""
+	}
+	token := signToken(config.Secret, normalizeAddress(address), normalizeCategory(category))
+	return config.BaseURL + UnsubscribePath + "?token=" + url.QueryEscape(token)
+}
+
+// signToken encodes address and category with their HMAC-SHA256 signature.
+// Tokens do not expire so links in old notifications keep working.
+func signToken(secret, address, category string) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(address + "\n" + category))
+	return payload + "." + base64.RawURLEncoding.EncodeToString(tokenSignature(secret, payload))
+}
+
+// parseToken returns the address and category of a token signed with secret
+func parseToken(secret, token string) (string, string, error) {
+	if secret == "" {
+		return "", "", fmt.Errorf("%w: unsubscribe links are not configured", ErrInvalidToken)
+	}
+	payload, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return "", "", ErrInvalidToken
+	}
+	decodedSignature, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(decodedSignature, tokenSignature(secret, payload)) {
+		return "", "", ErrInvalidToken
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return "", "", ErrInvalidToken
+	}
+	address, category, ok := strings.Cut(string(decoded), "\n")
+	if !ok || address == "" || category == "" {
+		return "", "", ErrInvalidToken
+	}
+	return address, category, nil
+}
+
+func tokenSignature(secret, payload string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("unsubscribe:" + payload))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,57 @@
+package suppression
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseToken(t *testing.T) {
+	token := signToken("secret", "user@example.com", "marketing")
+
+	address, category, err := parseToken("secret", token)
+	require.NoError(t, err)
+	assert.Equal(t, "user@example.com", address)
+	assert.Equal(t, "marketing", category)
+
+	payload, signature, _ := strings.Cut(token, ".")
+	forged := signToken("secret", "other@example.com", "marketing")
+	forgedPayload, _, _ := strings.Cut(forged, ".")
+
+	for name, invalid := range map[string]string{
+		"other secret":      signToken("other", "user@example.com", "marketing"),
+		"swapped payload":   forgedPayload + "." + signature,
+		"missing signature": payload,
+		"empty":             "",
+		"garbage":           "not.a-token!",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, _, err := parseToken("secret", invalid)
+			assert.ErrorIs(t, err, ErrInvalidToken)
+		})
+	}
+
+	_, _, err = parseToken("", token)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestUnsubscribeURL(t *testing.T) {
+	defer Init(SuppressionConfig{})
+
+	Init(SuppressionConfig{})
+	assert.Empty(t, UnsubscribeURL("user@example.com", "marketing"))
+
+	Init(SuppressionConfig{Secret: "secret", BaseURL: "https://pager.example.com/"})
+	link := UnsubscribeURL(" User@Example.com ", "Marketing")
+	parsed, err := url.Parse(link)
+	require.NoError(t, err)
+	assert.Equal(t, "https://pager.example.com"+UnsubscribePath, parsed.Scheme+"://"+parsed.Host+parsed.Path)
+
+	address, category, err := TokenSubject(parsed.Query().Get("token"))
+	require.NoError(t, err)
+	assert.Equal(t, "user@example.com", address)
+	assert.Equal(t, "marketing", category)
+}
@@ -0,0 +1,57 @@
+package suppression
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// CategoryAll suppresses every category for an address
+const CategoryAll = "all"
+
+// Reasons recorded with a suppression
+const (
+	ReasonUnsubscribe = "unsubscribe"
+	ReasonAdmin       = "admin"
+)
+
+// ErrInvalidToken is returned for an unsubscribe token which was not signed
+// with the configured secret
+var ErrInvalidToken = errors.New("invalid unsubscribe token")
+
+// SuppressionConfig configures unsubscribe links. Links are only added to
+// notifications when both the secret and the public base URL of the api are set,
+// e.g. UNSUBSCRIBE_BASE_URL=https://pager.example.com
+type SuppressionConfig struct {
+	Secret  string `json:"UNSUBSCRIBE_SECRET"`
+	BaseURL string `json:"UNSUBSCRIBE_BASE_URL"`
+}
+
+type SuppressionService interface {
+	Suppress(ctx context.Context, address, category, reason string) (*Entry, error)
+	Remove(ctx context.Context, id int64) error
+	List(ctx context.Context, address string, limit, offset int) ([]Entry, error)
+	IsSuppressed(ctx context.Context, address, category string) (bool, error)
+	Unsubscribe(ctx context.Context, token string) (*Entry, error)
+}
+
+func NewSuppressionService(db *gorm.DB) SuppressionService {
+	return &suppressionManager{db: db}
+}
+
+type SuppressionRequest struct {
+	Address string `json:"address" binding:"required"`
+	// Category defaults to CategoryAll
+	Category string `json:"category"`
+	Reason   string `json:"reason"`
+}
+
+type Entry struct {
+	ID        int64     `json:"id"`
+	Address   string    `json:"address"`
+	Category  string    `json:"category"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,124 @@
+package templates
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/kp/pager/common"
+)
+
+// MaxSMSSegments is the longest SMS a template may render, longer messages
+// are rejected instead of being split by the gateway
+const MaxSMSSegments = 10
+
+// ChannelContent holds the bodies of a template for the channels other than
+// the email HTML kept in Subject and Content. A channel whose body is empty
+// is not supported by the template, TextContent is an optional plaintext
+// alternative of the email.
+type ChannelContent struct {
+	TextContent  string `json:"text_content" gorm:"column:text_content;type:text"`
+	SMSText      string `json:"sms_text" gorm:"column:sms_text;type:text"`
+	PushTitle    string `json:"push_title" gorm:"column:push_title"`
+	PushBody     string `json:"push_body" gorm:"column:push_body;type:text"`
+	InAppContent string `json:"in_app_content" gorm:"column:in_app_content;type:text"`
+}
+
+// columns returns the content as the values of an Updates call
+func (c ChannelContent) columns() map[string]interface{} {
+	return map[string]interface{}{
+		"text_content":   c.TextContent,
+		"sms_text":       c.SMSText,
+		"push_title":     c.PushTitle,
+		"push_body":      c.PushBody,
+		"in_app_content": c.InAppContent,
+	}
+}
+
+// validate rejects SMS text which is too long before any context is rendered into it
+func (c ChannelContent) validate() error {
+	if segments := SMSSegments(c.SMSText); segments > MaxSMSSegments {
+		return fmt.Errorf("%w: sms_text needs %d segments, at most %d are allowed", ErrInvalidTemplate, segments, MaxSMSSegments)
+	}
+	return nil
+}
+
+// withDefaults fills the empty bodies of c from fallback
+func (c ChannelContent) withDefaults(fallback ChannelContent) ChannelContent {
+	if c.TextContent == "" {
+		c.TextContent = fallback.TextContent
+	}
+	if c.SMSText == "" {
+		c.SMSText = fallback.SMSText
+	}
+	if c.PushTitle == "" && c.PushBody == "" {
+		c.PushTitle, c.PushBody = fallback.PushTitle, fallback.PushBody
+	}
+	if c.InAppContent == "" {
+		c.InAppContent = fallback.InAppContent
+	}
+	return c
+}
+
+// Channels returns the channels the template has content for, email is
+// always supported since subject and content are required
+func (t *Template) Channels() []string {
+	channels := []string{common.ChannelEmail}
+	if t.SMSText != "" {
+		channels = append(channels, common.ChannelSMS)
+	}
+	if t.PushBody != "" {
+		channels = append(channels, common.ChannelPush)
+	}
+	if t.InAppContent != "" {
+		channels = append(channels, common.ChannelInApp)
+	}
+	return channels
+}
+
+// Supports reports whether the template has content for channel
+func (t *Template) Supports(channel string) bool {
+	for _, c := range t.Channels() {
+		if c == channel {
+			return true
+		}
+	}
+	return false
+}
+
+// gsm7Basic characters take one septet in the GSM 03.38 alphabet and
+// gsm7Extension characters take two
+const (
+	gsm7Basic = "@£$¥èéùìòÇ\nØø\rÅåΔ_ΦΓΛΩΠΨΣΘΞÆæßÉ !\"#¤%&'()*+,-./0123456789:;<=>?" +
+		"¡ABCDEFGHIJKLMNOPQRSTUVWXYZÄÖÑÜ§¿abcdefghijklmnopqrstuvwxyzäöñüà"
+	gsm7Extension = "^{}\\[~]|€\f"
+)
+
+// SMSSegments returns how many messages text is sent as. Text in the GSM 03.38
+// alphabet fits 160 characters in one message and 153 per part when split,
+// any other text is sent as UCS-2 with 70 and 67 characters.
+func SMSSegments(text string) int {
+	if text == "" {
+		return 0
+	}
+	single, part := 160, 153
+	length := 0
+	for _, r := range text {
+		switch {
+		case strings.ContainsRune(gsm7Basic, r):
+			length++
+		case strings.ContainsRune(gsm7Extension, r):
+			length += 2
+		default:
+			single, part = 70, 67
+			length = len(utf16.Encode([]rune(text)))
+		}
+		if single == 70 {
+			break
+		}
+	}
+	if length <= single {
+		return 1
+	}
+	return (length + part - 1) / part
+}
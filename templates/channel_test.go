@@ -0,0 +1,49 @@
+package templates
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kp/pager/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSMSSegments(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want int
+	}{
+		{name: "empty", text: "", want: 0},
+		{name: "single gsm", text: strings.Repeat("a", 160), want: 1},
+		{name: "split gsm", text: strings.Repeat("a", 161), want: 2},
+		{name: "extension characters count twice", text: strings.Repeat("€", 81), want: 2},
+		{name: "one non gsm character switches to ucs2", text: strings.Repeat("ü", 70) + "ç", want: 2},
+		{name: "emoji", text: strings.Repeat("😀", 35), want: 1},
+		{name: "split ucs2", text: strings.Repeat("😀", 36), want: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, SMSSegments(tt.text))
+		})
+	}
+}
+
+func TestTemplate_Channels(t *testing.T) {
+	template := &Template{Subject: "Hi", Content: "<p>Hi</p>"}
+	assert.Equal(t, []string{common.ChannelEmail}, template.Channels())
+
+	template.SMSText = "Hi"
+	template.InAppContent = "Hi"
+	assert.Equal(t, []string{common.ChannelEmail, common.ChannelSMS, common.ChannelInApp}, template.Channels())
+	assert.True(t, template.Supports(common.ChannelSMS))
+	assert.False(t, template.Supports(common.ChannelPush))
+}
+
+func TestChannelContent_Validate(t *testing.T) {
+	assert.NoError(t, ChannelContent{SMSText: "Your code is {{.code}}"}.validate())
+
+	err := ChannelContent{SMSText: strings.Repeat("a", 153*MaxSMSSegments+1)}.validate()
+	assert.ErrorIs(t, err, ErrInvalidTemplate)
+}
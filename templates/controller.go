@@ -1,11 +1,13 @@
 package templates
 
 import (
+	"errors"
 	"log/slog"
 	"net/http"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
 )
 
 type TemplateController struct {
@@ -26,10 +28,10 @@ func (c *TemplateController) CreateTemplate(ctx *gin.Context) {
 		return
 	}
 
-	template, err := c.templateService.CreateTemplate(ctx.Request.Context(), request.Name, request.Subject, request.Content)
+	template, err := c.templateService.CreateTemplate(ctx.Request.Context(), request.Name, request.Category, request.Subject, request.Content, request.ChannelContent)
 	if err != nil {
 		slog.Error("createTemplateView:unableToCreateTemplate", slog.Any("error", err))
-		ctx.JSON(http.StatusInternalServerError, gin.H{
+		ctx.JSON(templateErrorStatus(err), gin.H{
 			"error":  err.Error(),
 			"status": false,
 			"msg":    err.Error(),
@@ -60,12 +62,12 @@ func (c *TemplateController) UpdateTemplate(ctx *gin.Context) {
 		return
 	}
 
-	template, err := c.templateService.UpdateTemplate(ctx.Request.Context(), id, request.Name, request.Subject, request.Content)
+	template, err := c.templateService.UpdateTemplate(ctx.Request.Context(), id, request.Name, request.Category, request.Subject, request.Content, request.ChannelContent)
 	if err != nil {
 		slog.Error("updateTemplateView:unableToUpdateTemplate",
 			slog.Int64("template_id", id),
 			slog.Any("error", err))
-		ctx.JSON(http.StatusInternalServerError, gin.H{
+		ctx.JSON(templateErrorStatus(err), gin.H{
 			"error":  err.Error(),
 			"status": false,
 			"msg":    err.Error(),
@@ -94,7 +96,7 @@ func (c *TemplateController) GetTemplate(ctx *gin.Context) {
 		slog.Error("getTemplateView:unableToGetTemplate",
 			slog.Int64("template_id", id),
 			slog.Any("error", err))
-		ctx.JSON(http.StatusInternalServerError, gin.H{
+		ctx.JSON(templateErrorStatus(err), gin.H{
 			"error":  err.Error(),
 			"status": false,
 			"msg":    err.Error(),
@@ -129,3 +131,282 @@ func (c *TemplateController) GetAllTemplates(ctx *gin.Context) {
 		"data":   templates,
 	})
 }
+
+func (c *TemplateController) ListTemplateVersions(ctx *gin.Context) {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid template ID"})
+		return
+	}
+
+	versions, err := c.templateService.ListTemplateVersions(ctx.Request.Context(), id)
+	if err != nil {
+		slog.Error("listTemplateVersionsView:unableToGetVersions",
+			slog.Int64("template_id", id),
+			slog.Any("error", err))
+		ctx.JSON(templateErrorStatus(err), gin.H{
+			"error":  err.Error(),
+			"status": false,
+			"msg":    err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"error":  nil,
+		"status": true,
+		"msg":    "Template versions retrieved successfully",
+		"data":   versions,
+	})
+}
+
+func (c *TemplateController) GetTemplateVersion(ctx *gin.Context) {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid template ID"})
+		return
+	}
+	version, err := strconv.Atoi(ctx.Param("version"))
+	if err != nil || version < 1 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid template version"})
+		return
+	}
+
+	template, err := c.templateService.GetTemplateVersion(ctx.Request.Context(), id, version)
+	if err != nil {
+		slog.Error("getTemplateVersionView:unableToGetVersion",
+			slog.Int64("template_id", id),
+			slog.Int("version", version),
+			slog.Any("error", err))
+		ctx.JSON(templateErrorStatus(err), gin.H{
+			"error":  err.Error(),
+			"status": false,
+			"msg":    err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"error":  nil,
+		"status": true,
+		"msg":    "Template version retrieved successfully",
+		"data":   template,
+	})
+}
+
+func (c *TemplateController) DiffTemplateVersions(ctx *gin.Context) {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid template ID"})
+		return
+	}
+	from, fromErr := strconv.Atoi(ctx.Query("from"))
+	to, toErr := strconv.Atoi(ctx.Query("to"))
+	if fromErr != nil || toErr != nil || from < 1 || to < 1 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "from and to must be template versions"})
+		return
+	}
+
+	diff, err := c.templateService.DiffTemplateVersions(ctx.Request.Context(), id, from, to)
+	if err != nil {
+		slog.Error("diffTemplateVersionsView:unableToDiffVersions",
+			slog.Int64("template_id", id),
+			slog.Int("from", from),
+			slog.Int("to", to),
+			slog.Any("error", err))
+		ctx.JSON(templateErrorStatus(err), gin.H{
+			"error":  err.Error(),
+			"status": false,
+			"msg":    err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"error":  nil,
+		"status": true,
+		"msg":    "Template versions compared successfully",
+		"data":   diff,
+	})
+}
+
+func (c *TemplateController) RollbackTemplate(ctx *gin.Context) {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid template ID"})
+		return
+	}
+
+	var request RollbackRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		slog.Error("rollbackTemplateView:unableToBindJSON", slog.Any("error", err))
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	template, err := c.templateService.RollbackTemplate(ctx.Request.Context(), id, request.Version)
+	if err != nil {
+		slog.Error("rollbackTemplateView:unableToRollbackTemplate",
+			slog.Int64("template_id", id),
+			slog.Int("version", request.Version),
+			slog.Any("error", err))
+		ctx.JSON(templateErrorStatus(err), gin.H{
+			"error":  err.Error(),
+			"status": false,
+			"msg":    err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"error":  nil,
+		"status": true,
+		"msg":    "Template rolled back successfully",
+		"data":   template,
+	})
+}
+
+func (c *TemplateController) PreviewTemplate(ctx *gin.Context) {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid template ID"})
+		return
+	}
+
+	var request PreviewRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		slog.Error("previewTemplateView:unableToBindJSON", slog.Any("error", err))
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	preview, err := c.templateService.PreviewTemplate(ctx.Request.Context(), id, request.Version, request.Channel, request.Recipient, request.Context)
+	if err != nil {
+		slog.Error("previewTemplateView:unableToPreviewTemplate",
+			slog.Int64("template_id", id),
+			slog.Int("version", request.Version),
+			slog.Any("error", err))
+		ctx.JSON(templateErrorStatus(err), gin.H{
+			"error":  err.Error(),
+			"status": false,
+			"msg":    err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"error":  nil,
+		"status": true,
+		"msg":    "Template preview rendered successfully",
+		"data":   preview,
+	})
+}
+
+func (c *TemplateController) ListTemplateVariants(ctx *gin.Context) {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid template ID"})
+		return
+	}
+
+	variants, err := c.templateService.ListTemplateVariants(ctx.Request.Context(), id)
+	if err != nil {
+		slog.Error("listTemplateVariantsView:unableToGetVariants",
+			slog.Int64("template_id", id),
+			slog.Any("error", err))
+		ctx.JSON(templateErrorStatus(err), gin.H{
+			"error":  err.Error(),
+			"status": false,
+			"msg":    err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"error":  nil,
+		"status": true,
+		"msg":    "Template variants retrieved successfully",
+		"data":   variants,
+	})
+}
+
+func (c *TemplateController) PutTemplateVariant(ctx *gin.Context) {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid template ID"})
+		return
+	}
+
+	var request TemplateVariantRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		slog.Error("putTemplateVariantView:unableToBindJSON", slog.Any("error", err))
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	locale := ctx.Param("locale")
+	template, err := c.templateService.PutTemplateVariant(ctx.Request.Context(), id, locale, request.Subject, request.Content, request.ChannelContent)
+	if err != nil {
+		slog.Error("putTemplateVariantView:unableToPutVariant",
+			slog.Int64("template_id", id),
+			slog.String("locale", locale),
+			slog.Any("error", err))
+		ctx.JSON(templateErrorStatus(err), gin.H{
+			"error":  err.Error(),
+			"status": false,
+			"msg":    err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"error":  nil,
+		"status": true,
+		"msg":    "Template variant saved successfully",
+		"data":   template,
+	})
+}
+
+func (c *TemplateController) DeleteTemplateVariant(ctx *gin.Context) {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid template ID"})
+		return
+	}
+
+	locale := ctx.Param("locale")
+	template, err := c.templateService.DeleteTemplateVariant(ctx.Request.Context(), id, locale)
+	if err != nil {
+		slog.Error("deleteTemplateVariantView:unableToDeleteVariant",
+			slog.Int64("template_id", id),
+			slog.String("locale", locale),
+			slog.Any("error", err))
+		ctx.JSON(templateErrorStatus(err), gin.H{
+			"error":  err.Error(),
+			"status": false,
+			"msg":    err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"error":  nil,
+		"status": true,
+		"msg":    "Template variant deleted successfully",
+		"data":   template,
+	})
+}
+
+// templateErrorStatus maps template service errors to response codes
+func templateErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrInvalidVersion), errors.Is(err, ErrInvalidLocale), errors.Is(err, ErrUnsupportedChannel):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrInvalidTemplate):
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusInternalServerError
+	}
+}
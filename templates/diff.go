@@ -0,0 +1,112 @@
+package templates
+
+import (
+	"sort"
+	"strings"
+)
+
+const (
+	DiffEqual  = "equal"
+	DiffInsert = "insert"
+	DiffDelete = "delete"
+)
+
+// diffChanged is diffLines for optional texts, it is nil when they are equal
+func diffChanged(from, to string) []DiffLine {
+	if from == to {
+		return nil
+	}
+	return diffLines(from, to)
+}
+
+// diffLines returns the line-by-line changes turning from into to, based on
+// their longest common subsequence
+func diffLines(from, to string) []DiffLine {
+	a := strings.Split(from, "\n")
+	b := strings.Split(to, "\n")
+
+	// lcs[i][j] is the common subsequence length of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	diff := make([]DiffLine, 0, max(len(a), len(b)))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			diff = append(diff, DiffLine{Op: DiffEqual, Text: a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			diff = append(diff, DiffLine{Op: DiffDelete, Text: a[i]})
+			i++
+		default:
+			diff = append(diff, DiffLine{Op: DiffInsert, Text: b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		diff = append(diff, DiffLine{Op: DiffDelete, Text: a[i]})
+	}
+	for ; j < len(b); j++ {
+		diff = append(diff, DiffLine{Op: DiffInsert, Text: b[j]})
+	}
+	return diff
+}
+
+// diffVariants returns the changes of the locale variants of two versions
+func diffVariants(from, to []NotificationTemplateVariant) []VariantDiff {
+	fromByLocale := make(map[string]NotificationTemplateVariant, len(from))
+	for _, variant := range from {
+		fromByLocale[variant.Locale] = variant
+	}
+	toByLocale := make(map[string]NotificationTemplateVariant, len(to))
+	for _, variant := range to {
+		toByLocale[variant.Locale] = variant
+	}
+	locales := make([]string, 0, len(from)+len(to))
+	for locale := range fromByLocale {
+		locales = append(locales, locale)
+	}
+	for locale := range toByLocale {
+		if _, ok := fromByLocale[locale]; !ok {
+			locales = append(locales, locale)
+		}
+	}
+	sort.Strings(locales)
+
+	diffs := make([]VariantDiff, 0, len(locales))
+	for _, locale := range locales {
+		a, inFrom := fromByLocale[locale]
+		b, inTo := toByLocale[locale]
+		op := DiffEqual
+		if !inFrom {
+			op = DiffInsert
+		} else if !inTo {
+			op = DiffDelete
+		}
+		diffs = append(diffs, VariantDiff{
+			Locale:       locale,
+			Op:           op,
+			Subject:      diffLines(a.Subject, b.Subject),
+			Content:      diffLines(a.Content, b.Content),
+			TextContent:  diffLines(a.TextContent, b.TextContent),
+			SMSText:      diffLines(a.SMSText, b.SMSText),
+			PushTitle:    diffLines(a.PushTitle, b.PushTitle),
+			PushBody:     diffLines(a.PushBody, b.PushBody),
+			InAppContent: diffLines(a.InAppContent, b.InAppContent),
+		})
+	}
+	return diffs
+}
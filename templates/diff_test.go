@@ -0,0 +1,85 @@
+package templates
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		name string
+		from string
+		to   string
+		want []DiffLine
+	}{
+		{
+			name: "unchanged",
+			from: "Hello",
+			to:   "Hello",
+			want: []DiffLine{{DiffEqual, "Hello"}},
+		},
+		{
+			name: "line changed",
+			from: "<p>Hi</p>\n<p>Bye</p>",
+			to:   "<p>Hello</p>\n<p>Bye</p>",
+			want: []DiffLine{
+				{DiffDelete, "<p>Hi</p>"},
+				{DiffInsert, "<p>Hello</p>"},
+				{DiffEqual, "<p>Bye</p>"},
+			},
+		},
+		{
+			name: "lines added and removed",
+			from: "a\nb\nc",
+			to:   "b\nc\nd",
+			want: []DiffLine{
+				{DiffDelete, "a"},
+				{DiffEqual, "b"},
+				{DiffEqual, "c"},
+				{DiffInsert, "d"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, diffLines(tt.from, tt.to))
+		})
+	}
+}
+
+func TestDiffVariants(t *testing.T) {
+	from := []NotificationTemplateVariant{
+		{Locale: "de", Subject: "Hallo", Content: "Willkommen"},
+		{Locale: "fr", Subject: "Bonjour", Content: "Bienvenue\nA bientôt"},
+	}
+	// only the fr variant changes, es is added and de removed
+	to := []NotificationTemplateVariant{
+		{Locale: "es", Subject: "Hola", Content: "Bienvenido"},
+		{Locale: "fr", Subject: "Bonjour", Content: "Bienvenue chez nous\nA bientôt", ChannelContent: ChannelContent{SMSText: "Bienvenue"}},
+	}
+
+	diffs := diffVariants(from, to)
+	assert.Len(t, diffs, 3)
+
+	assert.Equal(t, "de", diffs[0].Locale)
+	assert.Equal(t, DiffDelete, diffs[0].Op)
+	assert.Equal(t, []DiffLine{{DiffDelete, "Hallo"}, {DiffInsert, ""}}, diffs[0].Subject)
+
+	assert.Equal(t, "es", diffs[1].Locale)
+	assert.Equal(t, DiffInsert, diffs[1].Op)
+
+	fr := diffs[2]
+	assert.Equal(t, "fr", fr.Locale)
+	assert.Equal(t, DiffEqual, fr.Op)
+	assert.Equal(t, []DiffLine{{DiffEqual, "Bonjour"}}, fr.Subject)
+	assert.Equal(t, []DiffLine{
+		{DiffDelete, "Bienvenue"},
+		{DiffInsert, "Bienvenue chez nous"},
+		{DiffEqual, "A bientôt"},
+	}, fr.Content)
+	assert.Equal(t, []DiffLine{{DiffDelete, ""}, {DiffInsert, "Bienvenue"}}, fr.SMSText)
+
+	assert.Empty(t, diffVariants(nil, nil))
+}
@@ -0,0 +1,80 @@
+package templates
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// LocaleContextKey is the audience context key selecting the template variant
+const LocaleContextKey = "locale"
+
+// UnsubscribeURLContextKey is set by the communicator to the recipient's
+// unsubscribe link, so previews never report it missing
+const UnsubscribeURLContextKey = "unsubscribe_url"
+
+var localePattern = regexp.MustCompile(`^[A-Za-z]{2,8}([-_][A-Za-z0-9]{1,8})*$`)
+
+// NormalizeLocale returns locale as a BCP 47 tag with conventional casing,
+// e.g. pt_br becomes pt-BR and zh-hant-tw becomes zh-Hant-TW
+func NormalizeLocale(locale string) (string, error) {
+	locale = strings.TrimSpace(locale)
+	if !localePattern.MatchString(locale) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidLocale, locale)
+	}
+	subtags := strings.Split(strings.ReplaceAll(locale, "_", "-"), "-")
+	subtags[0] = strings.ToLower(subtags[0])
+	for i := 1; i < len(subtags); i++ {
+		switch {
+		case len(subtags[i]) == 2:
+			subtags[i] = strings.ToUpper(subtags[i])
+		case len(subtags[i]) == 4:
+			subtags[i] = strings.ToUpper(subtags[i][:1]) + strings.ToLower(subtags[i][1:])
+		default:
+			subtags[i] = strings.ToLower(subtags[i])
+		}
+	}
+	return strings.Join(subtags, "-"), nil
+}
+
+// LocaleFallbacks returns the locales tried for locale, most specific first,
+// e.g. pt-BR gives pt-BR then pt. Invalid locales have no fallbacks so the
+// default content is used.
+func LocaleFallbacks(locale string) []string {
+	if strings.TrimSpace(locale) == "" {
+		return nil
+	}
+	normalized, err := NormalizeLocale(locale)
+	if err != nil {
+		return nil
+	}
+	var fallbacks []string
+	for tag := normalized; tag != ""; {
+		fallbacks = append(fallbacks, tag)
+		i := strings.LastIndex(tag, "-")
+		if i < 0 {
+			break
+		}
+		tag = tag[:i]
+	}
+	return fallbacks
+}
+
+// Localize returns the template with the content of the variant closest to
+// locale, falling back to the default content when no variant matches. Channels
+// the variant has no content for keep the default content.
+func (t *Template) Localize(locale string) *Template {
+	for _, candidate := range LocaleFallbacks(locale) {
+		for _, variant := range t.Variants {
+			if strings.EqualFold(variant.Locale, candidate) {
+				localized := *t
+				localized.Subject = variant.Subject
+				localized.Content = variant.Content
+				localized.ChannelContent = variant.ChannelContent.withDefaults(t.ChannelContent)
+				localized.Locale = variant.Locale
+				return &localized
+			}
+		}
+	}
+	return t
+}
@@ -0,0 +1,78 @@
+package templates
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeLocale(t *testing.T) {
+	tests := []struct {
+		locale string
+		want   string
+	}{
+		{locale: "en", want: "en"},
+		{locale: "pt_br", want: "pt-BR"},
+		{locale: " PT-br ", want: "pt-BR"},
+		{locale: "zh-hant-tw", want: "zh-Hant-TW"},
+		{locale: "es-419", want: "es-419"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.locale, func(t *testing.T) {
+			got, err := NormalizeLocale(tt.locale)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestNormalizeLocale_Invalid(t *testing.T) {
+	for _, locale := range []string{"", "e", "pt-", "en us", "../etc"} {
+		_, err := NormalizeLocale(locale)
+		assert.ErrorIs(t, err, ErrInvalidLocale, locale)
+	}
+}
+
+func TestLocaleFallbacks(t *testing.T) {
+	assert.Equal(t, []string{"pt-BR", "pt"}, LocaleFallbacks("pt_BR"))
+	assert.Equal(t, []string{"zh-Hant-TW", "zh-Hant", "zh"}, LocaleFallbacks("zh-Hant-TW"))
+	assert.Equal(t, []string{"en"}, LocaleFallbacks("en"))
+	assert.Nil(t, LocaleFallbacks(""))
+	assert.Nil(t, LocaleFallbacks("not a locale"))
+}
+
+func TestTemplate_Localize(t *testing.T) {
+	template := &Template{
+		ID:      1,
+		Subject: "Hello",
+		Content: "Welcome",
+		Variants: []TemplateVariant{
+			{Locale: "pt", Subject: "Olá", Content: "Bem-vindo"},
+			{Locale: "pt-BR", Subject: "Oi", Content: "Bem-vindo ao Brasil"},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		locale      string
+		wantLocale  string
+		wantSubject string
+	}{
+		{name: "exact match", locale: "pt-BR", wantLocale: "pt-BR", wantSubject: "Oi"},
+		{name: "case insensitive", locale: "pt_br", wantLocale: "pt-BR", wantSubject: "Oi"},
+		{name: "language fallback", locale: "pt-PT", wantLocale: "pt", wantSubject: "Olá"},
+		{name: "default fallback", locale: "fr-FR", wantLocale: "", wantSubject: "Hello"},
+		{name: "no locale", locale: "", wantLocale: "", wantSubject: "Hello"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			localized := template.Localize(tt.locale)
+			assert.Equal(t, tt.wantLocale, localized.Locale)
+			assert.Equal(t, tt.wantSubject, localized.Subject)
+		})
+	}
+	assert.Equal(t, "Hello", template.Subject, "the template itself is not changed")
+}
@@ -4,33 +4,81 @@ import (
 	"context"
 	"time"
 
+	"github.com/jinzhu/gorm"
 	"github.com/kp/pager/databases/sql"
 )
 
-const TemplateTableName = "notification_template"
+const (
+	TemplateTableName        = "notification_template"
+	TemplateVersionTableName = "notification_template_version"
+	TemplateVariantTableName = "notification_template_variant"
+)
 
 type NotificationTemplate struct {
-	ID          int64     `gorm:"column:id;primaryKey"`
-	Name        string    `gorm:"column:name;unique"`
-	Subject     string    `gorm:"column:subject"`
-	Content     string    `gorm:"column:content;type:text"`
-	Description string    `gorm:"column:description"`
-	CreatedAt   time.Time `gorm:"column:created_at"`
-	UpdatedAt   time.Time `gorm:"column:updated_at"`
+	ID          int64  `gorm:"column:id;primaryKey"`
+	Name        string `gorm:"column:name;unique"`
+	Subject     string `gorm:"column:subject"`
+	Content     string `gorm:"column:content;type:text"`
+	Description string `gorm:"column:description"`
+	// Category groups templates recipients can unsubscribe from, e.g. marketing
+	Category string `gorm:"column:category;default:'general'"`
+	ChannelContent
+	// CurrentVersion is the version whose content the row holds
+	CurrentVersion int       `gorm:"column:current_version;default:0"`
+	CreatedAt      time.Time `gorm:"column:created_at"`
+	UpdatedAt      time.Time `gorm:"column:updated_at"`
 }
 
 func (NotificationTemplate) TableName() string {
 	return TemplateTableName
 }
 
-func NewTemplateEntry(ctx context.Context, tx interface{}, name, subject, content string) (*NotificationTemplate, error) {
+// NotificationTemplateVersion is an immutable snapshot of a template, every
+// change to the template adds a new version
+type NotificationTemplateVersion struct {
+	ID         int64  `gorm:"column:id;primaryKey"`
+	TemplateID int64  `gorm:"column:template_id;unique_index:idx_template_version"`
+	Version    int    `gorm:"column:version;unique_index:idx_template_version"`
+	Name       string `gorm:"column:name"`
+	Category   string `gorm:"column:category;default:'general'"`
+	Subject    string `gorm:"column:subject"`
+	Content    string `gorm:"column:content;type:text"`
+	ChannelContent
+	CreatedAt time.Time `gorm:"column:created_at"`
+}
+
+func (NotificationTemplateVersion) TableName() string {
+	return TemplateVersionTableName
+}
+
+// NotificationTemplateVariant is the subject and content of a template version
+// in one locale, every version carries the complete set of its variants
+type NotificationTemplateVariant struct {
+	ID         int64  `gorm:"column:id;primaryKey"`
+	TemplateID int64  `gorm:"column:template_id;unique_index:idx_template_variant"`
+	Version    int    `gorm:"column:version;unique_index:idx_template_variant"`
+	Locale     string `gorm:"column:locale;unique_index:idx_template_variant"`
+	Subject    string `gorm:"column:subject"`
+	Content    string `gorm:"column:content;type:text"`
+	ChannelContent
+	CreatedAt time.Time `gorm:"column:created_at"`
+}
+
+func (NotificationTemplateVariant) TableName() string {
+	return TemplateVariantTableName
+}
+
+func NewTemplateEntry(ctx context.Context, tx interface{}, name, category, subject, content string, channels ChannelContent) (*NotificationTemplate, error) {
 	database := sql.GetOrmQuearyable(ctx, tx)
 	entry := NotificationTemplate{
-		Name:      name,
-		Subject:   subject,
-		Content:   content,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+		Name:           name,
+		Category:       category,
+		Subject:        subject,
+		Content:        content,
+		ChannelContent: channels,
+		CurrentVersion: 1,
+		CreatedAt:      time.Now(),
+		UpdatedAt:      time.Now(),
 	}
 	err := database.Create(&entry).Error
 	return &entry, err
@@ -43,6 +91,106 @@ func GetTemplateByID(ctx context.Context, tx interface{}, id int64) (*Notificati
 	return &entry, err
 }
 
+// GetTemplateByIDForUpdate locks the template row until tx ends, tx must be a transaction
+func GetTemplateByIDForUpdate(ctx context.Context, tx interface{}, id int64) (*NotificationTemplate, error) {
+	db := sql.GetOrmQuearyable(ctx, tx)
+	entry := NotificationTemplate{}
+	err := db.Set("gorm:query_option", "FOR UPDATE").First(&entry, id).Error
+	return &entry, err
+}
+
+// UpdateTemplateContent points the template at version, leaving created_at untouched
+func UpdateTemplateContent(ctx context.Context, tx interface{}, id int64, name, category, subject, content string, channels ChannelContent, version int) error {
+	db := sql.GetOrmQuearyable(ctx, tx)
+	columns := channels.columns()
+	columns["name"] = name
+	columns["category"] = category
+	columns["subject"] = subject
+	columns["content"] = content
+	columns["current_version"] = version
+	columns["updated_at"] = time.Now()
+	return db.Model(&NotificationTemplate{}).Where("id = ?", id).Updates(columns).Error
+}
+
+func NewTemplateVersionEntry(ctx context.Context, tx interface{}, templateID int64, version int, name, category, subject, content string, channels ChannelContent) (*NotificationTemplateVersion, error) {
+	database := sql.GetOrmQuearyable(ctx, tx)
+	entry := NotificationTemplateVersion{
+		TemplateID:     templateID,
+		Version:        version,
+		Name:           name,
+		Category:       category,
+		Subject:        subject,
+		Content:        content,
+		ChannelContent: channels,
+		CreatedAt:      time.Now(),
+	}
+	err := database.Create(&entry).Error
+	return &entry, err
+}
+
+func GetTemplateVersion(ctx context.Context, tx interface{}, templateID int64, version int) (*NotificationTemplateVersion, error) {
+	db := sql.GetOrmQuearyable(ctx, tx)
+	entry := NotificationTemplateVersion{}
+	err := db.Where("template_id = ? AND version = ?", templateID, version).First(&entry).Error
+	return &entry, err
+}
+
+// GetTemplateVersions returns every version of a template, newest first
+func GetTemplateVersions(ctx context.Context, tx interface{}, templateID int64) ([]NotificationTemplateVersion, error) {
+	var versions []NotificationTemplateVersion
+	db := sql.GetOrmQuearyable(ctx, tx)
+	err := db.Where("template_id = ?", templateID).Order("version DESC").Find(&versions).Error
+	return versions, err
+}
+
+func NewTemplateVariantEntry(ctx context.Context, tx interface{}, templateID int64, version int, locale, subject, content string, channels ChannelContent) (*NotificationTemplateVariant, error) {
+	database := sql.GetOrmQuearyable(ctx, tx)
+	entry := NotificationTemplateVariant{
+		TemplateID:     templateID,
+		Version:        version,
+		Locale:         locale,
+		Subject:        subject,
+		Content:        content,
+		ChannelContent: channels,
+		CreatedAt:      time.Now(),
+	}
+	err := database.Create(&entry).Error
+	return &entry, err
+}
+
+// GetTemplateVariants returns the locale variants of a template version ordered by locale
+func GetTemplateVariants(ctx context.Context, tx interface{}, templateID int64, version int) ([]NotificationTemplateVariant, error) {
+	var variants []NotificationTemplateVariant
+	db := sql.GetOrmQuearyable(ctx, tx)
+	err := db.Where("template_id = ? AND version = ?", templateID, version).Order("locale").Find(&variants).Error
+	return variants, err
+}
+
+// BackfillTemplateVersions stores the content of templates created before
+// versioning as their first version
+func BackfillTemplateVersions(ctx context.Context, tx interface{}) error {
+	db := sql.GetOrmQuearyable(ctx, tx)
+	var legacy []NotificationTemplate
+	if err := db.Where("current_version = 0 OR current_version IS NULL").Find(&legacy).Error; err != nil {
+		return err
+	}
+	for _, template := range legacy {
+		// a previous run may have stopped between the two writes
+		_, err := GetTemplateVersion(ctx, db, template.ID, 1)
+		if gorm.IsRecordNotFoundError(err) {
+			_, err = NewTemplateVersionEntry(ctx, db, template.ID, 1, template.Name, template.Category, template.Subject, template.Content, template.ChannelContent)
+		}
+		if err != nil {
+			return err
+		}
+		if err := db.Model(&NotificationTemplate{}).Where("id = ?", template.ID).
+			UpdateColumn("current_version", 1).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func GetAllTemplates(ctx context.Context, tx interface{}, limit, offset int) ([]NotificationTemplate, error) {
 	var templates []NotificationTemplate
 	db := sql.GetOrmQuearyable(ctx, tx)
@@ -0,0 +1,156 @@
+package templates
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"strings"
+	texttemplate "text/template"
+
+	"github.com/kp/pager/common"
+	"github.com/kp/pager/suppression"
+)
+
+// RenderedTemplate is a template personalized for a single recipient on one
+// channel. Subject holds the email subject or the push title, Body the email
+// HTML, SMS text, push body or in-app message and Text the plaintext
+// alternative of an email.
+type RenderedTemplate struct {
+	Name    string `json:"name"`
+	Channel string `json:"channel"`
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+	Text    string `json:"text,omitempty"`
+}
+
+// renderFuncs are available inside subject and content, e.g.
+// {{default "there" .first_name}} or {{range split .items ","}}{{.}}{{end}}
+var renderFuncs = map[string]any{
+	"default": func(fallback, value string) string {
+		if strings.TrimSpace(value) == "" {
+			return fallback
+		}
+		return value
+	},
+	"split": func(value, sep string) []string {
+		if value == "" {
+			return nil
+		}
+		return strings.Split(value, sep)
+	},
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	"trim":  strings.TrimSpace,
+}
+
+// templatePart is one text of a template rendered for a channel, into is
+// the field of RenderedTemplate receiving the result
+type templatePart struct {
+	name string
+	text string
+	html bool
+	into func(*RenderedTemplate) *string
+}
+
+// parse parses the part as HTML or plain text, missing keys render as empty strings
+func (p templatePart) parse() (interface {
+	Execute(w io.Writer, data any) error
+}, error) {
+	if p.html {
+		return htmltemplate.New(p.name).Funcs(renderFuncs).Option("missingkey=zero").Parse(p.text)
+	}
+	return texttemplate.New(p.name).Funcs(renderFuncs).Option("missingkey=zero").Parse(p.text)
+}
+
+func renderedSubject(r *RenderedTemplate) *string { return &r.Subject }
+func renderedBody(r *RenderedTemplate) *string    { return &r.Body }
+func renderedText(r *RenderedTemplate) *string    { return &r.Text }
+
+// channelParts returns the texts of template delivered on channel
+func channelParts(template *Template, channel string) ([]templatePart, error) {
+	if !template.Supports(channel) {
+		return nil, fmt.Errorf("%w: template %d has no %s content", ErrUnsupportedChannel, template.ID, channel)
+	}
+	switch channel {
+	case common.ChannelSMS:
+		return []templatePart{{name: "sms_text", text: template.SMSText, into: renderedBody}}, nil
+	case common.ChannelPush:
+		return []templatePart{
+			{name: "push_title", text: template.PushTitle, into: renderedSubject},
+			{name: "push_body", text: template.PushBody, into: renderedBody},
+		}, nil
+	case common.ChannelInApp:
+		return []templatePart{{name: "in_app_content", text: template.InAppContent, into: renderedBody}}, nil
+	default:
+		parts := []templatePart{
+			{name: "subject", text: template.Subject, into: renderedSubject},
+			{name: "content", text: template.Content, html: true, into: renderedBody},
+		}
+		if template.TextContent != "" {
+			parts = append(parts, templatePart{name: "text_content", text: template.TextContent, into: renderedText})
+		}
+		return parts, nil
+	}
+}
+
+// Render executes the template subject and content against the recipient
+// context. The subject is rendered as plain text while the content is
+// rendered as HTML, so context values are escaped in the body.
+// Missing context keys render as empty strings.
+func Render(template *Template, data map[string]string) (*RenderedTemplate, error) {
+	return RenderChannel(template, common.ChannelEmail, data)
+}
+
+// RenderChannel executes the texts of template for channel against the
+// recipient context like Render. Only the email content is rendered as HTML,
+// and SMS text longer than MaxSMSSegments is rejected.
+func RenderChannel(template *Template, channel string, data map[string]string) (*RenderedTemplate, error) {
+	if data == nil {
+		data = map[string]string{}
+	}
+	parts, err := channelParts(template, channel)
+	if err != nil {
+		return nil, err
+	}
+
+	rendered := &RenderedTemplate{Name: template.Name, Channel: channel}
+	for _, part := range parts {
+		tmpl, err := part.parse()
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s in template %d: %v", part.name, template.ID, err)
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, data); err != nil {
+			return nil, fmt.Errorf("failed to render %s of template %d: %v", part.name, template.ID, err)
+		}
+		*part.into(rendered) = buf.String()
+	}
+
+	if channel == common.ChannelSMS {
+		if segments := SMSSegments(rendered.Body); segments > MaxSMSSegments {
+			return nil, fmt.Errorf("sms text of template %d needs %d segments, at most %d are allowed", template.ID, segments, MaxSMSSegments)
+		}
+	}
+	return rendered, nil
+}
+
+// RenderData returns the data a template is rendered with for recipient: the
+// audience context and the unsubscribe link of recipient from category, e.g.
+// <a href="{{.unsubscribe_url}}">. The link is left out when unsubscribe
+// links are not configured.
+func RenderData(context map[string]string, recipient, category string) map[string]string {
+	if category == "" {
+		category = DefaultCategory
+	}
+	unsubscribeURL := suppression.UnsubscribeURL(recipient, category)
+	if unsubscribeURL == "" {
+		return context
+	}
+	data := make(map[string]string, len(context)+1)
+	for key, value := range context {
+		data[key] = value
+	}
+	data[UnsubscribeURLContextKey] = unsubscribeURL
+	return data
+}
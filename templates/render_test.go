@@ -0,0 +1,167 @@
+package templates
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kp/pager/common"
+	"github.com/kp/pager/suppression"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name        string
+		template    Template
+		context     map[string]string
+		wantSubject string
+		wantBody    string
+	}{
+		{
+			name:        "variables",
+			template:    Template{Subject: "Hi {{.name}}", Content: "<p>Welcome {{.name}}</p>"},
+			context:     map[string]string{"name": "John"},
+			wantSubject: "Hi John",
+			wantBody:    "<p>Welcome John</p>",
+		},
+		{
+			name:        "default value for missing key",
+			template:    Template{Subject: "Hi {{default \"there\" .name}}", Content: "{{.missing}}done"},
+			context:     nil,
+			wantSubject: "Hi there",
+			wantBody:    "done",
+		},
+		{
+			name:        "conditional",
+			template:    Template{Subject: "Order", Content: "{{if eq .tier \"gold\"}}Free shipping{{else}}Standard{{end}}"},
+			context:     map[string]string{"tier": "gold"},
+			wantSubject: "Order",
+			wantBody:    "Free shipping",
+		},
+		{
+			name:        "loop",
+			template:    Template{Subject: "Items", Content: "{{range split .items \",\"}}<li>{{.}}</li>{{end}}"},
+			context:     map[string]string{"items": "a,b"},
+			wantSubject: "Items",
+			wantBody:    "<li>a</li><li>b</li>",
+		},
+		{
+			name:        "body escapes html from context",
+			template:    Template{Subject: "{{.name}}", Content: "<p>{{.name}}</p>"},
+			context:     map[string]string{"name": "<b>Tom & Jerry</b>"},
+			wantSubject: "<b>Tom & Jerry</b>",
+			wantBody:    "<p>&lt;b&gt;Tom &amp; Jerry&lt;/b&gt;</p>",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rendered, err := Render(&tt.template, tt.context)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantSubject, rendered.Subject)
+			assert.Equal(t, tt.wantBody, rendered.Body)
+		})
+	}
+}
+
+func TestRender_Errors(t *testing.T) {
+	tests := []struct {
+		name     string
+		template Template
+		errMsg   string
+	}{
+		{
+			name:     "invalid subject",
+			template: Template{ID: 7, Subject: "Hi {{.name", Content: "body"},
+			errMsg:   "invalid subject in template 7",
+		},
+		{
+			name:     "invalid content",
+			template: Template{ID: 7, Subject: "Hi", Content: "{{if .name}}"},
+			errMsg:   "invalid content in template 7",
+		},
+		{
+			name:     "execution error",
+			template: Template{ID: 7, Subject: "Hi", Content: "{{index .name 5}}"},
+			errMsg:   "failed to render content of template 7",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Render(&tt.template, map[string]string{"name": "x"})
+			assert.Error(t, err)
+			assert.Contains(t, err.Error(), tt.errMsg)
+		})
+	}
+}
+
+func TestRenderChannel(t *testing.T) {
+	template := &Template{
+		ID:      3,
+		Name:    "order",
+		Subject: "Order {{.order}}",
+		Content: "<p>Hi {{.name}}</p>",
+		ChannelContent: ChannelContent{
+			TextContent:  "Hi {{.name}}",
+			SMSText:      "Order {{.order}} shipped",
+			PushTitle:    "Order {{.order}}",
+			PushBody:     "It is on its way, {{.name}}",
+			InAppContent: "Track order {{.order}}",
+		},
+	}
+	data := map[string]string{"name": "<Ann>", "order": "42"}
+
+	tests := []struct {
+		channel     string
+		wantSubject string
+		wantBody    string
+		wantText    string
+	}{
+		{channel: common.ChannelEmail, wantSubject: "Order 42", wantBody: "<p>Hi &lt;Ann&gt;</p>", wantText: "Hi <Ann>"},
+		{channel: common.ChannelSMS, wantBody: "Order 42 shipped"},
+		{channel: common.ChannelPush, wantSubject: "Order 42", wantBody: "It is on its way, <Ann>"},
+		{channel: common.ChannelInApp, wantBody: "Track order 42"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.channel, func(t *testing.T) {
+			rendered, err := RenderChannel(template, tt.channel, data)
+			require.NoError(t, err)
+			assert.Equal(t, tt.channel, rendered.Channel)
+			assert.Equal(t, tt.wantSubject, rendered.Subject)
+			assert.Equal(t, tt.wantBody, rendered.Body)
+			assert.Equal(t, tt.wantText, rendered.Text)
+		})
+	}
+}
+
+func TestRenderChannel_Errors(t *testing.T) {
+	_, err := RenderChannel(&Template{ID: 3, Content: "body"}, common.ChannelPush, nil)
+	assert.ErrorIs(t, err, ErrUnsupportedChannel)
+
+	template := &Template{ID: 3, Content: "body", ChannelContent: ChannelContent{SMSText: "{{.long}}"}}
+	_, err = RenderChannel(template, common.ChannelSMS, map[string]string{"long": strings.Repeat("a", 2000)})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "segments")
+}
+
+func TestRenderData(t *testing.T) {
+	context := map[string]string{"name": "Ada"}
+	assert.Equal(t, context, RenderData(context, "ada@example.com", "marketing"), "links are not configured")
+
+	suppression.Init(suppression.SuppressionConfig{Secret: "secret", BaseURL: "https://pager.example.com/"})
+	t.Cleanup(func() { suppression.Init(suppression.SuppressionConfig{}) })
+
+	data := RenderData(context, "ada@example.com", "")
+	assert.Equal(t, "Ada", data["name"])
+	assert.Equal(t, suppression.UnsubscribeURL("ada@example.com", DefaultCategory), data[UnsubscribeURLContextKey])
+	assert.NotContains(t, context, UnsubscribeURLContextKey, "the audience context is not changed")
+
+	// previews render the link the same way as sends
+	template := &Template{Content: `<a href="{{.unsubscribe_url}}">Unsubscribe</a>`}
+	rendered, err := RenderChannel(template, common.ChannelEmail, data)
+	require.NoError(t, err)
+	assert.Contains(t, rendered.Body, "https://pager.example.com/pager/v1/unsubscribe/?token=")
+}
@@ -2,75 +2,382 @@ package templates
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
+	"sort"
 	"time"
 
 	"github.com/jinzhu/gorm"
+	"github.com/kp/pager/common"
+	"github.com/kp/pager/databases/sql"
 )
 
 type Template struct {
-	ID        int64     `gorm:"primaryKey"`
-	Name      string    `gorm:"unique;not null"`
-	Subject   string    `gorm:"not null"`
-	Content   string    `gorm:"not null"`
-	CreatedAt time.Time `gorm:"column:created_at"`
-	UpdatedAt time.Time `gorm:"column:updated_at"`
+	ID   int64  `gorm:"primaryKey"`
+	Name string `gorm:"unique;not null"`
+	// Category scopes unsubscribes, see DefaultCategory
+	Category string `gorm:"-"`
+	Subject  string `gorm:"not null"`
+	Content  string `gorm:"not null"`
+	ChannelContent
+	Version int `gorm:"-"`
+	// Locale is set when the content is a locale variant, see Localize
+	Locale    string            `gorm:"-"`
+	Variants  []TemplateVariant `gorm:"-"`
+	CreatedAt time.Time         `gorm:"column:created_at"`
+	UpdatedAt time.Time         `gorm:"column:updated_at"`
 }
 
 type templateManager struct {
 	db *gorm.DB
 }
 
-func (s *templateManager) CreateTemplate(ctx context.Context, name, subject, content string) (*Template, error) {
-	template, err := NewTemplateEntry(ctx, nil, name, subject, content)
+func (s *templateManager) CreateTemplate(ctx context.Context, name, category, subject, content string, channels ChannelContent) (*Template, error) {
+	if err := channels.validate(); err != nil {
+		return nil, err
+	}
+	category = normalizeCategory(category)
+	var template *NotificationTemplate
+	err := s.inTransaction("createTemplate", func(tx *gorm.DB) error {
+		var err error
+		template, err = NewTemplateEntry(ctx, tx, name, category, subject, content, channels)
+		if err != nil {
+			return err
+		}
+		_, err = NewTemplateVersionEntry(ctx, tx, template.ID, template.CurrentVersion, name, category, subject, content, channels)
+		return err
+	})
 	if err != nil {
 		slog.Error("createTemplate:unableToCreateTemplate", slog.Any("error", err))
 		return nil, err
 	}
-	return &Template{
-		ID:        template.ID,
-		Name:      template.Name,
-		Subject:   template.Subject,
-		Content:   template.Content,
-		CreatedAt: template.CreatedAt,
-		UpdatedAt: template.UpdatedAt,
-	}, nil
+	result := toTemplate(template)
+	result.Variants = []TemplateVariant{}
+	return result, nil
 }
 
-func (s *templateManager) UpdateTemplate(ctx context.Context, id int64, name, subject, content string) (*Template, error) {
-	template := &NotificationTemplate{
-		ID:        int64(id),
-		Name:      name,
-		Subject:   subject,
-		Content:   content,
-		UpdatedAt: time.Now(),
+// templateSnapshot is the complete content of one template version
+type templateSnapshot struct {
+	name     string
+	category string
+	subject  string
+	content  string
+	channels ChannelContent
+	variants []TemplateVariant
+}
+
+// UpdateTemplate stores the new content as the next version of the template,
+// the locale variants are carried over unchanged
+func (s *templateManager) UpdateTemplate(ctx context.Context, id int64, name, category, subject, content string, channels ChannelContent) (*Template, error) {
+	if err := channels.validate(); err != nil {
+		return nil, err
 	}
-	err := template.Save(ctx, nil)
+	category = normalizeCategory(category)
+	return s.addVersion(ctx, id, func(_ *NotificationTemplate, current templateSnapshot) (templateSnapshot, error) {
+		current.name, current.category = name, category
+		current.subject, current.content, current.channels = subject, content, channels
+		return current, nil
+	})
+}
+
+// RollbackTemplate restores the content and variants of version as a new
+// version, so the history stays append-only
+func (s *templateManager) RollbackTemplate(ctx context.Context, id int64, version int) (*Template, error) {
+	return s.addVersion(ctx, id, func(template *NotificationTemplate, _ templateSnapshot) (templateSnapshot, error) {
+		if version == template.CurrentVersion {
+			return templateSnapshot{}, fmt.Errorf("%w: version %d is already current", ErrInvalidVersion, version)
+		}
+		target, err := GetTemplateVersion(ctx, s.db, id, version)
+		if err != nil {
+			return templateSnapshot{}, err
+		}
+		variants, err := GetTemplateVariants(ctx, s.db, id, version)
+		if err != nil {
+			return templateSnapshot{}, err
+		}
+		return templateSnapshot{
+			name:     target.Name,
+			category: target.Category,
+			subject:  target.Subject,
+			content:  target.Content,
+			channels: target.ChannelContent,
+			variants: toTemplateVariants(variants),
+		}, nil
+	})
+}
+
+// PutTemplateVariant adds or replaces the variant for locale as a new version
+func (s *templateManager) PutTemplateVariant(ctx context.Context, id int64, locale, subject, content string, channels ChannelContent) (*Template, error) {
+	locale, err := NormalizeLocale(locale)
 	if err != nil {
 		return nil, err
 	}
-	return &Template{
-		ID:        template.ID,
-		Name:      template.Name,
-		Subject:   template.Subject,
-		Content:   template.Content,
-		CreatedAt: template.CreatedAt,
-		UpdatedAt: template.UpdatedAt,
-	}, nil
+	if err := channels.validate(); err != nil {
+		return nil, err
+	}
+	return s.addVersion(ctx, id, func(_ *NotificationTemplate, current templateSnapshot) (templateSnapshot, error) {
+		variants := make([]TemplateVariant, 0, len(current.variants)+1)
+		for _, variant := range current.variants {
+			if variant.Locale != locale {
+				variants = append(variants, variant)
+			}
+		}
+		current.variants = append(variants, TemplateVariant{
+			Locale:         locale,
+			Subject:        subject,
+			Content:        content,
+			ChannelContent: channels,
+		})
+		return current, nil
+	})
+}
+
+// DeleteTemplateVariant removes the variant for locale as a new version
+func (s *templateManager) DeleteTemplateVariant(ctx context.Context, id int64, locale string) (*Template, error) {
+	locale, err := NormalizeLocale(locale)
+	if err != nil {
+		return nil, err
+	}
+	return s.addVersion(ctx, id, func(_ *NotificationTemplate, current templateSnapshot) (templateSnapshot, error) {
+		variants := make([]TemplateVariant, 0, len(current.variants))
+		for _, variant := range current.variants {
+			if variant.Locale != locale {
+				variants = append(variants, variant)
+			}
+		}
+		if len(variants) == len(current.variants) {
+			return templateSnapshot{}, fmt.Errorf("variant %s: %w", locale, gorm.ErrRecordNotFound)
+		}
+		current.variants = variants
+		return current, nil
+	})
 }
 
+func (s *templateManager) ListTemplateVariants(ctx context.Context, id int64) ([]TemplateVariant, error) {
+	template, err := GetTemplateByID(ctx, nil, id)
+	if err != nil {
+		return nil, err
+	}
+	variants, err := GetTemplateVariants(ctx, nil, id, template.CurrentVersion)
+	if err != nil {
+		return nil, err
+	}
+	return toTemplateVariants(variants), nil
+}
+
+// addVersion locks the template and appends the snapshot returned by next,
+// which receives the snapshot of the current version
+func (s *templateManager) addVersion(ctx context.Context, id int64, next func(*NotificationTemplate, templateSnapshot) (templateSnapshot, error)) (*Template, error) {
+	var template *NotificationTemplate
+	var snapshot templateSnapshot
+	err := s.inTransaction("addTemplateVersion", func(tx *gorm.DB) error {
+		var err error
+		template, err = GetTemplateByIDForUpdate(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		variants, err := GetTemplateVariants(ctx, tx, id, template.CurrentVersion)
+		if err != nil {
+			return err
+		}
+		snapshot, err = next(template, templateSnapshot{
+			name:     template.Name,
+			category: template.Category,
+			subject:  template.Subject,
+			content:  template.Content,
+			channels: template.ChannelContent,
+			variants: toTemplateVariants(variants),
+		})
+		if err != nil {
+			return err
+		}
+
+		version := template.CurrentVersion + 1
+		if _, err := NewTemplateVersionEntry(ctx, tx, id, version, snapshot.name, snapshot.category, snapshot.subject, snapshot.content, snapshot.channels); err != nil {
+			return err
+		}
+		for _, variant := range snapshot.variants {
+			if _, err := NewTemplateVariantEntry(ctx, tx, id, version, variant.Locale, variant.Subject, variant.Content, variant.ChannelContent); err != nil {
+				return err
+			}
+		}
+		if err := UpdateTemplateContent(ctx, tx, id, snapshot.name, snapshot.category, snapshot.subject, snapshot.content, snapshot.channels, version); err != nil {
+			return err
+		}
+		template.Name = snapshot.name
+		template.Category = snapshot.category
+		template.Subject = snapshot.subject
+		template.Content = snapshot.content
+		template.ChannelContent = snapshot.channels
+		template.CurrentVersion = version
+		template.UpdatedAt = time.Now()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	result := toTemplate(template)
+	result.Variants = snapshot.variants
+	return result, nil
+}
+
+// GetTemplate returns the current version of the template with its locale variants
 func (s *templateManager) GetTemplate(ctx context.Context, id int64) (*Template, error) {
 	template, err := GetTemplateByID(ctx, nil, int64(id))
 	if err != nil {
 		return nil, err
 	}
+	variants, err := GetTemplateVariants(ctx, nil, id, template.CurrentVersion)
+	if err != nil {
+		return nil, err
+	}
+	result := toTemplate(template)
+	result.Variants = toTemplateVariants(variants)
+	return result, nil
+}
+
+// GetTemplateVersion returns the template with the content and locale variants of version
+func (s *templateManager) GetTemplateVersion(ctx context.Context, id int64, version int) (*Template, error) {
+	template, err := GetTemplateByID(ctx, nil, id)
+	if err != nil {
+		return nil, err
+	}
+	snapshot, err := GetTemplateVersion(ctx, nil, id, version)
+	if err != nil {
+		return nil, err
+	}
+	variants, err := GetTemplateVariants(ctx, nil, id, version)
+	if err != nil {
+		return nil, err
+	}
 	return &Template{
-		ID:        template.ID,
-		Name:      template.Name,
-		Subject:   template.Subject,
-		Content:   template.Content,
-		CreatedAt: template.CreatedAt,
-		UpdatedAt: template.UpdatedAt,
+		ID:             template.ID,
+		Name:           snapshot.Name,
+		Category:       snapshot.Category,
+		Subject:        snapshot.Subject,
+		Content:        snapshot.Content,
+		ChannelContent: snapshot.ChannelContent,
+		Version:        snapshot.Version,
+		Variants:       toTemplateVariants(variants),
+		CreatedAt:      template.CreatedAt,
+		UpdatedAt:      snapshot.CreatedAt,
+	}, nil
+}
+
+// previewRecipient receives the unsubscribe link of previews without a recipient
+const previewRecipient = "recipient@example.com"
+
+// PreviewTemplate renders version of the template for channel, or the
+// current version when version is 0, the same way recipient receives it.
+// Nothing is persisted.
+func (s *templateManager) PreviewTemplate(ctx context.Context, id int64, version int, channel, recipient string, data map[string]string) (*TemplatePreview, error) {
+	if channel == "" {
+		channel = common.ChannelEmail
+	}
+	if recipient == "" {
+		recipient = previewRecipient
+	}
+	var template *Template
+	var err error
+	if version > 0 {
+		template, err = s.GetTemplateVersion(ctx, id, version)
+	} else {
+		template, err = s.GetTemplate(ctx, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// select the variant the way the communicator does for a recipient
+	template = template.Localize(data[LocaleContextKey])
+	if !template.Supports(channel) {
+		return nil, fmt.Errorf("%w: template %d has no %s content", ErrUnsupportedChannel, id, channel)
+	}
+	rendered, err := RenderChannel(template, channel, RenderData(data, recipient, template.Category))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidTemplate, err)
+	}
+	variables, err := Variables(template, channel)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidTemplate, err)
+	}
+
+	preview := &TemplatePreview{
+		TemplateID:       template.ID,
+		Version:          template.Version,
+		Locale:           template.Locale,
+		Channel:          channel,
+		Subject:          rendered.Subject,
+		Body:             rendered.Body,
+		Text:             rendered.Text,
+		MissingVariables: []string{},
+		UnusedVariables:  []string{},
+	}
+	used := map[string]bool{LocaleContextKey: true}
+	for _, variable := range variables {
+		used[variable] = true
+		if _, ok := data[variable]; !ok && variable != UnsubscribeURLContextKey {
+			preview.MissingVariables = append(preview.MissingVariables, variable)
+		}
+	}
+	for key := range data {
+		if !used[key] {
+			preview.UnusedVariables = append(preview.UnusedVariables, key)
+		}
+	}
+	sort.Strings(preview.UnusedVariables)
+	if channel == common.ChannelSMS {
+		preview.SMSSegments = SMSSegments(rendered.Body)
+	}
+	return preview, nil
+}
+
+func (s *templateManager) ListTemplateVersions(ctx context.Context, id int64) ([]TemplateVersion, error) {
+	if _, err := GetTemplateByID(ctx, nil, id); err != nil {
+		return nil, err
+	}
+	entries, err := GetTemplateVersions(ctx, nil, id)
+	if err != nil {
+		return nil, err
+	}
+	versions := make([]TemplateVersion, 0, len(entries))
+	for _, entry := range entries {
+		versions = append(versions, toTemplateVersion(entry))
+	}
+	return versions, nil
+}
+
+func (s *templateManager) DiffTemplateVersions(ctx context.Context, id int64, from, to int) (*TemplateDiff, error) {
+	fromVersion, err := GetTemplateVersion(ctx, nil, id, from)
+	if err != nil {
+		return nil, err
+	}
+	toVersion, err := GetTemplateVersion(ctx, nil, id, to)
+	if err != nil {
+		return nil, err
+	}
+	fromVariants, err := GetTemplateVariants(ctx, nil, id, from)
+	if err != nil {
+		return nil, err
+	}
+	toVariants, err := GetTemplateVariants(ctx, nil, id, to)
+	if err != nil {
+		return nil, err
+	}
+	return &TemplateDiff{
+		TemplateID:   id,
+		From:         from,
+		To:           to,
+		Name:         diffLines(fromVersion.Name, toVersion.Name),
+		Category:     diffChanged(fromVersion.Category, toVersion.Category),
+		Subject:      diffLines(fromVersion.Subject, toVersion.Subject),
+		Content:      diffLines(fromVersion.Content, toVersion.Content),
+		TextContent:  diffLines(fromVersion.TextContent, toVersion.TextContent),
+		SMSText:      diffLines(fromVersion.SMSText, toVersion.SMSText),
+		PushTitle:    diffLines(fromVersion.PushTitle, toVersion.PushTitle),
+		PushBody:     diffLines(fromVersion.PushBody, toVersion.PushBody),
+		InAppContent: diffLines(fromVersion.InAppContent, toVersion.InAppContent),
+		Variants:     diffVariants(fromVariants, toVariants),
 	}, nil
 }
 
@@ -81,14 +388,60 @@ func (s *templateManager) GetAllTemplates(ctx context.Context) ([]Template, erro
 	}
 	var templates []Template
 	for _, t := range notificationTemplates {
-		templates = append(templates, Template{
-			ID:        t.ID,
-			Name:      t.Name,
-			Subject:   t.Subject,
-			Content:   t.Content,
-			CreatedAt: t.CreatedAt,
-			UpdatedAt: t.UpdatedAt,
-		})
+		templates = append(templates, *toTemplate(&t))
 	}
 	return templates, nil
 }
+
+// inTransaction runs fn in a transaction which is rolled back when fn fails
+func (s *templateManager) inTransaction(function string, fn func(tx *gorm.DB) error) error {
+	tx := s.db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if err := fn(tx); err != nil {
+		sql.TxRollBack(tx, function)
+		return err
+	}
+	return tx.Commit().Error
+}
+
+func toTemplate(template *NotificationTemplate) *Template {
+	return &Template{
+		ID:             template.ID,
+		Name:           template.Name,
+		Category:       template.Category,
+		Subject:        template.Subject,
+		Content:        template.Content,
+		ChannelContent: template.ChannelContent,
+		Version:        template.CurrentVersion,
+		CreatedAt:      template.CreatedAt,
+		UpdatedAt:      template.UpdatedAt,
+	}
+}
+
+func toTemplateVersion(entry NotificationTemplateVersion) TemplateVersion {
+	return TemplateVersion{
+		TemplateID:     entry.TemplateID,
+		Version:        entry.Version,
+		Name:           entry.Name,
+		Category:       entry.Category,
+		Subject:        entry.Subject,
+		Content:        entry.Content,
+		ChannelContent: entry.ChannelContent,
+		CreatedAt:      entry.CreatedAt,
+	}
+}
+
+func toTemplateVariants(entries []NotificationTemplateVariant) []TemplateVariant {
+	variants := make([]TemplateVariant, 0, len(entries))
+	for _, entry := range entries {
+		variants = append(variants, TemplateVariant{
+			Locale:         entry.Locale,
+			Subject:        entry.Subject,
+			Content:        entry.Content,
+			ChannelContent: entry.ChannelContent,
+		})
+	}
+	return variants
+}
@@ -2,28 +2,162 @@ package templates
 
 import (
 	"context"
+	"errors"
+	"strings"
+	"time"
 
 	"github.com/jinzhu/gorm"
 	"github.com/kp/pager/common"
 )
 
 type TemplateService interface {
-	CreateTemplate(ctx context.Context, name, subject, content string) (*Template, error)
-	UpdateTemplate(ctx context.Context, id int64, name, subject, content string) (*Template, error)
+	CreateTemplate(ctx context.Context, name, category, subject, content string, channels ChannelContent) (*Template, error)
+	UpdateTemplate(ctx context.Context, id int64, name, category, subject, content string, channels ChannelContent) (*Template, error)
 	GetTemplate(ctx context.Context, id int64) (*Template, error)
 	GetAllTemplates(ctx context.Context) ([]Template, error)
+	GetTemplateVersion(ctx context.Context, id int64, version int) (*Template, error)
+	ListTemplateVersions(ctx context.Context, id int64) ([]TemplateVersion, error)
+	DiffTemplateVersions(ctx context.Context, id int64, from, to int) (*TemplateDiff, error)
+	RollbackTemplate(ctx context.Context, id int64, version int) (*Template, error)
+	PreviewTemplate(ctx context.Context, id int64, version int, channel, recipient string, data map[string]string) (*TemplatePreview, error)
+	ListTemplateVariants(ctx context.Context, id int64) ([]TemplateVariant, error)
+	PutTemplateVariant(ctx context.Context, id int64, locale, subject, content string, channels ChannelContent) (*Template, error)
+	DeleteTemplateVariant(ctx context.Context, id int64, locale string) (*Template, error)
 }
 
+// ErrInvalidVersion is returned for a rollback which would not change the template
+var ErrInvalidVersion = errors.New("invalid template version")
+
+// ErrInvalidTemplate is returned when a template cannot be rendered
+var ErrInvalidTemplate = errors.New("invalid template")
+
+// ErrInvalidLocale is returned for a variant locale which is not a BCP 47 tag
+var ErrInvalidLocale = errors.New("invalid locale")
+
+// ErrUnsupportedChannel is returned for a channel the template has no content for
+var ErrUnsupportedChannel = errors.New("unsupported channel")
+
+// DefaultCategory is the category of templates created without one
+const DefaultCategory = "general"
+
 func NewTemplateService(db *gorm.DB) TemplateService {
 	return &templateManager{db: db}
 }
 
+// TemplateRequest carries the email subject and HTML content, the other
+// channels are optional
 type TemplateRequest struct {
-	Name    string `json:"name" binding:"required"`
+	Name string `json:"name" binding:"required"`
+	// Category defaults to DefaultCategory
+	Category string `json:"category"`
+	Subject  string `json:"subject" binding:"required"`
+	Content  string `json:"content" binding:"required"`
+	ChannelContent
+}
+
+type RollbackRequest struct {
+	Version int `json:"version" binding:"required,min=1"`
+}
+
+// TemplateVariant is the content of a template in one locale, channels the
+// variant leaves empty fall back to the default content
+type TemplateVariant struct {
+	Locale  string `json:"locale"`
+	Subject string `json:"subject"`
+	Content string `json:"content"`
+	ChannelContent
+}
+
+type TemplateVariantRequest struct {
 	Subject string `json:"subject" binding:"required"`
 	Content string `json:"content" binding:"required"`
+	ChannelContent
+}
+
+// PreviewRequest renders the template for Channel against Context, Channel
+// defaults to email and Version to the current version. The locale key of
+// Context selects the variant.
+type PreviewRequest struct {
+	Context map[string]string `json:"context"`
+	Version int               `json:"version" binding:"omitempty,min=1"`
+	Channel string            `json:"channel"`
+	// Recipient is the address the unsubscribe link is signed for
+	Recipient string `json:"recipient"`
+}
+
+// TemplatePreview is a rendered template together with the context keys the
+// template reads but were not given, and the given keys it never reads
+type TemplatePreview struct {
+	TemplateID       int64    `json:"template_id"`
+	Version          int      `json:"version"`
+	Locale           string   `json:"locale"`
+	Channel          string   `json:"channel"`
+	Subject          string   `json:"subject"`
+	Body             string   `json:"body"`
+	Text             string   `json:"text,omitempty"`
+	SMSSegments      int      `json:"sms_segments,omitempty"`
+	MissingVariables []string `json:"missing_variables"`
+	UnusedVariables  []string `json:"unused_variables"`
+}
+
+type TemplateVersion struct {
+	TemplateID int64  `json:"template_id"`
+	Version    int    `json:"version"`
+	Name       string `json:"name"`
+	Category   string `json:"category"`
+	Subject    string `json:"subject"`
+	Content    string `json:"content"`
+	ChannelContent
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// DiffLine is one line of a diff, Op is one of DiffEqual, DiffInsert or DiffDelete
+type DiffLine struct {
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+type TemplateDiff struct {
+	TemplateID   int64      `json:"template_id"`
+	From         int        `json:"from"`
+	To           int        `json:"to"`
+	Name         []DiffLine `json:"name"`
+	Category     []DiffLine `json:"category,omitempty"`
+	Subject      []DiffLine `json:"subject"`
+	Content      []DiffLine `json:"content"`
+	TextContent  []DiffLine `json:"text_content"`
+	SMSText      []DiffLine `json:"sms_text"`
+	PushTitle    []DiffLine `json:"push_title"`
+	PushBody     []DiffLine `json:"push_body"`
+	InAppContent []DiffLine `json:"in_app_content"`
+	// Variants lists the locales of either version ordered by locale
+	Variants []VariantDiff `json:"variants"`
+}
+
+// VariantDiff is the change of one locale variant between two versions, Op is
+// insert for a locale only To has, delete for one only From has and equal
+// for one both have
+type VariantDiff struct {
+	Locale       string     `json:"locale"`
+	Op           string     `json:"op"`
+	Subject      []DiffLine `json:"subject"`
+	Content      []DiffLine `json:"content"`
+	TextContent  []DiffLine `json:"text_content"`
+	SMSText      []DiffLine `json:"sms_text"`
+	PushTitle    []DiffLine `json:"push_title"`
+	PushBody     []DiffLine `json:"push_body"`
+	InAppContent []DiffLine `json:"in_app_content"`
 }
 
 var TemplateResponse struct {
 	common.Response
 }
+
+// normalizeCategory lowercases category, defaulting to DefaultCategory
+func normalizeCategory(category string) string {
+	category = strings.ToLower(strings.TrimSpace(category))
+	if category == "" {
+		return DefaultCategory
+	}
+	return category
+}
@@ -0,0 +1,95 @@
+package templates
+
+import (
+	"fmt"
+	"sort"
+	texttemplate "text/template"
+	"text/template/parse"
+)
+
+// Variables returns the sorted context keys referenced by the texts of the
+// template delivered on channel, such as first_name for {{.first_name}}.
+// Fields read inside range and with blocks are skipped since dot no longer
+// refers to the context there, unless they are read through $.
+func Variables(template *Template, channel string) ([]string, error) {
+	parts, err := channelParts(template, channel)
+	if err != nil {
+		return nil, err
+	}
+	keys := map[string]bool{}
+	for _, part := range parts {
+		tree, err := texttemplate.New(part.name).Funcs(renderFuncs).Parse(part.text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s in template %d: %v", part.name, template.ID, err)
+		}
+		if tree.Tree != nil {
+			collectVariables(tree.Tree.Root, true, keys)
+		}
+	}
+
+	variables := make([]string, 0, len(keys))
+	for key := range keys {
+		variables = append(variables, key)
+	}
+	sort.Strings(variables)
+	return variables, nil
+}
+
+// collectVariables walks node, rooted tells whether dot is the render context
+func collectVariables(node parse.Node, rooted bool, keys map[string]bool) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			collectVariables(child, rooted, keys)
+		}
+	case *parse.ActionNode:
+		collectVariables(n.Pipe, rooted, keys)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			collectVariables(cmd, rooted, keys)
+		}
+	case *parse.CommandNode:
+		// {{index . "first name"}} reads a key which is not a valid identifier
+		if rooted && len(n.Args) == 3 {
+			ident, isIdent := n.Args[0].(*parse.IdentifierNode)
+			_, isDot := n.Args[1].(*parse.DotNode)
+			key, isString := n.Args[2].(*parse.StringNode)
+			if isIdent && ident.Ident == "index" && isDot && isString {
+				keys[key.Text] = true
+			}
+		}
+		for _, arg := range n.Args {
+			collectVariables(arg, rooted, keys)
+		}
+	case *parse.FieldNode:
+		if rooted {
+			keys[n.Ident[0]] = true
+		}
+	case *parse.VariableNode:
+		if n.Ident[0] == "$" && len(n.Ident) > 1 {
+			keys[n.Ident[1]] = true
+		}
+	case *parse.ChainNode:
+		collectVariables(n.Node, rooted, keys)
+	case *parse.IfNode:
+		collectVariables(n.Pipe, rooted, keys)
+		collectVariables(n.List, rooted, keys)
+		collectVariables(n.ElseList, rooted, keys)
+	case *parse.RangeNode:
+		collectVariables(n.Pipe, rooted, keys)
+		collectVariables(n.List, false, keys)
+		collectVariables(n.ElseList, rooted, keys)
+	case *parse.WithNode:
+		collectVariables(n.Pipe, rooted, keys)
+		collectVariables(n.List, false, keys)
+		collectVariables(n.ElseList, rooted, keys)
+	case *parse.TemplateNode:
+		collectVariables(n.Pipe, rooted, keys)
+	}
+}
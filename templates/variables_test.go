@@ -0,0 +1,57 @@
+package templates
+
+import (
+	"testing"
+
+	"github.com/kp/pager/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVariables(t *testing.T) {
+	tests := []struct {
+		name     string
+		template Template
+		want     []string
+	}{
+		{
+			name:     "subject and content",
+			template: Template{Subject: "Hi {{.first_name}}", Content: "<p>{{.company}} {{.first_name}}</p>"},
+			want:     []string{"company", "first_name"},
+		},
+		{
+			name:     "functions and conditions",
+			template: Template{Subject: `{{default "there" .name | upper}}`, Content: "{{if .vip}}VIP{{else}}{{.plan}}{{end}}"},
+			want:     []string{"name", "plan", "vip"},
+		},
+		{
+			name:     "range rebinds dot",
+			template: Template{Subject: "Items", Content: `{{range split .items ","}}{{.}} {{.ignored}} {{$.owner}}{{end}}`},
+			want:     []string{"items", "owner"},
+		},
+		{
+			name:     "index with string key",
+			template: Template{Subject: `{{index . "first name"}}`, Content: "body"},
+			want:     []string{"first name"},
+		},
+		{
+			name:     "no variables",
+			template: Template{Subject: "Hello", Content: "World"},
+			want:     []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			variables, err := Variables(&tt.template, common.ChannelEmail)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, variables)
+		})
+	}
+}
+
+func TestVariables_InvalidTemplate(t *testing.T) {
+	_, err := Variables(&Template{ID: 7, Subject: "Hi {{.name", Content: "body"}, common.ChannelEmail)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid subject in template 7")
+}
@@ -0,0 +1,8 @@
+package tracing
+
+// TracingConfig configures span export, Endpoint is the OTLP/HTTP url of the
+// collector such as "http://localhost:4318", tracing is disabled without it
+type TracingConfig struct {
+	Endpoint    string `json:"OTEL_EXPORTER_OTLP_ENDPOINT"`
+	ServiceName string `json:"OTEL_SERVICE_NAME"`
+}